@@ -0,0 +1,55 @@
+package govector
+
+import (
+	"log"
+
+	"github.com/vseledkin/govector/index"
+)
+
+// LoadOrBuildAnnoy returns angular Annoy index over all words stored in
+// path, the model file name with ".annoy" suffix when path is empty. The
+// file is memory mapped when its metadata matches the model checksum, word
+// count and trees, otherwise the index is built with trees trees and saved.
+func (m *Manifold) LoadOrBuildAnnoy(path string, trees int) (*index.Annoy, error) {
+	if path == "" {
+		path = m.dbfile + ".annoy"
+	}
+	idx, e := index.NewAnnoy(m.Dim(), index.Angular, SIMDKernels)
+	if e != nil {
+		return nil, e
+	}
+	idx.Key = m.IDWord
+	checksum := m.Checksum()
+
+	meta, e := readIndexMeta(path + ".meta")
+	switch {
+	case e != nil:
+		log.Printf("No valid index in %s: %s", path, e)
+	case !meta.builtFrom(m, checksum):
+		log.Printf("Index %s was built for another model", path)
+	case meta.Trees != uint32(trees):
+		log.Printf("Index %s was built with %d trees", path, int32(meta.Trees))
+	default:
+		if e = idx.Load(path); e == nil && idx.Len() == int(meta.Items) {
+			log.Printf("Loaded %s with %d items", path, idx.Len())
+			return idx, nil
+		}
+		log.Printf("Index %s does not match its metadata: %v", path, e)
+		idx.Close()
+	}
+
+	idx.Trees = trees
+	if e = m.indexWords(idx); e != nil {
+		return nil, e
+	}
+	if e = idx.Save(path); e != nil {
+		return nil, e
+	}
+	return idx, writeIndexMeta(path+".meta", indexMeta{
+		Checksum: checksum,
+		Dim:      uint32(m.Dim()),
+		Words:    m.WordCount(),
+		Items:    uint32(idx.Len()),
+		Trees:    uint32(trees),
+	})
+}
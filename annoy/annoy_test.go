@@ -80,7 +80,21 @@ func eq(a, b []int) bool {
 	return true
 }
 
+// unit scales v to unit length
+func unit(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x * x)
+	}
+	for i := range v {
+		v[i] /= float32(math.Sqrt(norm))
+	}
+	return v
+}
+
 func TestGetNnsByVector(t *testing.T) {
+	// this Annoy build ranks by acos(x^T y) / Pi, which is the angle between
+	// vectors for unit vectors only, so queries are normalized
 	index := NewAnnoyIndexAngular(3)
 	index.AddItem(0, []float32{0, 0, 1})
 	index.AddItem(1, []float32{0, 1, 0})
@@ -88,19 +102,19 @@ func TestGetNnsByVector(t *testing.T) {
 	index.Build(10)
 
 	var result []int
-	index.GetNnsByVector([]float32{3, 2, 1}, 3, -1, &result)
+	index.GetNnsByVector(unit([]float32{3, 2, 1}), 3, -1, &result)
 	if !eq(result, []int{2, 1, 0}) {
 		t.Fatalf("%#v != %#v", result, []int{2, 1, 0})
 	}
 	t.Logf("%#v == %#v", result, []int{2, 1, 0})
 
-	index.GetNnsByVector([]float32{1, 2, 3}, 3, -1, &result)
+	index.GetNnsByVector(unit([]float32{1, 2, 3}), 3, -1, &result)
 	if !eq(result, []int{0, 1, 2}) {
 		t.Fatalf("%#v != %#v", result, []int{0, 1, 2})
 	}
 	t.Logf("%#v == %#v", result, []int{0, 1, 2})
 
-	index.GetNnsByVector([]float32{2, 0, 1}, 3, -1, &result)
+	index.GetNnsByVector(unit([]float32{2, 0, 1}), 3, -1, &result)
 	if !eq(result, []int{2, 0, 1}) {
 		t.Fatalf("%#v != %#v", result, []int{2, 0, 1})
 	}
@@ -577,8 +577,8 @@ protected:
         nns.insert(nns.end(), dst, &dst[nd->n_descendants]);
       } else {
         T margin = D::margin(nd, v, _f);
-        q.push(make_pair(std::min(d, +margin), nd->children[1]));
-        q.push(make_pair(std::min(d, -margin), nd->children[0]));
+        q.push(make_pair(std::min(d, +margin), static_cast<S>(nd->children[1])));
+        q.push(make_pair(std::min(d, -margin), static_cast<S>(nd->children[0])));
       }
     }
 
@@ -0,0 +1,188 @@
+package annoy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/vseledkin/govector/index"
+)
+
+// Angular adapts the cgo Annoy angular index to index.Index. Annoy items are
+// numbered densely in the order rows are added, the mapping back to row ids is
+// saved next to the tree file with the ".ids" suffix. The angular distance of
+// this Annoy build is acos(x^T y) / Pi, which is index.Angular for normalized
+// vectors.
+//
+// index.Annoy is a pure Go replacement that needs no C++ toolchain.
+type Angular struct {
+	idx   AnnoyIndexAngular
+	ids   []int32
+	items map[int32]int
+	built bool
+	// Trees is the number of trees to build, more trees give better precision
+	Trees int
+	// SearchK is the number of nodes inspected during search, -1 means
+	// Trees * k
+	SearchK int
+	// Key labels search results, it may be nil
+	Key func(id int32) string
+}
+
+// NewAngular creates an empty index of dim dimensional vectors
+func NewAngular(dim int, key func(id int32) string) *Angular {
+	return &Angular{
+		idx:     NewAnnoyIndexAngular(dim),
+		items:   make(map[int32]int),
+		Trees:   16,
+		SearchK: -1,
+		Key:     key,
+	}
+}
+
+// Add adds vector v under row id
+func (a *Angular) Add(id int32, v []float32) error {
+	if a.built {
+		return fmt.Errorf("Can not add item %d to built index", id)
+	}
+	if _, ok := a.items[id]; ok {
+		return fmt.Errorf("Item %d is already added", id)
+	}
+	a.items[id] = len(a.ids)
+	a.idx.AddItem(len(a.ids), v)
+	a.ids = append(a.ids, id)
+	return nil
+}
+
+// Build builds a.Trees random projection trees
+func (a *Angular) Build() error {
+	a.idx.Build(a.Trees)
+	a.built = true
+	return nil
+}
+
+// Search returns up to k neighbours of v
+func (a *Angular) Search(v []float32, k int) ([]index.Neighbor, error) {
+	if !a.built {
+		return nil, fmt.Errorf("Index is not built")
+	}
+	var items []int
+	var distances []float32
+	a.idx.GetNnsByVector(v, k, a.SearchK, &items, &distances)
+	return a.neighbors(items, distances), nil
+}
+
+// SearchFilter returns up to k neighbours of v accepted by filter. Annoy
+// can not filter while it walks the trees, so the search is repeated asking
+// for four times more neighbours until k of them are accepted or all items
+// are returned.
+func (a *Angular) SearchFilter(v []float32, k int, filter index.Filter) ([]index.Neighbor, error) {
+	if filter == nil {
+		return a.Search(v, k)
+	}
+	if !a.built {
+		return nil, fmt.Errorf("Index is not built")
+	}
+	if k < 1 {
+		return nil, nil
+	}
+	for n := k; ; n *= 4 {
+		if n > len(a.ids) {
+			n = len(a.ids)
+		}
+		var items []int
+		var distances []float32
+		a.idx.GetNnsByVector(v, n, a.SearchK, &items, &distances)
+		neighbors := make([]index.Neighbor, 0, k)
+		for i, item := range items {
+			if id := a.ids[item]; filter(id) {
+				neighbors = append(neighbors, index.Neighbor{ID: id, Distance: distances[i]})
+				if len(neighbors) == k {
+					break
+				}
+			}
+		}
+		if len(neighbors) == k || n == len(a.ids) {
+			if a.Key != nil {
+				for i := range neighbors {
+					neighbors[i].Key = a.Key(neighbors[i].ID)
+				}
+			}
+			return neighbors, nil
+		}
+	}
+}
+
+// SearchID returns up to k neighbours of the vector added under id
+func (a *Angular) SearchID(id int32, k int) ([]index.Neighbor, error) {
+	if !a.built {
+		return nil, fmt.Errorf("Index is not built")
+	}
+	item, ok := a.items[id]
+	if !ok {
+		return nil, fmt.Errorf("Item %d is not in index", id)
+	}
+	var items []int
+	var distances []float32
+	a.idx.GetNnsByItem(item, k, a.SearchK, &items, &distances)
+	return a.neighbors(items, distances), nil
+}
+
+func (a *Angular) neighbors(items []int, distances []float32) []index.Neighbor {
+	neighbors := make([]index.Neighbor, len(items))
+	for i, item := range items {
+		neighbors[i] = index.Neighbor{ID: a.ids[item], Distance: distances[i]}
+		if a.Key != nil {
+			neighbors[i].Key = a.Key(neighbors[i].ID)
+		}
+	}
+	return neighbors
+}
+
+// Save writes trees to path and row ids to path + ".ids"
+func (a *Angular) Save(path string) error {
+	if !a.idx.Save(path) {
+		return fmt.Errorf("Can not save Annoy index to %s", path)
+	}
+	f, e := os.Create(path + ".ids")
+	if e != nil {
+		return e
+	}
+	if e = binary.Write(f, binary.LittleEndian, a.ids); e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
+
+// Load loads trees from path and row ids from path + ".ids"
+func (a *Angular) Load(path string) error {
+	data, e := os.ReadFile(path + ".ids")
+	if e != nil {
+		return e
+	}
+	if !a.idx.Load(path) {
+		return fmt.Errorf("Can not load Annoy index from %s", path)
+	}
+	if n := a.idx.GetNItems(); n != len(data)/4 {
+		return fmt.Errorf("Annoy index %s has %d items but %d ids", path, n, len(data)/4)
+	}
+	a.ids = make([]int32, len(data)/4)
+	a.items = make(map[int32]int, len(a.ids))
+	for i := range a.ids {
+		a.ids[i] = int32(binary.LittleEndian.Uint32(data[i*4:]))
+		a.items[a.ids[i]] = i
+	}
+	a.built = true
+	return nil
+}
+
+// Len returns number of indexed vectors
+func (a *Angular) Len() int {
+	return len(a.ids)
+}
+
+// Metric returns index.Angular
+func (a *Angular) Metric() index.Metric {
+	return index.Angular
+}
@@ -0,0 +1,108 @@
+package annoy
+
+import (
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/vseledkin/govector/index"
+)
+
+func unitVectors(r *rand.Rand, n, dim int) [][]float32 {
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		v := make([]float32, dim)
+		for j := range v {
+			v[j] = float32(r.NormFloat64())
+		}
+		vectors[i] = unit(v)
+	}
+	return vectors
+}
+
+// checkInterop searches the same Annoy file with both implementations and
+// requires the same neighbours at the same distances
+func checkInterop(t *testing.T, path string, dim int, queries [][]float32) {
+	cgo := NewAnnoyIndexAngular(dim)
+	defer DeleteAnnoyIndexAngular(cgo)
+	if !cgo.Load(path) {
+		t.Fatalf("cgo Annoy can not load %s", path)
+	}
+	pure, e := index.NewAnnoy(dim, index.Angular, index.GoKernels)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := pure.Load(path); e != nil {
+		t.Fatal(e)
+	}
+	defer pure.Close()
+	if pure.Len() != cgo.GetNItems() {
+		t.Fatalf("cgo Annoy has %d items, index.Annoy %d", cgo.GetNItems(), pure.Len())
+	}
+
+	const k = 10
+	for q, query := range queries {
+		var items []int
+		var distances []float32
+		cgo.GetNnsByVector(query, k, -1, &items, &distances)
+		neighbors, e := pure.Search(query, k)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if len(neighbors) != len(items) {
+			t.Fatalf("query %d cgo Annoy found %d neighbours, index.Annoy %d", q, len(items), len(neighbors))
+		}
+		for i, n := range neighbors {
+			if int(n.ID) != items[i] {
+				t.Fatalf("query %d neighbour %d cgo Annoy %d index.Annoy %d", q, i, items[i], n.ID)
+			}
+			if math.Abs(float64(n.Distance-distances[i])) > 1e-4 {
+				t.Fatalf("query %d neighbour %d cgo Annoy distance %f index.Annoy %f", q, i, distances[i], n.Distance)
+			}
+		}
+	}
+}
+
+func TestInterop(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	dim := 32
+	vectors := unitVectors(r, 1000, dim)
+	queries := unitVectors(r, 50, dim)
+
+	t.Run("cgo to pure Go", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cgo.ann")
+		cgo := NewAnnoyIndexAngular(dim)
+		for i, v := range vectors {
+			cgo.AddItem(i, v)
+		}
+		cgo.Build(10)
+		ok := cgo.Save(path)
+		DeleteAnnoyIndexAngular(cgo)
+		if !ok {
+			t.Fatalf("cgo Annoy can not save %s", path)
+		}
+		checkInterop(t, path, dim, queries)
+	})
+
+	t.Run("pure Go to cgo", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "pure.ann")
+		pure, e := index.NewAnnoy(dim, index.Angular, index.GoKernels)
+		if e != nil {
+			t.Fatal(e)
+		}
+		pure.Trees = 10
+		for i, v := range vectors {
+			if e := pure.Add(int32(i), v); e != nil {
+				t.Fatal(e)
+			}
+		}
+		if e := pure.Build(); e != nil {
+			t.Fatal(e)
+		}
+		if e := pure.Save(path); e != nil {
+			t.Fatal(e)
+		}
+		checkInterop(t, path, dim, queries)
+	})
+}
@@ -0,0 +1,115 @@
+package govector
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeModel writes words with random vectors in the layout of govin build
+func writeModel(t *testing.T, words []string, seed int64) string {
+	path := filepath.Join(t.TempDir(), "model.govin")
+	f, e := os.Create(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	w := bufio.NewWriter(f)
+	binary.Write(w, binary.LittleEndian, [5]uint32{uint32(len(words)), uint32(len(words)), 0, 0, 0})
+	r := rand.New(rand.NewSource(seed))
+	for range words {
+		var vector [128]float32
+		for i := range vector {
+			vector[i] = float32(r.NormFloat64())
+		}
+		binary.Write(w, binary.LittleEndian, vector)
+	}
+	for _, word := range words {
+		w.WriteString("0" + word + "\n")
+	}
+	if e := w.Flush(); e != nil {
+		t.Fatal(e)
+	}
+	if e := f.Close(); e != nil {
+		t.Fatal(e)
+	}
+	return path
+}
+
+func openModel(t *testing.T, path string) *Manifold {
+	m, e := NewManifold(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := m.Open(); e != nil {
+		t.Fatal(e)
+	}
+	return m
+}
+
+func TestLoadOrBuildAnnoy(t *testing.T) {
+	var words []string
+	for i := 0; i < 300; i++ {
+		words = append(words, string(rune('a'+i%26))+string(rune('a'+i/26)))
+	}
+	path := writeModel(t, words, 1)
+	m := openModel(t, path)
+	defer m.Close()
+
+	built, e := m.LoadOrBuildAnnoy("", 4)
+	if e != nil {
+		t.Fatal(e)
+	}
+	indexPath := path + ".annoy"
+	stat, e := os.Stat(indexPath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	want, _ := built.SearchID(m.WordID("ab"), 10)
+	items := built.Len()
+	built.Close()
+
+	loaded, e := m.LoadOrBuildAnnoy("", 4)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if restat, _ := os.Stat(indexPath); !restat.ModTime().Equal(stat.ModTime()) {
+		t.Fatal("Valid index was rebuilt")
+	}
+	if loaded.Len() != items {
+		t.Fatalf("Loaded %d items, want %d", loaded.Len(), items)
+	}
+	got, _ := loaded.SearchID(m.WordID("ab"), 10)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Loaded index returned %v, want %v", got, want)
+	}
+	loaded.Close()
+
+	// index of another number of trees is rebuilt
+	if _, e := m.LoadOrBuildAnnoy("", 8); e != nil {
+		t.Fatal(e)
+	}
+	meta, e := readIndexMeta(indexPath + ".meta")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if meta.Trees != 8 {
+		t.Fatal("Index of another number of trees was not rebuilt")
+	}
+
+	// index of another model with the same words is rebuilt
+	other := openModel(t, writeModel(t, words, 2))
+	defer other.Close()
+	if _, e := other.LoadOrBuildAnnoy(indexPath, 8); e != nil {
+		t.Fatal(e)
+	}
+	if meta, e = readIndexMeta(indexPath + ".meta"); e != nil {
+		t.Fatal(e)
+	}
+	if meta.Checksum != other.Checksum() || meta.Checksum == m.Checksum() {
+		t.Fatal("Index of another model was not rebuilt")
+	}
+}
@@ -0,0 +1,99 @@
+package govector
+
+import "fmt"
+
+const (
+	// gemmRowBlock rows of a 128 dimensional matrix take 128KB and stay in
+	// L2 cache while all queries are scored against them
+	gemmRowBlock = 256
+	// gemmQueryBlock queries are scored against a row at once, every row
+	// value is loaded once for all of them
+	gemmQueryBlock = 4
+)
+
+// SdotBatch scores query against consecutive rows of a row-major matrix:
+//
+//	out[i] = query^T matrix[i*stride : i*stride+len(query)]
+//
+// for every i < len(out). stride is the distance between row starts and must
+// be at least len(query). It is a loop of Sdot calls, Sgemm shares row loads
+// between queries when there are several of them.
+func SdotBatch(query, matrix []float32, stride int, out []float32) {
+	dim := len(query)
+	if stride < dim {
+		panic(fmt.Errorf("SdotBatch stride is less than query dimension"))
+	}
+	if len(out) > 0 && (len(out)-1)*stride+dim > len(matrix) {
+		panic(fmt.Errorf("SdotBatch matrix is too short"))
+	}
+	for i := range out {
+		offset := i * stride
+		out[i] = Sdot(query, matrix[offset:offset+dim])
+	}
+}
+
+// sdot4Go adds dot products of four queries, which start stride values apart
+// in q, with values of y from index from on to out
+func sdot4Go(q []float32, stride int, y []float32, from int, out *[4]float32) {
+	q0, q1, q2, q3 := q[:len(y)], q[stride:stride+len(y)], q[2*stride:2*stride+len(y)], q[3*stride:3*stride+len(y)]
+	d0, d1, d2, d3 := out[0], out[1], out[2], out[3]
+	for i := from; i < len(y); i++ {
+		v := y[i]
+		d0 += q0[i] * v
+		d1 += q1[i] * v
+		d2 += q2[i] * v
+		d3 += q3[i] * v
+	}
+	*out = [4]float32{d0, d1, d2, d3}
+}
+
+// Sgemm scores a block of queries against consecutive rows of a row-major
+// matrix. queries holds len(queries)/dim packed vectors of dimension dim and
+// out is a row-major len(queries)/dim by rows result, so that
+//
+//	out[q*rows+r] = queries[q*dim : (q+1)*dim]^T matrix[r*stride : r*stride+dim]
+//
+// where rows is len(out) divided by the number of queries. Matrix rows are
+// scored in blocks which stay in cache while all queries are scored against
+// them, and every row is scored against gemmQueryBlock queries at once, so
+// its values are loaded into registers once for all of them.
+func Sgemm(queries, matrix []float32, dim, stride int, out []float32) {
+	if dim <= 0 || len(queries)%dim != 0 {
+		panic(fmt.Errorf("Sgemm queries length is not a multiple of dimension"))
+	}
+	if stride < dim {
+		panic(fmt.Errorf("Sgemm stride is less than dimension"))
+	}
+	nq := len(queries) / dim
+	if nq == 0 {
+		return
+	}
+	if len(out)%nq != 0 {
+		panic(fmt.Errorf("Sgemm out length is not a multiple of query count"))
+	}
+	rows := len(out) / nq
+	if rows > 0 && (rows-1)*stride+dim > len(matrix) {
+		panic(fmt.Errorf("Sgemm matrix is too short"))
+	}
+
+	for r0 := 0; r0 < rows; r0 += gemmRowBlock {
+		r1 := r0 + gemmRowBlock
+		if r1 > rows {
+			r1 = rows
+		}
+		q := 0
+		var dots [gemmQueryBlock]float32
+		for ; q+gemmQueryBlock <= nq; q += gemmQueryBlock {
+			block := queries[q*dim : (q+gemmQueryBlock)*dim]
+			for r := r0; r < r1; r++ {
+				sdot4(block, dim, matrix[r*stride:r*stride+dim], &dots)
+				for j, d := range dots {
+					out[(q+j)*rows+r] = d
+				}
+			}
+		}
+		for ; q < nq; q++ {
+			SdotBatch(queries[q*dim:(q+1)*dim], matrix[r0*stride:], stride, out[q*rows+r0:q*rows+r1])
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package govector
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSdotBatch(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	dim, stride, rows := 37, 40, 301
+	query := randomVector(r, dim)
+	matrix := randomVector(r, (rows-1)*stride+dim)
+
+	out := make([]float32, rows)
+	SdotBatch(query, matrix, stride, out)
+	for i := range out {
+		want := sdot(query, matrix[i*stride:i*stride+dim])
+		if !close32(out[i], want, dim) {
+			t.Fatalf("row %d want %f got %f", i, want, out[i])
+		}
+	}
+}
+
+func TestSgemm(t *testing.T) {
+	kernelPaths(t, func(t *testing.T) {
+		r := rand.New(rand.NewSource(1))
+		// dimensions with and without a remainder of eight values, query
+		// counts with and without a remainder of a query block
+		for _, shape := range []struct{ dim, stride, rows, nq int }{
+			{128, 128, gemmRowBlock*2 + 3, 21},
+			{37, 40, 301, gemmQueryBlock},
+			{5, 5, 17, 3},
+		} {
+			queries := randomVector(r, shape.nq*shape.dim)
+			matrix := randomVector(r, (shape.rows-1)*shape.stride+shape.dim)
+
+			out := make([]float32, shape.nq*shape.rows)
+			Sgemm(queries, matrix, shape.dim, shape.stride, out)
+			for q := 0; q < shape.nq; q++ {
+				for i := 0; i < shape.rows; i++ {
+					want := sdot(queries[q*shape.dim:(q+1)*shape.dim], matrix[i*shape.stride:i*shape.stride+shape.dim])
+					if got := out[q*shape.rows+i]; !close32(got, want, shape.dim) {
+						t.Fatalf("dim %d query %d row %d want %f got %f", shape.dim, q, i, want, got)
+					}
+				}
+			}
+		}
+	})
+}
+
+func benchmarkSgemm(b *testing.B, gemm func(queries, matrix []float32, dim int, out []float32)) {
+	r := rand.New(rand.NewSource(1))
+	dim, rows, nq := 128, 10000, 64
+	queries := randomVector(r, nq*dim)
+	matrix := randomVector(r, rows*dim)
+	out := make([]float32, nq*rows)
+	b.SetBytes(int64(nq * rows * dim * 4))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gemm(queries, matrix, dim, out)
+	}
+}
+
+func BenchmarkSgemm(b *testing.B) {
+	benchmarkSgemm(b, func(queries, matrix []float32, dim int, out []float32) {
+		Sgemm(queries, matrix, dim, dim, out)
+	})
+}
+
+// BenchmarkSgemmNaive scores every query against all rows one by one
+func BenchmarkSgemmNaive(b *testing.B) {
+	benchmarkSgemm(b, func(queries, matrix []float32, dim int, out []float32) {
+		rows := len(matrix) / dim
+		for q := 0; q < len(queries)/dim; q++ {
+			SdotBatch(queries[q*dim:(q+1)*dim], matrix, dim, out[q*rows:(q+1)*rows])
+		}
+	})
+}
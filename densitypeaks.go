@@ -0,0 +1,34 @@
+package govector
+
+import (
+	"log"
+	"time"
+
+	"github.com/vseledkin/govector/index"
+)
+
+// DensityPeaksClusters groups all words into k density peaks clusters
+// around the words of the largest density times distance to a denser word.
+// Density cutoff is chosen so that words have 2% of all words as
+// neighbours on average. Words are returned by cluster label.
+func (m *Manifold) DensityPeaksClusters(k int) map[int][]string {
+	var words []string
+	m.VisitWords(func(key string) bool {
+		words = append(words, key)
+		return true
+	})
+	ids := make([]int32, len(words))
+	rows := make([][]float32, len(words))
+	for i, word := range words {
+		ids[i] = int32(i)
+		rows[i] = m.Row(m.WordID(word))
+	}
+	distance := Distance(index.Angular)
+	tree := index.NewVPTree(func(x, y int32) float32 { return distance(rows[x], rows[y]) }, ids)
+
+	start := time.Now()
+	cutoff := index.DensityCutoff(tree, 0.02, 1000, 1)
+	peaks := index.ComputeDensityPeaks(tree, index.DensityPeaksOptions{Cutoff: cutoff})
+	log.Printf("Computed density peaks of %d words with cutoff %f in %s", len(words), cutoff, time.Now().Sub(start))
+	return groupWords(words, peaks.Assign(peaks.TopCenters(k)))
+}
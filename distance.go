@@ -0,0 +1,12 @@
+package govector
+
+import "github.com/vseledkin/govector/index"
+
+// SIMDKernels are index kernels backed by the assembly implementations
+var SIMDKernels = index.Kernels{Dot: Sdot, SqDist: Ssqdist, Xpy: Sxpy, Axpy: Saxpy}
+
+// Distance returns function computing metric between two vectors with the
+// SIMD kernels
+func Distance(metric index.Metric) func(x, y []float32) float32 {
+	return SIMDKernels.Distance(metric)
+}
@@ -0,0 +1,242 @@
+// Package eval measures recall and latency of nearest neighbour indexes
+// against exact ground truth, so index parameters can be tuned on real
+// models instead of blindly.
+package eval
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/vseledkin/govector/index"
+)
+
+// Options controls evaluation of an index
+type Options struct {
+	// K is the number of neighbours searched for every query
+	K int
+	// Workers is the number of concurrent searches, 1 measures latency of
+	// searches which do not compete for CPU
+	Workers int
+	// Filter restricts neighbours of both ground truth and evaluated
+	// indexes, it may be nil
+	Filter index.Filter
+	// QueryIDs holds ids of indexed rows queries were taken from, every
+	// query's own id is dropped from its neighbours so that finding the
+	// query itself does not inflate recall. It is nil for held out queries.
+	QueryIDs []int32
+}
+
+// DefaultOptions evaluates recall@10 with sequential searches
+var DefaultOptions = Options{K: 10, Workers: 1}
+
+// Result is the evaluation of an index with one parameter setting
+type Result struct {
+	Index  string `json:"index"`
+	Params string `json:"params"`
+	K      int    `json:"k"`
+	// Queries is the number of evaluated queries
+	Queries int `json:"queries"`
+	// Recall is the mean fraction of true k nearest neighbours found
+	Recall float64 `json:"recall"`
+	// QPS is the number of queries searched per second of wall time
+	QPS float64 `json:"qps"`
+	// P50 and P99 are latency percentiles of single queries
+	P50 time.Duration `json:"p50_ns"`
+	P99 time.Duration `json:"p99_ns"`
+	// Distances is the mean number of distances computed per query, zero
+	// when the index does not count them
+	Distances float64 `json:"distances"`
+	// Build is the time taken to build or load the index, it is set by
+	// callers which measure it
+	Build time.Duration `json:"build_ns"`
+}
+
+// Sample returns n ids chosen from ids at random without repetition, all of
+// them when n >= len(ids)
+func Sample(ids []int32, n int, seed int64) []int32 {
+	sample := append([]int32(nil), ids...)
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(sample), func(i, j int) { sample[i], sample[j] = sample[j], sample[i] })
+	if n < len(sample) {
+		sample = sample[:n]
+	}
+	return sample
+}
+
+// filtered searches an index with a fixed filter, so index.SearchBatch can
+// fan filtered searches out
+type filtered struct {
+	index.Index
+	filter index.Filter
+}
+
+func (f filtered) Search(v []float32, k int) ([]index.Neighbor, error) {
+	return f.SearchFilter(v, k, f.filter)
+}
+
+func (f filtered) SearchWithStats(v []float32, k int, filter index.Filter) ([]index.Neighbor, index.SearchStats, error) {
+	if searcher, ok := f.Index.(index.StatsSearcher); ok {
+		return searcher.SearchWithStats(v, k, f.filter)
+	}
+	start := time.Now()
+	neighbors, e := f.SearchFilter(v, k, f.filter)
+	return neighbors, index.SearchStats{Duration: time.Since(start)}, e
+}
+
+func search(idx index.Index, queries [][]float32, opts Options) ([]index.BatchResult, time.Duration, error) {
+	k := opts.K
+	if opts.QueryIDs != nil {
+		if len(opts.QueryIDs) != len(queries) {
+			return nil, 0, fmt.Errorf("%d query ids for %d queries", len(opts.QueryIDs), len(queries))
+		}
+		// one more neighbour makes up for the query itself
+		k++
+	}
+	start := time.Now()
+	results := index.SearchBatch(filtered{idx, opts.Filter}, queries, k, opts.Workers)
+	elapsed := time.Since(start)
+	for i, r := range results {
+		if r.Err != nil {
+			return nil, 0, fmt.Errorf("Query %d: %s", i, r.Err)
+		}
+		if opts.QueryIDs != nil {
+			results[i].Neighbors = without(r.Neighbors, opts.QueryIDs[i], opts.K)
+		}
+	}
+	return results, elapsed, nil
+}
+
+// without returns up to k neighbours other than id
+func without(neighbors []index.Neighbor, id int32, k int) []index.Neighbor {
+	kept := neighbors[:0]
+	for _, n := range neighbors {
+		if n.ID != id {
+			kept = append(kept, n)
+		}
+	}
+	if len(kept) > k {
+		kept = kept[:k]
+	}
+	return kept
+}
+
+// GroundTruth returns ids of true K nearest neighbours of every query found
+// by exact, usually a flat index
+func GroundTruth(exact index.Index, queries [][]float32, opts Options) ([][]int32, error) {
+	results, _, e := search(exact, queries, opts)
+	if e != nil {
+		return nil, e
+	}
+	truth := make([][]int32, len(results))
+	for i, r := range results {
+		truth[i] = make([]int32, len(r.Neighbors))
+		for j, n := range r.Neighbors {
+			truth[i][j] = n.ID
+		}
+	}
+	return truth, nil
+}
+
+// Evaluate searches idx for neighbours of queries and compares them with
+// truth computed by GroundTruth with the same options
+func Evaluate(name, params string, idx index.Index, queries [][]float32, truth [][]int32, opts Options) (Result, error) {
+	if len(truth) != len(queries) {
+		return Result{}, fmt.Errorf("Ground truth of %d queries for %d queries", len(truth), len(queries))
+	}
+	results, elapsed, e := search(idx, queries, opts)
+	if e != nil {
+		return Result{}, e
+	}
+	result := Result{Index: name, Params: params, K: opts.K, Queries: len(queries)}
+	if len(queries) == 0 {
+		return result, nil
+	}
+	latencies := make([]time.Duration, len(results))
+	var recall float64
+	var distances int
+	for i, r := range results {
+		latencies[i] = r.Stats.Duration
+		distances += r.Stats.Distances
+		recall += Recall(r.Neighbors, truth[i])
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.Recall = recall / float64(len(results))
+	result.QPS = float64(len(results)) / elapsed.Seconds()
+	result.P50 = Percentile(latencies, 0.5)
+	result.P99 = Percentile(latencies, 0.99)
+	result.Distances = float64(distances) / float64(len(results))
+	return result, nil
+}
+
+// Recall returns the fraction of truth found among neighbours, 1 when truth
+// is empty
+func Recall(neighbors []index.Neighbor, truth []int32) float64 {
+	if len(truth) == 0 {
+		return 1
+	}
+	found := make(map[int32]bool, len(neighbors))
+	for _, n := range neighbors {
+		found[n.ID] = true
+	}
+	hits := 0
+	for _, id := range truth {
+		if found[id] {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(truth))
+}
+
+// Percentile returns the q-th quantile of sorted latencies by the nearest
+// rank method
+func Percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// WriteCSV writes results as CSV with a header line, latencies are in
+// milliseconds
+func WriteCSV(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"index", "params", "k", "queries", "recall", "qps", "p50_ms", "p99_ms", "distances", "build_ms"})
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+	for _, r := range results {
+		cw.Write([]string{
+			r.Index,
+			r.Params,
+			strconv.Itoa(r.K),
+			strconv.Itoa(r.Queries),
+			strconv.FormatFloat(r.Recall, 'f', 4, 64),
+			strconv.FormatFloat(r.QPS, 'f', 1, 64),
+			ms(r.P50),
+			ms(r.P99),
+			strconv.FormatFloat(r.Distances, 'f', 1, 64),
+			ms(r.Build),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes results as indented JSON array, durations are in
+// nanoseconds
+func WriteJSON(w io.Writer, results []Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
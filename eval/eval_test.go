@@ -0,0 +1,120 @@
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vseledkin/govector/index"
+)
+
+func euclidean(x, y []float32) float32 {
+	var s float32
+	for i := range x {
+		d := x[i] - y[i]
+		s += d * d
+	}
+	return float32(math.Sqrt(float64(s)))
+}
+
+func TestEvaluate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	exact := index.NewVPIndex(index.Euclidean, euclidean, nil)
+	annoy, _ := index.NewAnnoy(8, index.Euclidean, index.GoKernels)
+	annoy.Trees = 1
+	var ids []int32
+	var vectors [][]float32
+	for i := int32(0); i < 1000; i++ {
+		v := make([]float32, 8)
+		for j := range v {
+			v[j] = float32(r.NormFloat64())
+		}
+		exact.Add(i, v)
+		annoy.Add(i, v)
+		ids = append(ids, i)
+		vectors = append(vectors, v)
+	}
+	exact.Build()
+	annoy.Build()
+
+	sample := Sample(ids, 50, 1)
+	if len(sample) != 50 || len(Sample(ids, 5000, 1)) != len(ids) {
+		t.Fatalf("Sampled %d ids, want 50", len(sample))
+	}
+	queries := make([][]float32, len(sample))
+	for i, id := range sample {
+		queries[i] = vectors[id]
+	}
+	opts := DefaultOptions
+	opts.Filter = func(id int32) bool { return id%2 == 0 }
+	opts.QueryIDs = sample
+	truth, e := GroundTruth(exact, queries, opts)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i, ids := range truth {
+		if len(ids) != opts.K {
+			t.Fatalf("Ground truth of query %d holds %d ids, want %d", i, len(ids), opts.K)
+		}
+		for _, id := range ids {
+			if id%2 != 0 {
+				t.Fatalf("Ground truth holds rejected id %d", id)
+			}
+			if id == sample[i] {
+				t.Fatalf("Ground truth of query %d holds the query itself", i)
+			}
+		}
+	}
+
+	perfect, e := Evaluate("vp", "", exact, queries, truth, opts)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if perfect.Recall != 1 || perfect.Queries != 50 || perfect.QPS <= 0 || perfect.Distances <= 0 || perfect.P99 < perfect.P50 {
+		t.Fatalf("Exact index evaluated as %+v", perfect)
+	}
+	approximate, e := Evaluate("annoy", "trees=1", annoy, queries, truth, opts)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if approximate.Recall <= 0 || approximate.Recall >= 1 {
+		t.Fatalf("Single tree recall %f", approximate.Recall)
+	}
+
+	var buf bytes.Buffer
+	if e := WriteCSV(&buf, []Result{perfect, approximate}); e != nil {
+		t.Fatal(e)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "index,params,k,") || !strings.HasPrefix(lines[2], "annoy,trees=1,10,50,") {
+		t.Fatalf("Unexpected CSV report:\n%s", buf.String())
+	}
+	buf.Reset()
+	if e := WriteJSON(&buf, []Result{perfect}); e != nil {
+		t.Fatal(e)
+	}
+	var decoded []Result
+	if e := json.Unmarshal(buf.Bytes(), &decoded); e != nil || len(decoded) != 1 || decoded[0] != perfect {
+		t.Fatalf("JSON report %s does not decode to %+v: %v", buf.String(), perfect, e)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i))
+	}
+	if p := Percentile(latencies, 0.5); p != 50 {
+		t.Fatalf("p50 %d, want 50", p)
+	}
+	if p := Percentile(latencies, 0.99); p != 99 {
+		t.Fatalf("p99 %d, want 99", p)
+	}
+	if p := Percentile(latencies[:1], 0.99); p != 1 {
+		t.Fatalf("p99 of one latency %d, want 1", p)
+	}
+}
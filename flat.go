@@ -0,0 +1,257 @@
+package govector
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vseledkin/govector/index"
+)
+
+// flatBlock rows are scored with one SdotBatch call
+const flatBlock = 1024
+
+// FlatIndex is an exact nearest neighbour index that scans every row of a
+// row-major vector matrix, usually the mapped vector section of a Manifold.
+// It is slow compared to tree indexes but always returns the true nearest
+// neighbours, which makes it the ground truth for recall measurement.
+type FlatIndex struct {
+	matrix []float32
+	dim    int
+	rows   int
+	metric index.Metric
+	// inverse norms of rows for cosine distance
+	inorms []float32
+	// Workers limits the number of goroutines scanning the matrix,
+	// defaults to runtime.NumCPU()
+	Workers int
+	// Key labels search results, it may be nil
+	Key func(id int32) string
+}
+
+// NewFlatIndex creates an exact index over matrix, a row-major matrix with
+// rows of dim values. The matrix is referenced, not copied.
+func NewFlatIndex(matrix []float32, dim int, metric index.Metric) *FlatIndex {
+	if dim <= 0 || len(matrix)%dim != 0 {
+		panic(fmt.Errorf("Matrix length %d is not a multiple of dimension %d", len(matrix), dim))
+	}
+	f := &FlatIndex{
+		matrix:  matrix,
+		dim:     dim,
+		rows:    len(matrix) / dim,
+		metric:  metric,
+		Workers: runtime.NumCPU(),
+	}
+	if metric == index.Cosine || metric == index.Angular {
+		f.inorms = make([]float32, f.rows)
+		for i := range f.inorms {
+			if n := L2(f.row(i)); n > 0 {
+				f.inorms[i] = 1 / n
+			}
+		}
+	}
+	return f
+}
+
+// FlatIndex creates an exact index over all stored vectors of the manifold,
+// words as well as subwords, results are labelled by RowKey
+func (m *Manifold) FlatIndex(metric index.Metric) *FlatIndex {
+	f := NewFlatIndex(m.Matrix(), m.Dim(), metric)
+	f.Key = m.RowKey
+	return f
+}
+
+func (f *FlatIndex) row(i int) []float32 {
+	return f.matrix[i*f.dim : (i+1)*f.dim]
+}
+
+// Len returns number of rows in the index
+func (f *FlatIndex) Len() int {
+	return f.rows
+}
+
+// Metric returns the distance the index ranks rows by
+func (f *FlatIndex) Metric() index.Metric {
+	return f.metric
+}
+
+// Add is not supported, rows of the matrix are indexed as they are
+func (f *FlatIndex) Add(id int32, v []float32) error {
+	return index.ErrNotSupported
+}
+
+// Build does nothing, FlatIndex needs no preparation
+func (f *FlatIndex) Build() error {
+	return nil
+}
+
+// Save is not supported, the matrix itself is the index
+func (f *FlatIndex) Save(path string) error {
+	return index.ErrNotSupported
+}
+
+// Load is not supported, the matrix itself is the index
+func (f *FlatIndex) Load(path string) error {
+	return index.ErrNotSupported
+}
+
+// Search returns up to k rows closest to v
+func (f *FlatIndex) Search(v []float32, k int) ([]index.Neighbor, error) {
+	return f.SearchFilter(v, k, nil)
+}
+
+// SearchFilter returns up to k rows closest to v accepted by filter
+func (f *FlatIndex) SearchFilter(v []float32, k int, filter index.Filter) ([]index.Neighbor, error) {
+	neighbors, _, e := f.SearchWithStats(v, k, filter)
+	return neighbors, e
+}
+
+// SearchWithStats is SearchFilter which also returns search statistics,
+// distances to all rows are computed
+func (f *FlatIndex) SearchWithStats(v []float32, k int, filter index.Filter) ([]index.Neighbor, index.SearchStats, error) {
+	start := time.Now()
+	if len(v) != f.dim {
+		return nil, index.SearchStats{}, fmt.Errorf("Query dimension %d does not match index dimension %d", len(v), f.dim)
+	}
+	ids, distances := f.SearchRows(v, k, filter)
+	stats := index.SearchStats{Distances: f.rows, Duration: time.Since(start)}
+	return f.neighbors(ids, distances), stats, nil
+}
+
+// SearchID returns up to k rows closest to row id
+func (f *FlatIndex) SearchID(id int32, k int) ([]index.Neighbor, error) {
+	if id < 0 || int(id) >= f.rows {
+		return nil, fmt.Errorf("Row %d is out of range", id)
+	}
+	return f.Search(f.row(int(id)), k)
+}
+
+func (f *FlatIndex) neighbors(ids []int32, distances []float32) []index.Neighbor {
+	neighbors := make([]index.Neighbor, len(ids))
+	for i, id := range ids {
+		neighbors[i] = index.Neighbor{ID: id, Distance: distances[i]}
+		if f.Key != nil {
+			neighbors[i].Key = f.Key(id)
+		}
+	}
+	return neighbors
+}
+
+// SearchRows returns ids of up to k rows closest to query and the
+// corresponding distances ordered from the closest. Rows for which filter
+// returns false are skipped, a nil filter accepts every row.
+func (f *FlatIndex) SearchRows(query []float32, k int, filter func(id int32) bool) (ids []int32, distances []float32) {
+	if k < 1 || f.rows == 0 {
+		return
+	}
+	if len(query) != f.dim {
+		panic(fmt.Errorf("Query dimension %d does not match index dimension %d", len(query), f.dim))
+	}
+	var iq float32
+	if f.inorms != nil {
+		if n := L2(query); n > 0 {
+			iq = 1 / n
+		}
+	}
+
+	workers := f.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	// every worker scans at least one block of rows
+	if blocks := (f.rows + flatBlock - 1) / flatBlock; workers > blocks {
+		workers = blocks
+	}
+	chunk := (f.rows + workers - 1) / workers
+
+	heaps := make([]index.PriorityQueue, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		start, end := w*chunk, (w+1)*chunk
+		if end > f.rows {
+			end = f.rows
+		}
+		wg.Add(1)
+		go func(w, start, end int) {
+			defer wg.Done()
+			heaps[w] = f.scan(query, iq, start, end, k, filter)
+		}(w, start, end)
+	}
+	wg.Wait()
+
+	// merge per worker heaps
+	var all []*index.HeapItem
+	for _, h := range heaps {
+		all = append(all, h...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Dist == all[j].Dist {
+			return all[i].Item.(int32) < all[j].Item.(int32)
+		}
+		return all[i].Dist < all[j].Dist
+	})
+	if len(all) > k {
+		all = all[:k]
+	}
+	ids = make([]int32, len(all))
+	distances = make([]float32, len(all))
+	for i, hi := range all {
+		ids[i] = hi.Item.(int32)
+		switch f.metric {
+		case index.Angular:
+			distances[i] = index.CosineToAngular(hi.Dist)
+		case index.Euclidean:
+			distances[i] = float32(math.Sqrt(float64(hi.Dist)))
+		default:
+			distances[i] = hi.Dist
+		}
+	}
+	return
+}
+
+// scan keeps k closest rows in [start, end) in a max heap. Euclidean
+// distances are kept squared and angular ones as cosine distances.
+func (f *FlatIndex) scan(query []float32, iq float32, start, end, k int, filter func(id int32) bool) index.PriorityQueue {
+	h := make(index.PriorityQueue, 0, k)
+	push := func(id int, d float32) {
+		if filter != nil && !filter(int32(id)) {
+			return
+		}
+		if len(h) < k {
+			heap.Push(&h, &index.HeapItem{Item: int32(id), Dist: d})
+		} else if d < h.Top().Dist {
+			top := h.Top()
+			top.Item = int32(id)
+			top.Dist = d
+			heap.Fix(&h, 0)
+		}
+	}
+
+	if f.metric == index.Euclidean {
+		for i := start; i < end; i++ {
+			push(i, Ssqdist(query, f.row(i)))
+		}
+		return h
+	}
+
+	scores := make([]float32, flatBlock)
+	for b := start; b < end; b += flatBlock {
+		n := end - b
+		if n > flatBlock {
+			n = flatBlock
+		}
+		SdotBatch(query, f.matrix[b*f.dim:], f.dim, scores[:n])
+		for i, dot := range scores[:n] {
+			if f.inorms != nil {
+				push(b+i, 1-dot*iq*f.inorms[b+i])
+			} else {
+				push(b+i, -dot)
+			}
+		}
+	}
+	return h
+}
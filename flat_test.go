@@ -0,0 +1,90 @@
+package govector
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/vseledkin/govector/index"
+)
+
+func bruteForce(matrix []float32, dim int, query []float32, metric index.Metric, k int, filter func(int32) bool) (ids []int32, distances []float32) {
+	type scored struct {
+		id int32
+		d  float32
+	}
+	var all []scored
+	for i := 0; i < len(matrix)/dim; i++ {
+		if filter != nil && !filter(int32(i)) {
+			continue
+		}
+		row := matrix[i*dim : (i+1)*dim]
+		var d float32
+		switch metric {
+		case index.Angular:
+			d = index.CosineToAngular(1 - sdot(query, row)/l2(query)/l2(row))
+		case index.Cosine:
+			d = 1 - sdot(query, row)/l2(query)/l2(row)
+		case index.DotProduct:
+			d = -sdot(query, row)
+		case index.Euclidean:
+			d = float32(math.Sqrt(float64(ssqdist(query, row))))
+		}
+		all = append(all, scored{int32(i), d})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].d < all[j].d })
+	if len(all) > k {
+		all = all[:k]
+	}
+	for _, s := range all {
+		ids = append(ids, s.id)
+		distances = append(distances, s.d)
+	}
+	return
+}
+
+func TestFlatIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	dim, rows := 24, 5000
+	matrix := randomVector(r, rows*dim)
+	odd := func(id int32) bool { return id%2 == 1 }
+
+	for _, metric := range []index.Metric{index.Angular, index.Cosine, index.DotProduct, index.Euclidean} {
+		idx := NewFlatIndex(matrix, dim, metric)
+		for _, workers := range []int{1, 3, 8} {
+			idx.Workers = workers
+			for _, filter := range []func(int32) bool{nil, odd} {
+				for _, k := range []int{1, 10, 2000} {
+					query := randomVector(r, dim)
+					ids, distances := idx.SearchRows(query, k, filter)
+					wantIds, wantDistances := bruteForce(matrix, dim, query, metric, k, filter)
+					if len(ids) != len(wantIds) {
+						t.Fatalf("%s k=%d want %d results got %d", metric, k, len(wantIds), len(ids))
+					}
+					for i := range ids {
+						// ties may be ordered differently, compare distances
+						if math.Abs(float64(distances[i]-wantDistances[i])) > 1e-4 {
+							t.Fatalf("%s k=%d result %d want %d %f got %d %f", metric, k, i, wantIds[i], wantDistances[i], ids[i], distances[i])
+						}
+						if filter != nil && !filter(ids[i]) {
+							t.Fatalf("%s filtered row %d returned", metric, ids[i])
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestFlatIndexSmall(t *testing.T) {
+	idx := NewFlatIndex([]float32{0, 1, 1, 0, 1, 1}, 2, index.Euclidean)
+	idx.Key = func(id int32) string { return string(rune('a' + id)) }
+	neighbors, err := idx.Search([]float32{1, 0}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(neighbors) != 3 || neighbors[0].ID != 1 || neighbors[0].Key != "b" || neighbors[0].Distance != 0 {
+		t.Fatalf("unexpected result %v", neighbors)
+	}
+}
@@ -6,9 +6,10 @@ import (
 	"log"
 	"math"
 	"os"
+	"runtime"
 	"sort"
+	"sync"
 
-	"github.com/vseledkin/govector/annoy"
 	"github.com/vseledkin/govector/index"
 )
 
@@ -16,6 +17,9 @@ type Manifold struct {
 	dbfile string
 	bc     *Store
 	cache  *Cache
+	// NGramOptions controls how out of vocabulary words are split into
+	// subwords, defaults to DefaultNGramOptions
+	NGramOptions NGramOptions
 	//c := cache.New(5*time.Minute, 30*time.Second)
 }
 
@@ -25,6 +29,7 @@ func NewManifold(dbfile string) (*Manifold, error) {
 		manifold := new(Manifold)
 		manifold.dbfile = dbfile
 		manifold.cache = NewCache()
+		manifold.NGramOptions = DefaultNGramOptions
 		return manifold, nil
 	}
 	return nil, err
@@ -42,32 +47,10 @@ func (m *Manifold) Close() {
 
 var CacheHit, CacheMiss int
 
-func сomputeNGrams(s string, minn, maxn int) (ngrams []string) {
-
-	runes := []rune(s)
-	L := len(runes)
-	for i := 0; i < L; i++ {
-		ngram := ""
-		if (runes[i] & 0xC0) == 0x80 {
-			continue
-		}
-		for j, n := i, 1; j < L && n <= maxn; n++ {
-			ngram += string(runes[j])
-			j++
-			for j < L && (runes[j]&0xC0) == 0x80 {
-				ngram += string(runes[j])
-				j++
-			}
-			if n >= minn && !(n == 1 && (i == 0 || j == L)) {
-				ngrams = append(ngrams, ngram)
-			}
-		}
-	}
-	return
-}
-
+// ComputeNGrams returns subword n-grams of the word s wrapped in boundary
+// markers according to m.NGramOptions
 func (m *Manifold) ComputeNGrams(s string) (ngrams []string) {
-	return сomputeNGrams(s, 3, 6)
+	return NGrams(s, m.NGramOptions)
 }
 
 func (m *Manifold) WordID(word string) int32 {
@@ -83,12 +66,20 @@ func (m *Manifold) IDWord(id int32) string {
 	return m.bc.rindex[id][1:]
 }
 
+// RowKey returns the stored key of row id, which keeps the row type prefix:
+// 0 for words, 1 for word n-grams and 2 for n-grams. Indexes over all rows
+// label neighbours with it, so that a word and a subword of the same
+// spelling are told apart.
+func (m *Manifold) RowKey(id int32) string {
+	return m.bc.rindex[id]
+}
+
 func (m *Manifold) llget(key []byte) (v []float32, ok bool, err error) {
 	offset, ok := m.bc.index[string(key)]
 	if !ok {
 		return nil, false, nil
 	}
-	offs := 4*4 + int64(offset)*128*4
+	offs := headerSize + int64(offset)*128*4
 	var off int64
 	off, err = m.bc.Reader.Seek(offs, 0)
 	if err != nil {
@@ -137,7 +128,7 @@ func (m *Manifold) GetVector(s string) (v []float32, e error) {
 
 	// get ngrams
 	var nv []float32
-	for _, ngram := range m.ComputeNGrams("<" + s + ">") {
+	for _, ngram := range m.ComputeNGrams(s) {
 		if nv, found, e = m.llget([]byte("2" + ngram)); e != nil {
 			log.Printf("Error geting vector for word [%s] %s", s, e)
 			return
@@ -169,6 +160,19 @@ func (m *Manifold) Dim() int {
 	return 128
 }
 
+// Matrix returns all stored vectors as a row-major Count() by Dim() matrix
+// mapped from the file, it must not be modified
+func (m *Manifold) Matrix() []float32 {
+	return m.bc.Matrix
+}
+
+// Row returns the stored vector with row id without copying, it must not be
+// modified
+func (m *Manifold) Row(id int32) []float32 {
+	offset := int(id) * m.Dim()
+	return m.bc.Matrix[offset : offset+m.Dim() : offset+m.Dim()]
+}
+
 func (m *Manifold) HasWord(s string) (has bool) {
 	_, has = m.bc.index["0"+s]
 	return
@@ -273,6 +277,12 @@ func (m *Manifold) VisitWGrams(visitor func(key string, vector []float32)) {
 	}
 }
 
+// Checksum returns checksum of the model file, indexes saved next to the
+// model record it to detect that they were built for another model
+func (m *Manifold) Checksum() uint32 {
+	return m.bc.Checksum()
+}
+
 func (m *Manifold) Count() (count uint32) {
 	return m.bc.TotalCount
 }
@@ -394,73 +404,279 @@ func (m *Manifold) EuclideanStr(x, y string) (d float32) {
 	return
 }
 */
-func (m *Manifold) AnnoyIndex() (annoy.AnnoyIndexAngular, []interface{}) {
-	/*defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered in f", r)
-			fmt.Println("Failed to create index")
+// AnnoyIndex builds Annoy angular forest of 16 trees over all words. Word
+// row ids are used as Annoy items, words occupy the first rows of the file.
+func (m *Manifold) AnnoyIndex() (index.Index, error) {
+	idx, e := index.NewAnnoy(m.Dim(), index.Angular, SIMDKernels)
+	if e != nil {
+		return nil, e
+	}
+	idx.Trees = 16
+	idx.Key = m.IDWord
+	if e := m.indexWords(idx); e != nil {
+		return nil, e
+	}
+	return idx, nil
+}
 
+// VPIndex returns VP-tree with angular metric over all words. The tree is
+// kept next to the model file, it is loaded when its metadata matches the
+// model checksum and word count, otherwise it is built and saved.
+func (m *Manifold) VPIndex() (index.Index, error) {
+	idx := index.NewVPIndex(index.Angular, Distance(index.Angular), m.IDWord)
+	if e := m.addWords(idx); e != nil {
+		return nil, e
+	}
+	path := m.dbfile + ".vpt"
+	checksum := m.Checksum()
+
+	meta, e := readIndexMeta(path + ".meta")
+	switch {
+	case e != nil:
+		log.Printf("No valid index in %s: %s", path, e)
+	case !meta.builtFrom(m, checksum):
+		log.Printf("Index %s was built for another model", path)
+	default:
+		log.Printf("Loading %s", path)
+		if e = idx.Load(path); e == nil && idx.Len() == int(meta.Items) {
+			return idx, nil
 		}
-	}()*/
-	var max uint32 = m.WordCount()
-	if m.WordCount() < max {
-		max = m.WordCount()
+		log.Printf("Index %s does not match its metadata: %v", path, e)
 	}
-	keys := make([]interface{}, max)
-	log.Printf("Reading %d words", max)
-	var i uint32 = 0
+
+	if e := idx.Build(); e != nil {
+		return nil, e
+	}
+	if e := idx.Save(path); e != nil {
+		return nil, e
+	}
+	return idx, writeIndexMeta(path+".meta", indexMeta{
+		Checksum: checksum,
+		Dim:      uint32(m.Dim()),
+		Words:    m.WordCount(),
+		Items:    uint32(idx.Len()),
+	})
+}
+
+// HNSWIndex returns HNSW graph with angular metric over all words kept next
+// to the model file. The graph is loaded from the file when its metadata
+// matches the model checksum and word count, otherwise vectors are inserted
+// concurrently by runtime.NumCPU() goroutines and the graph is saved.
+func (m *Manifold) HNSWIndex() (index.Index, error) {
+	path := m.dbfile + ".hnsw"
+	checksum := m.Checksum()
+
+	meta, e := readIndexMeta(path + ".meta")
+	switch {
+	case e != nil:
+		log.Printf("No valid index in %s: %s", path, e)
+	case !meta.builtFrom(m, checksum):
+		log.Printf("Index %s was built for another model", path)
+	default:
+		log.Printf("Loading %s", path)
+		idx := index.NewHNSW(m.Dim(), index.Angular, SIMDKernels, 16, 200)
+		idx.Key = m.IDWord
+		if e = idx.Load(path); e == nil && idx.Len() == int(meta.Items) {
+			return idx, nil
+		}
+		if e == nil {
+			idx.Close()
+		}
+		log.Printf("Index %s does not match its metadata: %v", path, e)
+	}
+
+	idx := index.NewHNSW(m.Dim(), index.Angular, SIMDKernels, 16, 200)
+	idx.Key = m.IDWord
+	var ids []int32
+	m.VisitWords(func(key string) bool {
+		ids = append(ids, m.WordID(key))
+		return true
+	})
+	log.Printf("Inserting %d words", len(ids))
+	next := make(chan int32)
+	errs := make(chan error, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range next {
+				if e := idx.Add(id, m.Row(id)); e != nil {
+					errs <- e
+					return
+				}
+			}
+		}()
+	}
+	for i, id := range ids {
+		select {
+		case next <- id:
+		case e := <-errs:
+			close(next)
+			wg.Wait()
+			return nil, e
+		}
+		if (i+1)%1e5 == 0 {
+			log.Printf("Inserted %d words", i+1)
+		}
+	}
+	close(next)
+	wg.Wait()
+	select {
+	case e := <-errs:
+		return nil, e
+	default:
+	}
+	if e := idx.Save(path); e != nil {
+		return nil, e
+	}
+	return idx, writeIndexMeta(path+".meta", indexMeta{
+		Checksum: checksum,
+		Dim:      uint32(m.Dim()),
+		Words:    m.WordCount(),
+		Items:    uint32(idx.Len()),
+	})
+}
+
+// IVFIndex returns angular IVF index over all stored rows, words as well as
+// n-grams, kept next to the model file. With subquantizers > 0 lists hold PQ
+// codes instead of vectors. The index is loaded from the file when its
+// metadata matches the model checksum, row count and parameters, otherwise
+// it is trained with nlist lists, 4*sqrt(Count()) when nlist <= 0, and
+// saved.
+func (m *Manifold) IVFIndex(nlist, subquantizers int) (index.Index, error) {
+	if nlist <= 0 {
+		nlist = int(4 * math.Sqrt(float64(m.Count())))
+	}
+	if subquantizers < 0 {
+		subquantizers = 0
+	}
+	path := m.dbfile + ".ivf"
+	if subquantizers > 0 {
+		path = m.dbfile + ".ivfpq"
+	}
+	newIndex := func() (idx *index.IVF, e error) {
+		if subquantizers > 0 {
+			idx, e = index.NewIVFPQ(m.Dim(), index.Angular, SIMDKernels, nlist, subquantizers)
+		} else {
+			idx = index.NewIVF(m.Dim(), index.Angular, SIMDKernels, nlist)
+		}
+		if e == nil {
+			idx.Key = m.RowKey
+		}
+		return
+	}
+	idx, e := newIndex()
+	if e != nil {
+		return nil, e
+	}
+	checksum := m.Checksum()
+
+	meta, e := readIndexMeta(path + ".meta")
+	switch {
+	case e != nil:
+		log.Printf("No valid index in %s: %s", path, e)
+	case !meta.builtFrom(m, checksum) || meta.Items != m.Count():
+		log.Printf("Index %s was built for another model", path)
+	case meta.Lists != uint32(nlist) || meta.Subquantizers != uint32(subquantizers):
+		log.Printf("Index %s was built with %d lists and %d subquantizers", path, meta.Lists, meta.Subquantizers)
+	default:
+		log.Printf("Loading %s", path)
+		e = idx.Load(path)
+		if e == nil && idx.Len() == int(meta.Items) && idx.Lists() == nlist && idx.Subquantizers() == subquantizers {
+			return idx, nil
+		}
+		log.Printf("Index %s does not match its metadata: %v", path, e)
+		// loading replaces parameters of the index
+		idx.Close()
+		if idx, e = newIndex(); e != nil {
+			return nil, e
+		}
+	}
+
+	for id := int32(0); id < int32(m.Count()); id++ {
+		if e := idx.Add(id, m.Row(id)); e != nil {
+			return nil, e
+		}
+	}
+	log.Printf("Training %d lists over %d rows", nlist, m.Count())
+	if e := idx.Build(); e != nil {
+		return nil, e
+	}
+	if e := idx.Save(path); e != nil {
+		return nil, e
+	}
+	return idx, writeIndexMeta(path+".meta", indexMeta{
+		Checksum:      checksum,
+		Dim:           uint32(m.Dim()),
+		Words:         m.WordCount(),
+		Items:         uint32(idx.Len()),
+		Lists:         uint32(nlist),
+		Subquantizers: uint32(subquantizers),
+	})
+}
+
+// indexWords adds stored vectors of all words to idx and builds it
+func (m *Manifold) indexWords(idx index.Index) error {
+	if e := m.addWords(idx); e != nil {
+		return e
+	}
+	return idx.Build()
+}
+
+// addWords adds stored vectors of all words to idx
+func (m *Manifold) addWords(idx index.Index) (e error) {
+	log.Printf("Reading %d words", m.WordCount())
+	var i uint32
 	m.VisitWords(func(key string) bool {
 		if len(key) == 0 {
-			panic(fmt.Errorf("Empty key"))
+			e = fmt.Errorf("Empty key")
+			return false
 		}
-		keys[i] = key
-		i++
-		if i == max {
+		id := m.WordID(key)
+		if e = idx.Add(id, m.Row(id)); e != nil {
 			return false
 		}
-		if i%1e4 == 0 {
+		i++
+		if i%1e5 == 0 {
 			log.Printf("Read %d words", i)
 		}
 		return true
 	})
-	// check triangular inequality
-	/*
-		for i := 0; i < len(keys)-3; i++ {
-			x := keys[i]
-			y := keys[i+1]
-			z := keys[i+2]
-			dxy := m.Angular(x, y)
-			dxz := m.Angular(x, z)
-			dyz := m.Angular(y, z)
-
-			if dxy > dxz+dyz {
-				panic("Distance function is not metric!")
-			}
-
-			if dxz > dxy+dyz {
-				panic("Distance function is not metric!")
-			}
-			if dyz > dxy+dxz {
-				panic("Distance function is not metric!")
-			}
-		}*/
-
+	if e != nil {
+		return
+	}
 	log.Printf("Read %d words", i)
+	return
+}
 
-	idx := annoy.NewAnnoyIndexAngular(m.Dim())
-	for i, key := range keys {
-		v, e := m.GetVector(key.(string))
-		if e != nil {
-			panic(e)
+// WordFilter returns filter accepting rows of words, it rejects rows of
+// subwords which indexes over all rows, like IVFIndex, hold as well
+func (m *Manifold) WordFilter() index.Filter {
+	words := index.NewBitmap(len(m.bc.rindex))
+	for id, key := range m.bc.rindex {
+		if key[0] == '0' {
+			words.Set(int32(id))
 		}
-		idx.AddItem(i, v)
 	}
-	idx.Build(16)
-	//idx.Save(m.dbfile + ".tree")
-	return idx, keys
+	return words.Filter()
+}
+
+// RowVPTree builds VP-tree with angular metric over row ids of all words
+func (m *Manifold) RowVPTree() *index.RowVPTree {
+	ids := make([]int32, 0, m.WordCount())
+	m.VisitWords(func(key string) bool {
+		ids = append(ids, m.WordID(key))
+		return true
+	})
+	return index.NewRowVPTree(m.Matrix(), m.Dim(), Distance(index.Angular), ids)
 }
 
-func (m *Manifold) MakeVPIndex() *index.VPTree {
+// MakeVPIndex builds VP-tree over words using Angular metric on strings.
+//
+// Deprecated: use VPIndex or RowVPTree, they avoid vector lookups on every
+// distance computation.
+func (m *Manifold) MakeVPIndex() *index.VPTree[string] {
 	/*defer func() {
 		if r := recover(); r != nil {
 			fmt.Println("Recovered in f", r)
@@ -473,7 +689,7 @@ func (m *Manifold) MakeVPIndex() *index.VPTree {
 	if m.WordCount() < max {
 		max = m.WordCount()
 	}
-	keys := make([]interface{}, max)
+	keys := make([]string, max)
 	log.Printf("Reading %d words", max)
 	var i uint32 = 0
 	m.VisitWords(func(key string) bool {
@@ -513,7 +729,7 @@ func (m *Manifold) MakeVPIndex() *index.VPTree {
 		}
 	*/
 	log.Printf("Read %d words", i)
-	idx := index.NewVPTree(m.Angular, keys)
+	idx := index.NewVPTree(func(x, y string) float32 { return m.Angular(x, y) }, keys)
 
 	//idx.PrintTree(nil, 0, 100)
 	return idx
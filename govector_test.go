@@ -30,15 +30,6 @@ func TestGet(t *testing.T) {
 
 }
 
-func TestSplit(t *testing.T) {
-	s := "Путин"
-	ngrams := сomputeNGrams(s, 3, 6)
-	t.Logf("%#v", ngrams)
-	//if split != want {
-	//t.Fatalf("Split failed want\n%s\ngot\n%s\n", want, split)
-	//}
-}
-
 func TestOptics(t *testing.T) {
 	//m, e := NewManifold("/Volumes/data/fasttextmodel/RUEVENTOS/ru.cbow")
 	m, e := NewManifold("/Users/vseledkin/ru.cbow")
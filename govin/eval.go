@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vseledkin/govector"
+	"github.com/vseledkin/govector/eval"
+	"github.com/vseledkin/govector/index"
+)
+
+// parseList splits comma separated list of values parsed by parse
+func parseList[T any](list string, parse func(s string) (T, error)) ([]T, error) {
+	var values []T
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		v, e := parse(s)
+		if e != nil {
+			return nil, fmt.Errorf("Bad list value %q: %s", s, e)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func parseFloat32(s string) (float32, error) {
+	f, e := strconv.ParseFloat(s, 32)
+	return float32(f), e
+}
+
+// EvalIndex measures recall, QPS and latency of index types over the model
+// for every parameter setting and writes a report. Ground truth is computed
+// by the flat index and all indexes are restricted to words, so indexes
+// over all rows are evaluated on the same neighbours. Queries are sampled
+// words, which are excluded from their own neighbours.
+func EvalIndex() (e error) {
+	treeCounts, e := parseList(evalTrees, strconv.Atoi)
+	if e != nil {
+		return
+	}
+	cutoffs, e := parseList(evalCutoffs, parseFloat32)
+	if e != nil {
+		return
+	}
+	efs, e := parseList(evalEf, strconv.Atoi)
+	if e != nil {
+		return
+	}
+	nprobes, e := parseList(evalNprobe, strconv.Atoi)
+	if e != nil {
+		return
+	}
+
+	var manifold *govector.Manifold
+	if manifold, e = govector.NewManifold(input); e != nil {
+		return
+	}
+	if e = manifold.Open(); e != nil {
+		return
+	}
+	defer manifold.Close()
+
+	var words []int32
+	manifold.VisitWords(func(key string) bool {
+		words = append(words, manifold.WordID(key))
+		return true
+	})
+	sample := eval.Sample(words, evalQueries, evalSeed)
+	queries := make([][]float32, len(sample))
+	for i, id := range sample {
+		queries[i] = manifold.Row(id)
+	}
+	opts := eval.Options{K: evalK, Workers: threads, Filter: manifold.WordFilter(), QueryIDs: sample}
+	start := time.Now()
+	truth, e := eval.GroundTruth(manifold.FlatIndex(index.Angular), queries, opts)
+	if e != nil {
+		return
+	}
+	log.Printf("Ground truth of %d queries computed in %s", len(queries), time.Now().Sub(start))
+
+	var results []eval.Result
+	evaluate := func(name, params string, idx index.Index, build time.Duration) error {
+		result, e := eval.Evaluate(name, params, idx, queries, truth, opts)
+		if e != nil {
+			return e
+		}
+		result.Build = build
+		log.Printf("%s %s recall@%d %.4f %.1f QPS p50 %s p99 %s", name, params, result.K, result.Recall, result.QPS, result.P50, result.P99)
+		results = append(results, result)
+		return nil
+	}
+
+	for _, name := range strings.Split(evalIndexes, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "annoy":
+			dir, e := os.MkdirTemp("", "govin-eval")
+			if e != nil {
+				return e
+			}
+			defer os.RemoveAll(dir)
+			for _, trees := range treeCounts {
+				start := time.Now()
+				idx, e := manifold.LoadOrBuildAnnoy(filepath.Join(dir, fmt.Sprintf("%d.annoy", trees)), trees)
+				if e != nil {
+					return e
+				}
+				e = evaluate(name, fmt.Sprintf("trees=%d", trees), idx, time.Now().Sub(start))
+				idx.Close()
+				if e != nil {
+					return e
+				}
+			}
+		case "vptree":
+			start := time.Now()
+			idx, e := manifold.VPIndex()
+			if e != nil {
+				return e
+			}
+			build := time.Now().Sub(start)
+			for _, cutoff := range cutoffs {
+				idx.(*index.VPIndex).Cutoff = cutoff
+				if e := evaluate(name, fmt.Sprintf("cutoff=%g", cutoff), idx, build); e != nil {
+					return e
+				}
+			}
+		case "hnsw":
+			start := time.Now()
+			idx, e := manifold.HNSWIndex()
+			if e != nil {
+				return e
+			}
+			build := time.Now().Sub(start)
+			for _, ef := range efs {
+				idx.(*index.HNSW).EfSearch = ef
+				if e := evaluate(name, fmt.Sprintf("ef=%d", ef), idx, build); e != nil {
+					return e
+				}
+			}
+		case "ivf", "ivfpq":
+			subquantizers := 0
+			if name == "ivfpq" {
+				subquantizers = 16
+			}
+			start := time.Now()
+			idx, e := manifold.IVFIndex(0, subquantizers)
+			if e != nil {
+				return e
+			}
+			build := time.Now().Sub(start)
+			for _, nprobe := range nprobes {
+				idx.(*index.IVF).Nprobe = nprobe
+				if e := evaluate(name, fmt.Sprintf("nprobe=%d", nprobe), idx, build); e != nil {
+					return e
+				}
+			}
+		default:
+			return fmt.Errorf("Unknown index type %q", name)
+		}
+	}
+
+	if output == "" {
+		return eval.WriteCSV(os.Stdout, results)
+	}
+	f, e := os.Create(output)
+	if e != nil {
+		return e
+	}
+	if strings.HasSuffix(output, ".json") {
+		e = eval.WriteJSON(f, results)
+	} else {
+		e = eval.WriteCSV(f, results)
+	}
+	if e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
@@ -5,12 +5,17 @@ import (
 	"fmt"
 	"log"
 	"os"
+
+	"github.com/vseledkin/govector/index"
 )
 
 const (
 	build    = "build"
 	build_ft = "build_ft"
 	nearest  = "nearest"
+	indexCmd = "index"
+	evalCmd  = "eval-index"
+	kmeans   = "kmeans"
 )
 
 var threads int
@@ -18,6 +23,17 @@ var input, output string
 var cwd string
 
 var word string
+var indexType string
+var indexFile string
+var trees int
+var wordsOnly bool
+
+var evalIndexes, evalTrees, evalCutoffs, evalEf, evalNprobe string
+var evalK, evalQueries int
+var evalSeed int64
+
+var kmeansOptions index.KMeansOptions
+var centroidsOutput string
 
 func main() {
 	buildCommand := flag.NewFlagSet(build, flag.ExitOnError)
@@ -30,10 +46,43 @@ func main() {
 	buildFtCommand.IntVar(&threads, "threads", 2, "paralelizm factor")
 	buildFtCommand.StringVar(&output, "output", "", "dir to output index to")
 
-	nearestCommand := flag.NewFlagSet(build, flag.ExitOnError)
+	nearestCommand := flag.NewFlagSet(nearest, flag.ExitOnError)
 	nearestCommand.StringVar(&input, "input", "", "dir to load vectors from")
 	nearestCommand.IntVar(&threads, "threads", 2, "paralelizm factor")
 	nearestCommand.StringVar(&word, "word", "", "word to search nearest to")
+	nearestCommand.StringVar(&indexType, "index", "annoy", "index type: annoy, vptree, hnsw, ivf, ivfpq or flat")
+	nearestCommand.StringVar(&indexFile, "index-file", "", "annoy index file to load, it is built and saved when missing or stale")
+	nearestCommand.IntVar(&trees, "trees", 16, "number of annoy trees to build")
+	nearestCommand.BoolVar(&wordsOnly, "words-only", false, "return only words, never subwords")
+
+	indexBuildCommand := flag.NewFlagSet(indexCmd+" build", flag.ExitOnError)
+	indexBuildCommand.StringVar(&input, "input", "", "model to build index for")
+	indexBuildCommand.StringVar(&indexType, "index", "annoy", "index type: annoy, vptree, ivf or ivfpq")
+	indexBuildCommand.StringVar(&output, "output", "", "annoy index file, defaults to model file with .annoy suffix, other indexes are saved next to the model")
+	indexBuildCommand.IntVar(&trees, "trees", 16, "number of annoy trees to build")
+
+	evalCommand := flag.NewFlagSet(evalCmd, flag.ExitOnError)
+	evalCommand.StringVar(&input, "input", "", "model to evaluate indexes of")
+	evalCommand.StringVar(&evalIndexes, "index", "annoy,vptree,hnsw,ivf,ivfpq", "comma separated index types to evaluate")
+	evalCommand.IntVar(&evalK, "k", 10, "number of neighbours to search for")
+	evalCommand.IntVar(&evalQueries, "queries", 1000, "number of words sampled as queries")
+	evalCommand.Int64Var(&evalSeed, "seed", 1, "seed of query sampling")
+	evalCommand.IntVar(&threads, "threads", 1, "number of concurrent searches")
+	evalCommand.StringVar(&evalTrees, "trees", "8,16,32", "comma separated annoy tree counts")
+	evalCommand.StringVar(&evalCutoffs, "cutoffs", "0", "comma separated vptree cutoffs, 0 searches exactly")
+	evalCommand.StringVar(&evalEf, "ef", "16,50,100", "comma separated hnsw search list sizes")
+	evalCommand.StringVar(&evalNprobe, "nprobe", "1,4,8,16", "comma separated numbers of probed ivf lists")
+	evalCommand.StringVar(&output, "output", "", "report file, JSON when it ends with .json and CSV otherwise, CSV goes to stdout when empty")
+
+	kmeansCommand := flag.NewFlagSet(kmeans, flag.ExitOnError)
+	kmeansCommand.StringVar(&input, "input", "", "model to cluster words of")
+	kmeansCommand.IntVar(&kmeansOptions.K, "k", 100, "number of clusters")
+	kmeansCommand.IntVar(&kmeansOptions.Iterations, "iterations", 20, "maximum number of iterations, or number of mini-batches")
+	kmeansCommand.BoolVar(&kmeansOptions.Spherical, "spherical", true, "cluster by cosine similarity")
+	kmeansCommand.IntVar(&kmeansOptions.BatchSize, "batch", 0, "mini-batch size, 0 passes over all words every iteration")
+	kmeansCommand.Int64Var(&kmeansOptions.Seed, "seed", 1, "seed of centroid initialization")
+	kmeansCommand.StringVar(&output, "output", "", "file to write word and cluster lines to, stdout when empty")
+	kmeansCommand.StringVar(&centroidsOutput, "centroids", "", "file to write cluster and centroid lines to, centroids are not written when empty")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
@@ -49,6 +98,15 @@ func main() {
 		fmt.Fprintf(os.Stderr, "%s\n", nearest)
 		nearestCommand.PrintDefaults()
 
+		fmt.Fprintf(os.Stderr, "%s build\n", indexCmd)
+		indexBuildCommand.PrintDefaults()
+
+		fmt.Fprintf(os.Stderr, "%s\n", evalCmd)
+		evalCommand.PrintDefaults()
+
+		fmt.Fprintf(os.Stderr, "%s\n", kmeans)
+		kmeansCommand.PrintDefaults()
+
 		flag.PrintDefaults()
 	}
 	flag.Parse()
@@ -68,6 +126,16 @@ func main() {
 		buildFtCommand.Parse(os.Args[2:])
 	case nearest:
 		nearestCommand.Parse(os.Args[2:])
+	case indexCmd:
+		if len(os.Args) < 3 || os.Args[2] != "build" {
+			log.Printf("%s command expects build subcommand.\n", indexCmd)
+			os.Exit(1)
+		}
+		indexBuildCommand.Parse(os.Args[3:])
+	case evalCmd:
+		evalCommand.Parse(os.Args[2:])
+	case kmeans:
+		kmeansCommand.Parse(os.Args[2:])
 	default:
 		log.Printf("%q is not valid command.\n", os.Args[1])
 		os.Exit(1)
@@ -105,9 +173,46 @@ func main() {
 			nearestCommand.PrintDefaults()
 			return
 		}
-		fmt.Printf("yo")
-		//Nearest()
-		NearestAnnoy()
+		Nearest()
+		return
+	}
+
+	// INDEX BUILD COMMAND ISSUED
+	if indexBuildCommand.Parsed() {
+		if input == "" {
+			indexBuildCommand.PrintDefaults()
+			return
+		}
+		if e := IndexBuild(); e != nil {
+			log.Printf("Error %s", e)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// EVAL INDEX COMMAND ISSUED
+	if evalCommand.Parsed() {
+		if input == "" {
+			evalCommand.PrintDefaults()
+			return
+		}
+		if e := EvalIndex(); e != nil {
+			log.Printf("Error %s", e)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// KMEANS COMMAND ISSUED
+	if kmeansCommand.Parsed() {
+		if input == "" {
+			kmeansCommand.PrintDefaults()
+			return
+		}
+		if e := KMeans(); e != nil {
+			log.Printf("Error %s", e)
+			os.Exit(1)
+		}
 		return
 	}
 }
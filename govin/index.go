@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vseledkin/govector"
+)
+
+// IndexBuild builds index of the model so that nearest command can load it
+// instead of building on every start
+func IndexBuild() (e error) {
+	var manifold *govector.Manifold
+	if manifold, e = govector.NewManifold(input); e != nil {
+		return
+	}
+	if e = manifold.Open(); e != nil {
+		return
+	}
+	defer manifold.Close()
+
+	start := time.Now()
+	switch indexType {
+	case "annoy":
+		idx, e := manifold.LoadOrBuildAnnoy(output, trees)
+		if e != nil {
+			return e
+		}
+		defer idx.Close()
+		log.Printf("Index annoy ready for %d words %s", idx.Len(), time.Now().Sub(start))
+		return nil
+	case "vptree", "ivf", "ivfpq":
+		if output != "" {
+			return fmt.Errorf("Index %s is always saved next to the model", indexType)
+		}
+		idx, e := makeIndex(manifold)
+		if e != nil {
+			return e
+		}
+		log.Printf("Index %s ready for %d items %s", indexType, idx.Len(), time.Now().Sub(start))
+		return nil
+	}
+	return fmt.Errorf("Index %s can not be saved", indexType)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/vseledkin/govector"
+	"github.com/vseledkin/govector/index"
+)
+
+// KMeans clusters words of the model into topics and writes every word with
+// its cluster as tab separated lines, and centroids when centroidsOutput is
+// set
+func KMeans() (e error) {
+	var manifold *govector.Manifold
+	if manifold, e = govector.NewManifold(input); e != nil {
+		return
+	}
+	if e = manifold.Open(); e != nil {
+		return
+	}
+	defer manifold.Close()
+
+	var words []string
+	var ids []int32
+	manifold.VisitWords(func(key string) bool {
+		words = append(words, key)
+		ids = append(ids, manifold.WordID(key))
+		return true
+	})
+	start := time.Now()
+	km, e := manifold.KMeans(ids, kmeansOptions)
+	if e != nil {
+		return
+	}
+	log.Printf("Clustered %d words into %d clusters in %d iterations and %s, inertia %f",
+		len(words), km.K(), km.Iterations, time.Now().Sub(start), km.Inertia)
+
+	if centroidsOutput != "" {
+		if e = writeFile(centroidsOutput, func(w io.Writer) error { return writeCentroids(w, km) }); e != nil {
+			return
+		}
+	}
+	if output == "" {
+		return writeClusters(os.Stdout, words, km.Assignments)
+	}
+	return writeFile(output, func(w io.Writer) error { return writeClusters(w, words, km.Assignments) })
+}
+
+// writeFile creates file path and writes it with write
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+	if e = write(f); e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
+
+// writeClusters writes every word and its cluster as tab separated line
+func writeClusters(w io.Writer, words []string, clusters []int32) error {
+	buffered := bufio.NewWriter(w)
+	for i, word := range words {
+		if _, e := fmt.Fprintf(buffered, "%s\t%d\n", word, clusters[i]); e != nil {
+			return e
+		}
+	}
+	return buffered.Flush()
+}
+
+// writeCentroids writes every cluster and the values of its centroid as
+// space separated line
+func writeCentroids(w io.Writer, km *index.KMeans) error {
+	buffered := bufio.NewWriter(w)
+	for c := int32(0); c < int32(km.K()); c++ {
+		if _, e := fmt.Fprintf(buffered, "%d", c); e != nil {
+			return e
+		}
+		for _, v := range km.Centroid(c) {
+			if _, e := fmt.Fprintf(buffered, " %g", v); e != nil {
+				return e
+			}
+		}
+		if _, e := fmt.Fprintln(buffered); e != nil {
+			return e
+		}
+	}
+	return buffered.Flush()
+}
@@ -25,74 +25,31 @@ func readline(fi *bufio.Reader) (string, bool) {
 	return s[:len(s)-1], true
 }
 
-func Nearest() (e error) {
-	var manifold *govector.Manifold
-	manifold, e = govector.NewManifold(input)
-	if e != nil {
-		log.Printf("Error %s", e)
-		return
+func makeIndex(manifold *govector.Manifold) (index.Index, error) {
+	if indexFile != "" && indexType != "annoy" {
+		return nil, fmt.Errorf("Index file is supported for annoy index only")
 	}
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Defer Panic:", r)
+	switch indexType {
+	case "annoy":
+		if indexFile != "" {
+			return manifold.LoadOrBuildAnnoy(indexFile, trees)
 		}
-		manifold.Close()
-		log.Println("Closing manifold")
-	}()
-	e = manifold.Open()
-	defer func() {
-		manifold.Close()
-	}()
-	if e != nil {
-		log.Printf("Error %s", e)
-		return
+		return manifold.AnnoyIndex()
+	case "vptree":
+		return manifold.VPIndex()
+	case "hnsw":
+		return manifold.HNSWIndex()
+	case "ivf":
+		return manifold.IVFIndex(0, 0)
+	case "ivfpq":
+		return manifold.IVFIndex(0, 16)
+	case "flat":
+		return manifold.FlatIndex(index.Angular), nil
 	}
-	go func() {
-		start := time.Now()
-		idx := manifold.MakeVPIndex()
-		log.Printf("Index built for %d words %s %d metric calls\n", manifold.WordCount(), time.Now().Sub(start), index.MetricCalls)
-
-		search := func(word string) {
-			index.MetricCalls = 0
-			govector.CacheHit = 0
-			govector.CacheMiss = 0
-			start := time.Now()
-			words, distances := idx.Search(word, 35, 0)
-			fmt.Printf("Search for:%s %d metric calls hit:%d miss: %d\n", time.Now().Sub(start), index.MetricCalls, govector.CacheHit, govector.CacheMiss)
-			fmt.Println()
-			fmt.Printf("%12s \n", "Angular")
-			fmt.Println()
-			for i, word := range words {
-				fmt.Printf("%4d | %4.7f %s\n", i, distances[i], word)
-			}
-		}
-
-		if len(word) > 0 {
-			search(word)
-		} else {
-			fi := bufio.NewReader(os.NewFile(0, "stdin"))
-			for {
-				fmt.Printf("query: ")
-				if query, ok := readline(fi); ok {
-					if len(query) > 0 {
-						search(query)
-					}
-				} else {
-					break
-				}
-			}
-		}
-	}()
-
-	ch := make(chan os.Signal)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	<-ch
-	log.Println("Closing manifold")
-	manifold.Close()
-	return
+	return nil, fmt.Errorf("Unknown index type %q", indexType)
 }
 
-func NearestAnnoy() (e error) {
+func Nearest() (e error) {
 	var manifold *govector.Manifold
 	manifold, e = govector.NewManifold(input)
 	if e != nil {
@@ -107,37 +64,49 @@ func NearestAnnoy() (e error) {
 		log.Println("Closing manifold")
 	}()
 	e = manifold.Open()
-	defer func() {
-		manifold.Close()
-	}()
 	if e != nil {
 		log.Printf("Error %s", e)
 		return
 	}
 	go func() {
 		start := time.Now()
-		idx, items := manifold.AnnoyIndex()
-		log.Printf("Index built for %d words %s %d metric calls\n", manifold.WordCount(), time.Now().Sub(start), index.MetricCalls)
+		idx, e := makeIndex(manifold)
+		if e != nil {
+			log.Printf("Error %s", e)
+			return
+		}
+		log.Printf("Index %s built for %d words %s\n", indexType, idx.Len(), time.Now().Sub(start))
 
+		var filter index.Filter
+		if wordsOnly {
+			filter = manifold.WordFilter()
+		}
 		search := func(word string) {
-			index.MetricCalls = 0
 			govector.CacheHit = 0
 			govector.CacheMiss = 0
 			start := time.Now()
-			var sr []int
-			var distances []float32
 			v, e := manifold.GetVector(word)
 			if e != nil {
-				panic(e)
+				log.Printf("Error %s", e)
+				return
+			}
+			var neighbors []index.Neighbor
+			var stats index.SearchStats
+			if searcher, ok := idx.(index.StatsSearcher); ok {
+				neighbors, stats, e = searcher.SearchWithStats(v, 35, filter)
+			} else {
+				neighbors, e = idx.SearchFilter(v, 35, filter)
+			}
+			if e != nil {
+				log.Printf("Error %s", e)
+				return
 			}
-			idx.GetNnsByVector(v, 35, -1, &sr, &distances)
-			//words, distances := idx.Search(word, 35, 0)
-			fmt.Printf("Search for:%s %d metric calls hit:%d miss: %d\n", time.Now().Sub(start), index.MetricCalls, govector.CacheHit, govector.CacheMiss)
+			fmt.Printf("Search for:%s %d metric calls hit:%d miss: %d\n", time.Now().Sub(start), stats.Distances, govector.CacheHit, govector.CacheMiss)
 			fmt.Println()
-			fmt.Printf("%12s \n", "Angular")
+			fmt.Printf("%12s \n", idx.Metric())
 			fmt.Println()
-			for i, j := range sr {
-				fmt.Printf("%4d | %4.7f %s\n", i, distances[i], items[j])
+			for i, n := range neighbors {
+				fmt.Printf("%4d | %4.7f %s\n", i, n.Distance, n.Key)
 			}
 		}
 
@@ -158,10 +127,8 @@ func NearestAnnoy() (e error) {
 		}
 	}()
 
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	<-ch
-	log.Println("Closing manifold")
-	manifold.Close()
 	return
 }
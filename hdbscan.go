@@ -0,0 +1,454 @@
+package govector
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vseledkin/govector/index"
+)
+
+// HDBSCANOptions controls HDBSCAN
+type HDBSCANOptions struct {
+	// MinPts is the number of neighbours within core distance of a point,
+	// the point itself included
+	MinPts int
+	// MinClusterSize is the fewest points of a cluster, smaller groups
+	// splitting off a cluster are noise. MinPts is used when it is less
+	// than 2.
+	MinClusterSize int
+	// Metric is the distance between points when Index is nil
+	Metric index.Metric
+	// Index holds points under their ids, core distances and spanning tree
+	// edges are searched in it. All pairs of points are compared when it is
+	// nil, which is exact but takes quadratic time.
+	Index index.Index
+	// Neighbors is the number of nearest neighbours searched in Index for
+	// spanning tree edges, 2*MinPts when it is less than MinPts
+	Neighbors int
+}
+
+// DefaultHDBSCANOptions compares all pairs of points by angle
+var DefaultHDBSCANOptions = HDBSCANOptions{MinPts: 5, Metric: index.Angular}
+
+// CondensedEdge links a cluster of the condensed tree to a point or a
+// cluster which separates from it
+type CondensedEdge struct {
+	// Parent is a cluster, clusters are numbered from the number of points
+	// and the root is the first one
+	Parent int32
+	// Child is a point when it is less than the number of points,
+	// otherwise a cluster
+	Child int32
+	// Lambda is 1/distance at which the child separates from the parent
+	Lambda float32
+	// Size is the number of points of the child
+	Size int
+}
+
+// HDBSCANResult is the clustering found by HDBSCAN
+type HDBSCANResult struct {
+	// Labels are cluster labels of points, numbered from 0, or Noise
+	Labels []int
+	// Probabilities are strengths of cluster membership of points, 1 for
+	// points of the densest part of their cluster and 0 for noise
+	Probabilities []float32
+	// Core are core distances of points
+	Core []float32
+	// Stability holds stability of every cluster by label
+	Stability []float32
+	// Tree is the condensed cluster tree
+	Tree []CondensedEdge
+}
+
+// mstEdge is an edge of mutual reachability spanning tree
+type mstEdge struct {
+	a, b   int32
+	weight float32
+}
+
+// HDBSCAN clusters points by density with varying density threshold, see
+// Campello et al. "Density-Based Clustering Based on Hierarchical Density
+// Estimates". Points are connected by minimum spanning tree of mutual
+// reachability distances, its single linkage hierarchy is condensed to
+// clusters of at least MinClusterSize points and the most stable ones are
+// selected, so no epsilon has to be picked.
+func HDBSCAN(points [][]float32, opts HDBSCANOptions) (*HDBSCANResult, error) {
+	if opts.MinPts < 1 {
+		return nil, fmt.Errorf("MinPts %d is less than 1", opts.MinPts)
+	}
+	for i, p := range points {
+		if len(p) != len(points[0]) {
+			return nil, fmt.Errorf("Point %d has dimension %d, want %d", i, len(p), len(points[0]))
+		}
+	}
+	if opts.Index != nil && opts.Index.Len() != len(points) {
+		return nil, fmt.Errorf("Index holds %d points, want %d", opts.Index.Len(), len(points))
+	}
+	minClusterSize := opts.MinClusterSize
+	if minClusterSize < 2 {
+		minClusterSize = opts.MinPts
+	}
+	if minClusterSize < 2 {
+		minClusterSize = 2
+	}
+
+	var core []float32
+	var edges []mstEdge
+	if opts.Index == nil {
+		distance := Distance(opts.Metric)
+		core = exactCoreDistances(points, opts.MinPts, distance)
+		edges = primMST(points, core, distance)
+	} else {
+		var e error
+		if core, edges, e = indexMST(points, opts); e != nil {
+			return nil, e
+		}
+	}
+	result := condense(len(points), edges, minClusterSize)
+	result.Core = core
+	return result, nil
+}
+
+// HDBSCANClusters groups all words into HDBSCAN clusters of at least
+// minClusterSize words, core distances and spanning tree edges are searched
+// in angular Annoy forest of 16 trees. Words are returned by cluster label,
+// noise words under Noise.
+func (m *Manifold) HDBSCANClusters(minPts, minClusterSize int) (map[int][]string, error) {
+	words, points, idx, e := m.wordIndex()
+	if e != nil {
+		return nil, e
+	}
+	start := time.Now()
+	result, e := HDBSCAN(points, HDBSCANOptions{
+		MinPts:         minPts,
+		MinClusterSize: minClusterSize,
+		Metric:         index.Angular,
+		Index:          idx,
+	})
+	if e != nil {
+		return nil, e
+	}
+	log.Printf("Clustered %d words into %d clusters in %s", len(words), len(result.Stability), time.Now().Sub(start))
+	return groupWords(words, result.Labels), nil
+}
+
+// exactCoreDistances returns distances of every point to its minPts-th
+// nearest point, itself included, comparing all pairs
+func exactCoreDistances(points [][]float32, minPts int, distance func(x, y []float32) float32) []float32 {
+	core := make([]float32, len(points))
+	workers := runtime.NumCPU()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			distances := make([]float32, len(points))
+			for i := w; i < len(points); i += workers {
+				for j, p := range points {
+					distances[j] = distance(points[i], p)
+				}
+				core[i] = float32(math.Inf(1))
+				if minPts <= len(points) {
+					sort.Slice(distances, func(a, b int) bool { return distances[a] < distances[b] })
+					core[i] = distances[minPts-1]
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return core
+}
+
+// mutualReachability returns the larger of the distance and both core
+// distances
+func mutualReachability(d, coreA, coreB float32) float32 {
+	if coreA > d {
+		d = coreA
+	}
+	if coreB > d {
+		d = coreB
+	}
+	return d
+}
+
+// primMST returns minimum spanning tree of mutual reachability distances
+// of all pairs of points
+func primMST(points [][]float32, core []float32, distance func(x, y []float32) float32) []mstEdge {
+	if len(points) == 0 {
+		return nil
+	}
+	inTree := make([]bool, len(points))
+	best := make([]float32, len(points))
+	from := make([]int32, len(points))
+	for i := range best {
+		best[i] = float32(math.Inf(1))
+	}
+	edges := make([]mstEdge, 0, len(points)-1)
+	current := 0
+	inTree[0] = true
+	for len(edges) < len(points)-1 {
+		next := -1
+		for j, p := range points {
+			if inTree[j] {
+				continue
+			}
+			if d := mutualReachability(distance(points[current], p), core[current], core[j]); d < best[j] {
+				best[j], from[j] = d, int32(current)
+			}
+			if next < 0 || best[j] < best[next] {
+				next = j
+			}
+		}
+		inTree[next] = true
+		edges = append(edges, mstEdge{from[next], int32(next), best[next]})
+		current = next
+	}
+	return edges
+}
+
+// indexMST returns core distances searched in opts.Index and minimum
+// spanning forest of mutual reachability distances over nearest neighbour
+// graph, trees of the forest are joined by edges of infinite weight
+func indexMST(points [][]float32, opts HDBSCANOptions) ([]float32, []mstEdge, error) {
+	k := opts.Neighbors
+	if k < opts.MinPts {
+		k = 2 * opts.MinPts
+	}
+	results := index.SearchBatch(opts.Index, points, k, 0)
+	core := make([]float32, len(points))
+	for i, r := range results {
+		if r.Err != nil {
+			return nil, nil, fmt.Errorf("Point %d: %s", i, r.Err)
+		}
+		core[i] = float32(math.Inf(1))
+		if len(r.Neighbors) >= opts.MinPts {
+			core[i] = r.Neighbors[opts.MinPts-1].Distance
+		}
+	}
+	var graph []mstEdge
+	for i, r := range results {
+		for _, n := range r.Neighbors {
+			if n.ID != int32(i) {
+				graph = append(graph, mstEdge{int32(i), n.ID, mutualReachability(n.Distance, core[i], core[n.ID])})
+			}
+		}
+	}
+	sort.Slice(graph, func(i, j int) bool { return graph[i].weight < graph[j].weight })
+	sets := newDisjointSets(len(points))
+	var edges []mstEdge
+	for _, edge := range graph {
+		if sets.union(edge.a, edge.b) {
+			edges = append(edges, edge)
+		}
+	}
+	for i := 1; i < len(points); i++ {
+		if sets.union(0, int32(i)) {
+			edges = append(edges, mstEdge{0, int32(i), float32(math.Inf(1))})
+		}
+	}
+	return core, edges, nil
+}
+
+// disjointSets is union-find over n elements
+type disjointSets []int32
+
+func newDisjointSets(n int) disjointSets {
+	sets := make(disjointSets, n)
+	for i := range sets {
+		sets[i] = int32(i)
+	}
+	return sets
+}
+
+func (s disjointSets) find(x int32) int32 {
+	for s[x] != x {
+		s[x] = s[s[x]]
+		x = s[x]
+	}
+	return x
+}
+
+// union merges sets of a and b, it reports false when they are the same
+func (s disjointSets) union(a, b int32) bool {
+	a, b = s.find(a), s.find(b)
+	if a == b {
+		return false
+	}
+	s[b] = a
+	return true
+}
+
+// lambda returns density 1/distance, distance 0 gives the largest float32
+// so that stabilities stay finite
+func lambda(distance float32) float32 {
+	if distance == 0 {
+		return math.MaxFloat32
+	}
+	return 1 / distance
+}
+
+// condense builds single linkage hierarchy of n points from spanning tree
+// edges, condenses it to clusters of at least minClusterSize points and
+// selects clusters of excess of mass
+func condense(n int, edges []mstEdge, minClusterSize int) *HDBSCANResult {
+	result := &HDBSCANResult{
+		Labels:        make([]int, n),
+		Probabilities: make([]float32, n),
+	}
+	for i := range result.Labels {
+		result.Labels[i] = Noise
+	}
+	if n < 2 {
+		return result
+	}
+
+	// single linkage nodes n.. merge two nodes, points are nodes 0..n-1
+	sort.Slice(edges, func(i, j int) bool { return edges[i].weight < edges[j].weight })
+	nodes := 2*n - 1
+	left := make([]int32, nodes)
+	right := make([]int32, nodes)
+	height := make([]float32, nodes)
+	size := make([]int, nodes)
+	for i := 0; i < n; i++ {
+		size[i] = 1
+	}
+	sets := newDisjointSets(nodes)
+	for i, edge := range edges {
+		node := int32(n + i)
+		a, b := sets.find(edge.a), sets.find(edge.b)
+		left[node], right[node], height[node] = a, b, edge.weight
+		size[node] = size[a] + size[b]
+		sets[a], sets[b] = node, node
+	}
+
+	// leaves appends points of the subtree of node to points
+	leaves := func(node int32, points []int32) []int32 {
+		stack := []int32{node}
+		for len(stack) > 0 {
+			node, stack = stack[len(stack)-1], stack[:len(stack)-1]
+			if int(node) < n {
+				points = append(points, node)
+			} else {
+				stack = append(stack, left[node], right[node])
+			}
+		}
+		return points
+	}
+
+	// walk the hierarchy from the root, a node splitting into two large
+	// enough parts gives two clusters, smaller parts fall out as points
+	cluster := make([]int32, nodes)
+	root := int32(nodes - 1)
+	cluster[root] = int32(n)
+	next := int32(n + 1)
+	queue := []int32{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		l := lambda(height[node])
+		parent := cluster[node]
+		large := func(child int32) bool { return size[child] >= minClusterSize }
+		for _, child := range []int32{left[node], right[node]} {
+			switch {
+			case large(left[node]) && large(right[node]):
+				cluster[child] = next
+				next++
+				result.Tree = append(result.Tree, CondensedEdge{parent, cluster[child], l, size[child]})
+				queue = append(queue, child)
+			case large(child):
+				cluster[child] = parent
+				queue = append(queue, child)
+			default:
+				for _, p := range leaves(child, nil) {
+					result.Tree = append(result.Tree, CondensedEdge{parent, p, l, 1})
+				}
+			}
+		}
+	}
+
+	// stability sums lambdas points spend in a cluster since its birth
+	clusters := int(next) - n
+	birth := make([]float64, clusters)
+	stability := make([]float64, clusters)
+	parentOf := make([]int32, clusters)
+	children := make([][]int32, clusters)
+	parentOf[0] = -1
+	for _, edge := range result.Tree {
+		if int(edge.Child) >= n {
+			c := int(edge.Child) - n
+			birth[c] = float64(edge.Lambda)
+			parentOf[c] = edge.Parent - int32(n)
+			children[edge.Parent-int32(n)] = append(children[edge.Parent-int32(n)], int32(c))
+		}
+	}
+	for _, edge := range result.Tree {
+		p := int(edge.Parent) - n
+		stability[p] += (float64(edge.Lambda) - birth[p]) * float64(edge.Size)
+	}
+
+	// children are numbered after their parents, so clusters are visited
+	// bottom up; the root is never selected
+	selected := make([]bool, clusters)
+	own := append([]float64(nil), stability...)
+	for c := clusters - 1; c > 0; c-- {
+		var sum float64
+		for _, child := range children[c] {
+			sum += stability[child]
+		}
+		if len(children[c]) > 0 && sum > stability[c] {
+			stability[c] = sum
+			continue
+		}
+		selected[c] = true
+		stack := append([]int32(nil), children[c]...)
+		for len(stack) > 0 {
+			d := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			selected[d] = false
+			stack = append(stack, children[d]...)
+		}
+	}
+
+	// points are labelled by the selected cluster they fell out of or its
+	// selected ancestor
+	label := make([]int, clusters)
+	for c := 0; c < clusters; c++ {
+		switch {
+		case selected[c]:
+			label[c] = len(result.Stability)
+			result.Stability = append(result.Stability, float32(own[c]))
+		case c == 0:
+			label[c] = Noise
+		default:
+			label[c] = label[parentOf[c]]
+		}
+	}
+	maxLambda := make([]float32, len(result.Stability))
+	for _, edge := range result.Tree {
+		if int(edge.Child) < n {
+			if l := label[int(edge.Parent)-n]; l != Noise {
+				result.Labels[edge.Child] = l
+				result.Probabilities[edge.Child] = edge.Lambda
+				if edge.Lambda > maxLambda[l] {
+					maxLambda[l] = edge.Lambda
+				}
+			}
+		}
+	}
+	for i, l := range result.Labels {
+		if l == Noise {
+			continue
+		}
+		if maxLambda[l] == 0 {
+			result.Probabilities[i] = 1
+		} else {
+			result.Probabilities[i] /= maxLambda[l]
+		}
+	}
+	return result
+}
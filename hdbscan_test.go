@@ -0,0 +1,119 @@
+package govector
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/vseledkin/govector/index"
+)
+
+// checkHDBSCAN fails unless blobs of size consecutive points are clusters
+// of their own with at most misses points labelled otherwise
+func checkHDBSCAN(t *testing.T, result *HDBSCANResult, blobs, size, misses int) {
+	t.Helper()
+	if len(result.Stability) != blobs {
+		t.Fatalf("%d clusters, want %d", len(result.Stability), blobs)
+	}
+	checkBlobLabels(t, result.Labels, blobs, size, misses)
+	top := make([]float32, blobs)
+	for i, label := range result.Labels {
+		p := result.Probabilities[i]
+		if label == Noise {
+			if p != 0 {
+				t.Fatalf("Noise point %d has probability %f", i, p)
+			}
+			continue
+		}
+		if p <= 0 || p > 1 {
+			t.Fatalf("Point %d has probability %f", i, p)
+		}
+		if p > top[label] {
+			top[label] = p
+		}
+	}
+	for label, p := range top {
+		if p != 1 {
+			t.Fatalf("Cluster %d has no point of probability 1", label)
+		}
+	}
+}
+
+func TestHDBSCAN(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	// blobs of different density, which no single epsilon separates well
+	var points [][]float32
+	for b, sigma := range []float32{0.3, 0.8, 1.5} {
+		center := []float32{float32(b) * 12, float32(b%2) * 8}
+		points = append(points, gaussianBlobs(r, [][]float32{center}, 80, sigma, 0)...)
+	}
+	// outliers, too few to make a cluster
+	for i := 0; i < 6; i++ {
+		points = append(points, []float32{40 + 10*float32(i), -30 + 7*float32(i%3)})
+	}
+
+	result, e := HDBSCAN(points, HDBSCANOptions{MinPts: 5, MinClusterSize: 10, Metric: index.Euclidean})
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkHDBSCAN(t, result, 3, 80, 8)
+	for i := 240; i < len(points); i++ {
+		if result.Labels[i] != Noise {
+			t.Fatalf("Outlier %d labelled %d", i, result.Labels[i])
+		}
+	}
+
+	// every point leaves the condensed tree once, clusters are born from
+	// their parents at lower density
+	seen := make([]bool, len(points))
+	born := map[int32]float32{int32(len(points)): 0}
+	for _, edge := range result.Tree {
+		if edge.Parent < int32(len(points)) {
+			t.Fatalf("Edge %+v has point parent", edge)
+		}
+		if edge.Lambda < born[edge.Parent] {
+			t.Fatalf("Edge %+v precedes birth of parent at %f", edge, born[edge.Parent])
+		}
+		if edge.Child >= int32(len(points)) {
+			born[edge.Child] = edge.Lambda
+			continue
+		}
+		if seen[edge.Child] {
+			t.Fatalf("Point %d leaves the tree twice", edge.Child)
+		}
+		seen[edge.Child] = true
+	}
+	for i, ok := range seen {
+		if !ok {
+			t.Fatalf("Point %d never leaves the tree", i)
+		}
+	}
+
+	// core distances and spanning tree searched in an index
+	matrix := make([]float32, 0, 2*len(points))
+	for _, p := range points {
+		matrix = append(matrix, p...)
+	}
+	indexed, e := HDBSCAN(points, HDBSCANOptions{
+		MinPts:         5,
+		MinClusterSize: 10,
+		Index:          NewFlatIndex(matrix, 2, index.Euclidean),
+		Neighbors:      30,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i := range points {
+		if math.Abs(float64(indexed.Core[i]-result.Core[i])) > 1e-4 {
+			t.Fatalf("Core distance of point %d is %f, want %f", i, indexed.Core[i], result.Core[i])
+		}
+	}
+	checkHDBSCAN(t, indexed, 3, 80, 8)
+
+	if _, e := HDBSCAN(points, HDBSCANOptions{}); e == nil {
+		t.Fatal("MinPts 0 must fail")
+	}
+	if _, e := HDBSCAN(points[:5], HDBSCANOptions{MinPts: 2, Index: NewFlatIndex(matrix, 2, index.Euclidean)}); e == nil {
+		t.Fatal("Index of other points must fail")
+	}
+}
@@ -0,0 +1,598 @@
+package index
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/vseledkin/govector/mmap"
+)
+
+// twoMeansSteps is the number of iterations Annoy spends looking for a split
+const twoMeansSteps = 200
+
+// annoyLayout describes Annoy's node record in 4 byte words. All nodes have
+// the same size: items store their vector, split nodes store the normal of
+// the split plane and two children, and nodes with at most k descendants
+// store the list of descendant items in place of the vector.
+type annoyLayout struct {
+	// word offsets of children, the vector and the metric specific extra
+	// value (Euclidean plane offset or dot product factor), -1 if absent
+	children, v, extra int
+	words              int
+	k                  int
+}
+
+func newAnnoyLayout(metric Metric, dim int) (l annoyLayout, e error) {
+	switch metric {
+	case Angular, Cosine:
+		// n_descendants, children[2], v[dim]
+		l = annoyLayout{children: 1, v: 3, extra: -1}
+	case Euclidean:
+		// n_descendants, a, children[2], v[dim]
+		l = annoyLayout{children: 2, v: 4, extra: 1}
+	case DotProduct:
+		// n_descendants, children[2], dot_factor, v[dim]
+		l = annoyLayout{children: 1, v: 4, extra: 3}
+	default:
+		return l, fmt.Errorf("Annoy does not support metric %s", metric)
+	}
+	l.words = l.v + dim
+	l.k = l.words - l.children
+	return
+}
+
+// Annoy is a pure Go forest of random projection trees which reads and
+// writes the on-disk format of Spotify's Annoy, so files built by either
+// implementation can be searched by the other. Item ids are Annoy items:
+// nodes are allocated for every id up to the largest one, so ids should be
+// dense.
+type Annoy struct {
+	dim      int
+	metric   Metric
+	kernels  Kernels
+	distance func(x, y []float32) float32
+	layout   annoyLayout
+	nodes    []float32
+	nItems   int32
+	nNodes   int32
+	roots    []int32
+	random   *kiss64
+	built    bool
+	mapped   *mmap.ReaderAt
+	// Trees is the number of trees Build makes, -1 builds trees until the
+	// index takes twice the memory of the items
+	Trees int
+	// SearchK is the number of nodes inspected during search, -1 means
+	// Trees * k
+	SearchK int
+	// Key labels search results, it may be nil
+	Key func(id int32) string
+}
+
+// NewAnnoy creates an empty forest of dim dimensional vectors ranked by
+// metric, which must be Angular, Euclidean or DotProduct (Cosine uses
+// angular trees). kernels compute distances and split margins.
+func NewAnnoy(dim int, metric Metric, kernels Kernels) (*Annoy, error) {
+	layout, e := newAnnoyLayout(metric, dim)
+	if e != nil {
+		return nil, e
+	}
+	return &Annoy{
+		dim:      dim,
+		metric:   metric,
+		kernels:  kernels,
+		distance: kernels.Distance(metric),
+		layout:   layout,
+		random:   newKiss64(1234567890987654321),
+		Trees:    -1,
+		SearchK:  -1,
+	}, nil
+}
+
+// SetSeed seeds random number generator used to build trees
+func (a *Annoy) SetSeed(seed uint32) {
+	a.random.x = uint64(seed)
+}
+
+func (a *Annoy) node(i int32) []float32 {
+	return a.nodes[int(i)*a.layout.words : int(i+1)*a.layout.words]
+}
+
+func getInt(n []float32, word int) int32 {
+	return int32(math.Float32bits(n[word]))
+}
+
+func setInt(n []float32, word int, v int32) {
+	n[word] = math.Float32frombits(uint32(v))
+}
+
+func (a *Annoy) descendants(n []float32) int32 {
+	return getInt(n, 0)
+}
+
+func (a *Annoy) child(n []float32, side int) int32 {
+	return getInt(n, a.layout.children+side)
+}
+
+func (a *Annoy) vector(n []float32) []float32 {
+	return n[a.layout.v : a.layout.v+a.dim]
+}
+
+// allocate appends a zeroed node and returns its number
+func (a *Annoy) allocate() int32 {
+	for i := 0; i < a.layout.words; i++ {
+		a.nodes = append(a.nodes, 0)
+	}
+	a.nNodes++
+	return a.nNodes - 1
+}
+
+// Add adds vector v as Annoy item id
+func (a *Annoy) Add(id int32, v []float32) error {
+	if a.built {
+		return fmt.Errorf("Can not add item %d to built index", id)
+	}
+	if id < 0 {
+		return fmt.Errorf("Negative item %d", id)
+	}
+	if len(v) != a.dim {
+		return fmt.Errorf("Vector dimension %d does not match index dimension %d", len(v), a.dim)
+	}
+	for a.nNodes <= id {
+		a.allocate()
+	}
+	n := a.node(id)
+	setInt(n, 0, 1)
+	copy(a.vector(n), v)
+	if id >= a.nItems {
+		a.nItems = id + 1
+	}
+	return nil
+}
+
+// Build builds the forest, no items can be added afterwards
+func (a *Annoy) Build() error {
+	if a.built {
+		return fmt.Errorf("Index is already built")
+	}
+	var indices []int32
+	for i := int32(0); i < a.nItems; i++ {
+		if a.descendants(a.node(i)) == 1 {
+			indices = append(indices, i)
+		}
+	}
+	if a.metric == DotProduct {
+		a.setDotFactors(indices)
+	}
+	for len(indices) > 0 {
+		if a.Trees == -1 && a.nNodes >= a.nItems*2 {
+			break
+		}
+		if a.Trees != -1 && len(a.roots) >= a.Trees {
+			break
+		}
+		a.roots = append(a.roots, a.makeTree(append([]int32(nil), indices...)))
+		if len(indices) == 1 {
+			// a single item tree never grows the index
+			break
+		}
+	}
+	// copy roots to the end of nodes so loading finds them without
+	// scanning the whole file
+	for _, root := range a.roots {
+		copy(a.node(a.allocate()), a.node(root))
+	}
+	a.built = true
+	return nil
+}
+
+// setDotFactors reduces maximum inner product search to angular search by
+// giving every item an extra component so that all items have the norm of
+// the longest one
+func (a *Annoy) setDotFactors(indices []int32) {
+	var maxNorm float32
+	for _, i := range indices {
+		v := a.vector(a.node(i))
+		if n := a.kernels.Dot(v, v); n > maxNorm {
+			maxNorm = n
+		}
+	}
+	for _, i := range indices {
+		n := a.node(i)
+		v := a.vector(n)
+		n[a.layout.extra] = float32(math.Sqrt(math.Max(0, float64(maxNorm-a.kernels.Dot(v, v)))))
+	}
+}
+
+// point returns vector of item i trees are split on, DotProduct items are
+// extended with their dot factor
+func (a *Annoy) point(i int32) []float32 {
+	n := a.node(i)
+	if a.metric != DotProduct {
+		return a.vector(n)
+	}
+	return append(append(make([]float32, 0, a.dim+1), a.vector(n)...), n[a.layout.extra])
+}
+
+func (a *Annoy) makeTree(indices []int32) int32 {
+	if len(indices) == 1 {
+		return indices[0]
+	}
+	if len(indices) <= a.layout.k {
+		item := a.allocate()
+		n := a.node(item)
+		setInt(n, 0, int32(len(indices)))
+		for i, j := range indices {
+			setInt(n, a.layout.children+i, j)
+		}
+		return item
+	}
+
+	m := make([]float32, a.layout.words)
+	a.createSplit(indices, m)
+
+	var sides [2][]int32
+	for _, j := range indices {
+		side := a.side(m, a.node(j))
+		sides[side] = append(sides[side], j)
+	}
+	// If we didn't find a hyperplane, just randomize sides as a last option
+	for len(sides[0]) == 0 || len(sides[1]) == 0 {
+		sides[0], sides[1] = sides[0][:0], sides[1][:0]
+		for i := range a.vector(m) {
+			a.vector(m)[i] = 0
+		}
+		if a.layout.extra >= 0 {
+			m[a.layout.extra] = 0
+		}
+		for _, j := range indices {
+			side := a.random.flip()
+			sides[side] = append(sides[side], j)
+		}
+	}
+
+	flip := 0
+	if len(sides[0]) > len(sides[1]) {
+		flip = 1
+	}
+	setInt(m, 0, int32(len(indices)))
+	// build the smallest child first for cache locality
+	for side := 0; side < 2; side++ {
+		setInt(m, a.layout.children+(side^flip), a.makeTree(sides[side^flip]))
+	}
+	item := a.allocate()
+	copy(a.node(item), m)
+	return item
+}
+
+// createSplit stores in m the normal of a plane separating two centroids
+// found among items
+func (a *Annoy) createSplit(indices []int32, m []float32) {
+	cosine := a.metric != Euclidean
+	iv, jv := a.twoMeans(indices, cosine)
+	normal := make([]float32, len(iv))
+	for z := range iv {
+		normal[z] = iv[z] - jv[z]
+	}
+	normalize(a.kernels, normal)
+	copy(a.vector(m), normal)
+	switch a.metric {
+	case Euclidean:
+		// the plane passes through the middle of the centroids
+		var offset float32
+		for z := range normal {
+			offset += -normal[z] * (iv[z] + jv[z]) / 2
+		}
+		m[a.layout.extra] = offset
+	case DotProduct:
+		m[a.layout.extra] = normal[a.dim]
+	}
+}
+
+func normalize(k Kernels, v []float32) {
+	n := float32(math.Sqrt(float64(k.Dot(v, v))))
+	if n == 0 {
+		return
+	}
+	for i := range v {
+		v[i] /= n
+	}
+}
+
+// twoMeans is Annoy's heuristic: keep two centroids of random items and
+// assign random items to the closer one weighted by its size, which keeps
+// the split balanced
+func (a *Annoy) twoMeans(indices []int32, cosine bool) (iv, jv []float32) {
+	count := len(indices)
+	i := a.random.index(count)
+	j := a.random.index(count - 1)
+	if j >= i {
+		j++
+	}
+	iv = append([]float32(nil), a.point(indices[i])...)
+	jv = append([]float32(nil), a.point(indices[j])...)
+	distance := a.kernels.SqDist
+	if cosine {
+		normalize(a.kernels, iv)
+		normalize(a.kernels, jv)
+		// Annoy's Angular::distance, squared distance of unit vectors
+		distance = func(x, y []float32) float32 {
+			ppqq := float64(a.kernels.Dot(x, x)) * float64(a.kernels.Dot(y, y))
+			if ppqq <= 0 {
+				return 2
+			}
+			return 2 - 2*float32(float64(a.kernels.Dot(x, y))/math.Sqrt(ppqq))
+		}
+	}
+
+	ic, jc := float32(1), float32(1)
+	for l := 0; l < twoMeansSteps; l++ {
+		p := a.point(indices[a.random.index(count)])
+		di := ic * distance(iv, p)
+		dj := jc * distance(jv, p)
+		norm := float32(1)
+		if cosine {
+			norm = float32(math.Sqrt(float64(a.kernels.Dot(p, p))))
+			if norm == 0 {
+				continue
+			}
+		}
+		if di < dj {
+			for z := range iv {
+				iv[z] = (iv[z]*ic + p[z]/norm) / (ic + 1)
+			}
+			ic++
+		} else if dj < di {
+			for z := range jv {
+				jv[z] = (jv[z]*jc + p[z]/norm) / (jc + 1)
+			}
+			jc++
+		}
+	}
+	return
+}
+
+// margin is the signed distance of y from the split plane of node n, factor
+// is the dot factor of y which is zero for queries
+func (a *Annoy) margin(n, y []float32, factor float32) float32 {
+	dot := a.kernels.Dot(a.vector(n), y)
+	switch a.metric {
+	case Euclidean:
+		dot += n[a.layout.extra]
+	case DotProduct:
+		dot += n[a.layout.extra] * factor
+	}
+	return dot
+}
+
+// side returns the child of split node m item node n belongs to
+func (a *Annoy) side(m, n []float32) int {
+	var factor float32
+	if a.metric == DotProduct {
+		factor = n[a.layout.extra]
+	}
+	dot := a.margin(m, a.vector(n), factor)
+	if dot != 0 {
+		if dot > 0 {
+			return 1
+		}
+		return 0
+	}
+	return a.random.flip()
+}
+
+// annoyQueue is a max priority queue of nodes to visit
+type annoyQueue []annoyEntry
+
+type annoyEntry struct {
+	priority float32
+	node     int32
+}
+
+func (q annoyQueue) Len() int            { return len(q) }
+func (q annoyQueue) Less(i, j int) bool  { return q[i].priority > q[j].priority }
+func (q annoyQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *annoyQueue) Push(x interface{}) { *q = append(*q, x.(annoyEntry)) }
+func (q *annoyQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Search returns up to k neighbours of v
+func (a *Annoy) Search(v []float32, k int) ([]Neighbor, error) {
+	return a.SearchFilter(v, k, nil)
+}
+
+// SearchFilter returns up to k neighbours of v accepted by filter. Only
+// accepted items count towards SearchK, so trees are walked further when
+// filter rejects most items.
+func (a *Annoy) SearchFilter(v []float32, k int, filter Filter) ([]Neighbor, error) {
+	neighbors, _, e := a.SearchWithStats(v, k, filter)
+	return neighbors, e
+}
+
+// SearchWithStats is SearchFilter which also returns search statistics,
+// margins of split planes are not counted as distances
+func (a *Annoy) SearchWithStats(v []float32, k int, filter Filter) ([]Neighbor, SearchStats, error) {
+	start := time.Now()
+	if !a.built {
+		return nil, SearchStats{}, fmt.Errorf("Index is not built")
+	}
+	if len(v) != a.dim {
+		return nil, SearchStats{}, fmt.Errorf("Query dimension %d does not match index dimension %d", len(v), a.dim)
+	}
+	neighbors, calls := a.search(v, k, filter)
+	return neighbors, SearchStats{Distances: calls, Duration: time.Since(start)}, nil
+}
+
+// SearchID returns up to k neighbours of item id
+func (a *Annoy) SearchID(id int32, k int) ([]Neighbor, error) {
+	if !a.built {
+		return nil, fmt.Errorf("Index is not built")
+	}
+	if id < 0 || id >= a.nItems || a.descendants(a.node(id)) != 1 {
+		return nil, fmt.Errorf("Item %d is not in index", id)
+	}
+	neighbors, _ := a.search(a.vector(a.node(id)), k, nil)
+	return neighbors, nil
+}
+
+// search returns up to k neighbours of v and the number of computed
+// distances
+func (a *Annoy) search(v []float32, k int, filter Filter) ([]Neighbor, int) {
+	if k < 1 {
+		return nil, 0
+	}
+	searchK := a.SearchK
+	if searchK < 0 {
+		searchK = k * len(a.roots)
+	}
+	q := make(annoyQueue, 0, len(a.roots))
+	for _, root := range a.roots {
+		q = append(q, annoyEntry{float32(math.Inf(1)), root})
+	}
+	heap.Init(&q)
+
+	var nns []int32
+	for len(nns) < searchK && len(q) > 0 {
+		top := heap.Pop(&q).(annoyEntry)
+		n := a.node(top.node)
+		descendants := a.descendants(n)
+		if descendants == 1 && top.node < a.nItems {
+			if filter.accepts(top.node) {
+				nns = append(nns, top.node)
+			}
+		} else if int(descendants) <= a.layout.k {
+			for i := 0; i < int(descendants); i++ {
+				if item := getInt(n, a.layout.children+i); filter.accepts(item) {
+					nns = append(nns, item)
+				}
+			}
+		} else {
+			margin := a.margin(n, v, 0)
+			heap.Push(&q, annoyEntry{minFloat32(top.priority, margin), a.child(n, 1)})
+			heap.Push(&q, annoyEntry{minFloat32(top.priority, -margin), a.child(n, 0)})
+		}
+	}
+
+	// compute distance once for every item
+	sort.Slice(nns, func(i, j int) bool { return nns[i] < nns[j] })
+	neighbors := make([]Neighbor, 0, len(nns))
+	for i, j := range nns {
+		if i > 0 && nns[i-1] == j {
+			continue
+		}
+		neighbors = append(neighbors, Neighbor{ID: j, Distance: a.distance(v, a.vector(a.node(j)))})
+	}
+	calls := len(neighbors)
+	sort.Slice(neighbors, func(i, j int) bool { return neighbors[i].Distance < neighbors[j].Distance })
+	if len(neighbors) > k {
+		neighbors = neighbors[:k]
+	}
+	if a.Key != nil {
+		for i := range neighbors {
+			neighbors[i].Key = a.Key(neighbors[i].ID)
+		}
+	}
+	return neighbors, calls
+}
+
+func minFloat32(x, y float32) float32 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+// Save writes nodes in Annoy's file format
+func (a *Annoy) Save(path string) error {
+	if !a.built {
+		return fmt.Errorf("Index is not built")
+	}
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+	w := bufio.NewWriter(f)
+	if e = binary.Write(w, binary.LittleEndian, a.nodes[:int(a.nNodes)*a.layout.words]); e != nil {
+		f.Close()
+		return e
+	}
+	if e = w.Flush(); e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
+
+// Load memory maps an Annoy file, the index is searched in place and can not
+// be modified. Roots are found the way Annoy does: they are the trailing
+// nodes with the largest number of descendants.
+func (a *Annoy) Load(path string) error {
+	mapped, e := mmap.Open(path)
+	if e != nil {
+		return e
+	}
+	size := a.layout.words * 4
+	if len(mapped.Data) == 0 || len(mapped.Data)%size != 0 {
+		mapped.Close()
+		return fmt.Errorf("Size of %s is not a multiple of %d byte nodes", path, size)
+	}
+	a.Close()
+	a.mapped = mapped
+	a.nodes = unsafe.Slice((*float32)(unsafe.Pointer(&mapped.Data[0])), len(mapped.Data)/4)
+	a.nNodes = int32(len(mapped.Data) / size)
+
+	var m int32 = -1
+	a.roots = a.roots[:0]
+	for i := a.nNodes - 1; i >= 0; i-- {
+		k := a.descendants(a.node(i))
+		if m == -1 || k == m {
+			a.roots = append(a.roots, i)
+			m = k
+		} else {
+			break
+		}
+	}
+	// since the last root precedes the copy of all roots, delete it
+	if len(a.roots) > 1 && a.child(a.node(a.roots[0]), 0) == a.child(a.node(a.roots[len(a.roots)-1]), 0) {
+		a.roots = a.roots[:len(a.roots)-1]
+	}
+	a.nItems = m
+	a.built = true
+	return nil
+}
+
+// Close unmaps a loaded index and empties the index
+func (a *Annoy) Close() error {
+	var e error
+	if a.mapped != nil {
+		e = a.mapped.Close()
+		a.mapped = nil
+	}
+	a.nodes = nil
+	a.nNodes = 0
+	a.nItems = 0
+	a.roots = nil
+	a.built = false
+	return e
+}
+
+// Len returns number of items
+func (a *Annoy) Len() int {
+	return int(a.nItems)
+}
+
+// Metric returns the metric neighbours are ranked by
+func (a *Annoy) Metric() Metric {
+	return a.metric
+}
@@ -0,0 +1,174 @@
+package index
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func exactNeighbors(vectors [][]float32, query []float32, distance func(x, y []float32) float32, k int) []Neighbor {
+	var all []Neighbor
+	for i, v := range vectors {
+		all = append(all, Neighbor{ID: int32(i), Distance: distance(query, v)})
+	}
+	sortNeighbors(all)
+	if len(all) > k {
+		all = all[:k]
+	}
+	return all
+}
+
+func sortNeighbors(neighbors []Neighbor) {
+	for i := 1; i < len(neighbors); i++ {
+		for j := i; j > 0 && neighbors[j].Distance < neighbors[j-1].Distance; j-- {
+			neighbors[j], neighbors[j-1] = neighbors[j-1], neighbors[j]
+		}
+	}
+}
+
+func recall(got, want []Neighbor) float64 {
+	found := make(map[int32]bool)
+	for _, n := range got {
+		found[n.ID] = true
+	}
+	hits := 0
+	for _, n := range want {
+		if found[n.ID] {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(want))
+}
+
+func TestAnnoyRecall(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	vectors := randomVectors(r, 2000, 16)
+	for _, metric := range []Metric{Angular, Euclidean, DotProduct} {
+		idx, e := NewAnnoy(16, metric, GoKernels)
+		if e != nil {
+			t.Fatal(e)
+		}
+		idx.Trees = 10
+		for i, v := range vectors {
+			if e := idx.Add(int32(i), v); e != nil {
+				t.Fatal(e)
+			}
+		}
+		if e := idx.Build(); e != nil {
+			t.Fatal(e)
+		}
+		idx.SearchK = 2000
+		distance := GoKernels.Distance(metric)
+		var total float64
+		for q := 0; q < 50; q++ {
+			query := randomVectors(r, 1, 16)[0]
+			got, e := idx.Search(query, 10)
+			if e != nil {
+				t.Fatal(e)
+			}
+			total += recall(got, exactNeighbors(vectors, query, distance, 10))
+		}
+		if total/50 < 0.9 {
+			t.Fatalf("%s recall@10 %f is too low", metric, total/50)
+		}
+	}
+}
+
+func TestAnnoySaveLoad(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	vectors := randomVectors(r, 500, 8)
+	path := filepath.Join(t.TempDir(), "test.ann")
+
+	idx, _ := NewAnnoy(8, Euclidean, GoKernels)
+	idx.Trees = 5
+	for i, v := range vectors {
+		idx.Add(int32(i), v)
+	}
+	if e := idx.Build(); e != nil {
+		t.Fatal(e)
+	}
+	if e := idx.Save(path); e != nil {
+		t.Fatal(e)
+	}
+	info, e := os.Stat(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := int64(idx.nNodes) * int64(idx.layout.words) * 4; info.Size() != want {
+		t.Fatalf("want file of %d bytes got %d", want, info.Size())
+	}
+
+	loaded, _ := NewAnnoy(8, Euclidean, GoKernels)
+	if e := loaded.Load(path); e != nil {
+		t.Fatal(e)
+	}
+	defer loaded.Close()
+	if loaded.Len() != len(vectors) || len(loaded.roots) != 5 {
+		t.Fatalf("want %d items and 5 roots got %d and %d", len(vectors), loaded.Len(), len(loaded.roots))
+	}
+	for q := 0; q < 10; q++ {
+		query := randomVectors(r, 1, 8)[0]
+		want, _ := idx.Search(query, 5)
+		got, _ := loaded.Search(query, 5)
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("loaded index results differ\nwant %v\n got %v", want, got)
+		}
+	}
+	if e := loaded.Add(1000, vectors[0]); e == nil {
+		t.Fatal("loaded index must be read only")
+	}
+}
+
+// TestAnnoyFormat loads a file laid out by hand the way Annoy writes it
+func TestAnnoyFormat(t *testing.T) {
+	// angular nodes of dimension 2: n_descendants, children[2], v[2]
+	node := func(descendants int32, children []int32, v []float32) []uint32 {
+		words := make([]uint32, 5)
+		words[0] = uint32(descendants)
+		for i, c := range children {
+			words[1+i] = uint32(c)
+		}
+		for i, x := range v {
+			words[3+i] = math.Float32bits(x)
+		}
+		return words
+	}
+	var words []uint32
+	words = append(words, node(1, nil, []float32{1, 0})...)
+	words = append(words, node(1, nil, []float32{0, 1})...)
+	words = append(words, node(1, nil, []float32{-1, 0})...)
+	// three items fit into a node in place of the vector
+	root := node(3, []int32{0, 1, 2, 0}, nil)
+	words = append(words, root...)
+	// copy of the root
+	words = append(words, root...)
+
+	path := filepath.Join(t.TempDir(), "hand.ann")
+	f, e := os.Create(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	binary.Write(f, binary.LittleEndian, words)
+	f.Close()
+
+	idx, _ := NewAnnoy(2, Angular, GoKernels)
+	if e := idx.Load(path); e != nil {
+		t.Fatal(e)
+	}
+	defer idx.Close()
+	if idx.Len() != 3 || !reflect.DeepEqual(idx.roots, []int32{4}) {
+		t.Fatalf("want 3 items and root 4 got %d items and roots %v", idx.Len(), idx.roots)
+	}
+	neighbors, e := idx.SearchID(0, 3)
+	if e != nil {
+		t.Fatal(e)
+	}
+	want := []Neighbor{{ID: 0, Distance: 0}, {ID: 1, Distance: 0.5}, {ID: 2, Distance: 1}}
+	if !reflect.DeepEqual(neighbors, want) {
+		t.Fatalf("want %v got %v", want, neighbors)
+	}
+}
@@ -0,0 +1,72 @@
+package index
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// SearchStats describes the work done by a single search
+type SearchStats struct {
+	// Distances is the number of distances computed between the query and
+	// indexed vectors or centroids
+	Distances int
+	// Duration is the wall time of the search
+	Duration time.Duration
+}
+
+// StatsSearcher is implemented by indexes which count the work done by a
+// search. Unlike MetricCalls the counts belong to a single search, so they
+// stay correct when searches run concurrently.
+type StatsSearcher interface {
+	// SearchWithStats is Index.SearchFilter which also returns the
+	// statistics of the search
+	SearchWithStats(v []float32, k int, filter Filter) ([]Neighbor, SearchStats, error)
+}
+
+// BatchResult is the outcome of a single query of SearchBatch
+type BatchResult struct {
+	Neighbors []Neighbor
+	Stats     SearchStats
+	Err       error
+}
+
+// SearchBatch searches idx for up to k neighbours of every query using at
+// most workers goroutines, runtime.NumCPU() when workers < 1. Results are in
+// the order of queries. Indexes of this package can be searched
+// concurrently once built, so batches can run on the same index at once.
+// Stats.Distances stays zero when idx does not implement StatsSearcher.
+func SearchBatch(idx Index, queries [][]float32, k, workers int) []BatchResult {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(queries) {
+		workers = len(queries)
+	}
+	stats, _ := idx.(StatsSearcher)
+	results := make([]BatchResult, len(queries))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				r := &results[i]
+				if stats != nil {
+					r.Neighbors, r.Stats, r.Err = stats.SearchWithStats(queries[i], k, nil)
+					continue
+				}
+				start := time.Now()
+				r.Neighbors, r.Err = idx.Search(queries[i], k)
+				r.Stats.Duration = time.Since(start)
+			}
+		}()
+	}
+	for i := range queries {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
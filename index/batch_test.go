@@ -0,0 +1,64 @@
+package index
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSearchBatch(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	vectors := randomVectors(r, 1000, 8)
+	queries := randomVectors(r, 64, 8)
+	annoy, _ := NewAnnoy(8, Euclidean, GoKernels)
+	annoy.Trees = 4
+	indexes := map[string]Index{
+		"annoy": annoy,
+		"hnsw":  NewHNSW(8, Euclidean, GoKernels, 16, 100),
+		"ivf":   NewIVF(8, Euclidean, GoKernels, 8),
+		"vp":    NewVPIndex(Euclidean, euclidean, nil),
+	}
+	for name, idx := range indexes {
+		for i, v := range vectors {
+			if e := idx.Add(int32(i), v); e != nil {
+				t.Fatal(e)
+			}
+		}
+		if e := idx.Build(); e != nil {
+			t.Fatal(e)
+		}
+		want := make([]BatchResult, len(queries))
+		for i, q := range queries {
+			want[i].Neighbors, want[i].Stats, want[i].Err = idx.(StatsSearcher).SearchWithStats(q, 5, nil)
+		}
+		// batches on the same index run concurrently
+		var wg sync.WaitGroup
+		for b := 0; b < 3; b++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got := SearchBatch(idx, queries, 5, 4)
+				if len(got) != len(queries) {
+					t.Errorf("%s returned %d results for %d queries", name, len(got), len(queries))
+					return
+				}
+				for i := range got {
+					if got[i].Err != nil {
+						t.Error(got[i].Err)
+						return
+					}
+					if !reflect.DeepEqual(got[i].Neighbors, want[i].Neighbors) {
+						t.Errorf("%s query %d returned %v, want %v", name, i, got[i].Neighbors, want[i].Neighbors)
+						return
+					}
+					if got[i].Stats.Distances != want[i].Stats.Distances || got[i].Stats.Distances == 0 {
+						t.Errorf("%s query %d computed %d distances, want %d", name, i, got[i].Stats.Distances, want[i].Stats.Distances)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
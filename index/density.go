@@ -0,0 +1,208 @@
+package index
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// DensityPeaksOptions controls ComputeDensityPeaks
+type DensityPeaksOptions struct {
+	// Cutoff is the distance points are counted within for density, see
+	// DensityCutoff for choosing it
+	Cutoff float32
+	// Gaussian weights neighbours by exp(-(d/Cutoff)^2) within 3*Cutoff
+	// instead of counting them, which gives fewer density ties on small
+	// data sets
+	Gaussian bool
+}
+
+// DensityPeaks holds local densities of points of a VP-tree over point ids
+// and distances to their nearest denser points, see Rodriguez and Laio
+// "Clustering by fast search and find of density peaks". Cluster centers
+// are points which are both dense and far from denser points, the rest of
+// points join the cluster of their nearest denser point. Slices are
+// indexed by point id, ids which are not in the tree have zero density and
+// no nearest denser point.
+type DensityPeaks struct {
+	// Density is the number of other points within cutoff
+	Density []float32
+	// Delta is the distance to the nearest denser point, for the densest
+	// point the distance to the farthest one
+	Delta []float32
+	// Nearest is the nearest denser point, -1 for the densest point
+	Nearest []int32
+	// ids of points from the densest
+	order []int32
+}
+
+// denser reports whether point a is denser than point b, ties are broken by
+// ids so that densities are totally ordered
+func (dp *DensityPeaks) denser(a, b int32) bool {
+	if dp.Density[a] != dp.Density[b] {
+		return dp.Density[a] > dp.Density[b]
+	}
+	return a < b
+}
+
+// ComputeDensityPeaks computes densities and deltas of all points of tree,
+// items of which are point ids. Densities are computed by radius searches
+// and deltas by iterating points from the closest until a denser one, both
+// over all CPUs.
+func ComputeDensityPeaks(tree *VPTree[int32], opts DensityPeaksOptions) *DensityPeaks {
+	ids := tree.Items()
+	n := 0
+	for _, id := range ids {
+		if int(id) >= n {
+			n = int(id) + 1
+		}
+	}
+	dp := &DensityPeaks{
+		Density: make([]float32, n),
+		Delta:   make([]float32, n),
+		Nearest: make([]int32, n),
+	}
+	for i := range dp.Nearest {
+		dp.Nearest[i] = -1
+	}
+
+	parallel(len(ids), func(start, end int) {
+		for _, id := range ids[start:end] {
+			if !opts.Gaussian {
+				// the point itself is within cutoff
+				neighbors, _ := tree.SearchRadius(id, opts.Cutoff)
+				dp.Density[id] = float32(len(neighbors) - 1)
+				continue
+			}
+			neighbors, distances := tree.SearchRadius(id, 3*opts.Cutoff)
+			var density float64
+			for i, neighbor := range neighbors {
+				if neighbor != id {
+					x := float64(distances[i] / opts.Cutoff)
+					density += math.Exp(-x * x)
+				}
+			}
+			dp.Density[id] = float32(density)
+		}
+	})
+
+	parallel(len(ids), func(start, end int) {
+		for _, id := range ids[start:end] {
+			it := tree.Iterate(id)
+			for {
+				neighbor, distance, ok := it.Next()
+				if !ok {
+					break
+				}
+				dp.Delta[id] = distance
+				if dp.denser(neighbor, id) {
+					dp.Nearest[id] = neighbor
+					break
+				}
+			}
+		}
+	})
+
+	dp.order = ids
+	sort.Slice(dp.order, func(i, j int) bool { return dp.denser(dp.order[i], dp.order[j]) })
+	return dp
+}
+
+// Gamma returns density times delta of point id, cluster centers stand out
+// by large gamma
+func (dp *DensityPeaks) Gamma(id int32) float32 {
+	return dp.Density[id] * dp.Delta[id]
+}
+
+// Centers returns points of density above minDensity and delta above
+// minDelta from the densest, the thresholds are usually read off the
+// decision graph of delta against density
+func (dp *DensityPeaks) Centers(minDensity, minDelta float32) (centers []int32) {
+	for _, id := range dp.order {
+		if dp.Density[id] > minDensity && dp.Delta[id] > minDelta {
+			centers = append(centers, id)
+		}
+	}
+	return
+}
+
+// TopCenters returns k points of the largest gamma from the largest, the
+// densest point is always among them
+func (dp *DensityPeaks) TopCenters(k int) []int32 {
+	centers := append([]int32(nil), dp.order...)
+	sort.SliceStable(centers, func(i, j int) bool {
+		return dp.Gamma(centers[i]) > dp.Gamma(centers[j])
+	})
+	if k > len(centers) {
+		k = len(centers)
+	}
+	if k < 1 {
+		return nil
+	}
+	centers = centers[:k]
+	if densest := dp.order[0]; !containsID(centers, densest) {
+		centers[k-1] = densest
+	}
+	return centers
+}
+
+func containsID(ids []int32, id int32) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Assign returns cluster labels of points by id, the cluster of a center
+// is its position in centers and other points join the cluster of their
+// nearest denser point. Points which lead to no center, which happens when
+// the densest point is not a center, and ids not in the tree are labelled
+// -1.
+func (dp *DensityPeaks) Assign(centers []int32) []int {
+	labels := make([]int, len(dp.Density))
+	for i := range labels {
+		labels[i] = -1
+	}
+	for label, id := range centers {
+		labels[id] = label
+	}
+	// nearest denser points are labelled before points they are nearest to
+	for _, id := range dp.order {
+		if labels[id] < 0 && dp.Nearest[id] >= 0 {
+			labels[id] = labels[dp.Nearest[id]]
+		}
+	}
+	return labels
+}
+
+// DensityCutoff estimates the cutoff distance within which points have
+// fraction of all points as neighbours on average, Rodriguez and Laio
+// suggest 1 to 2 percent. It is the median distance of samples random
+// points to their neighbour of that rank.
+func DensityCutoff(tree *VPTree[int32], fraction float64, samples int, seed int64) float32 {
+	ids := tree.Items()
+	if len(ids) < 2 || samples < 1 {
+		return 0
+	}
+	k := int(math.Round(fraction * float64(len(ids))))
+	if k < 1 {
+		k = 1
+	}
+	r := rand.New(rand.NewSource(seed))
+	if samples > len(ids) {
+		samples = len(ids)
+	}
+	sample := r.Perm(len(ids))[:samples]
+	distances := make([]float32, samples)
+	parallel(samples, func(start, end int) {
+		for i := start; i < end; i++ {
+			// the point itself comes first
+			_, found := tree.Search(ids[sample[i]], k+1, 0)
+			distances[i] = found[len(found)-1]
+		}
+	})
+	sort.Slice(distances, func(i, j int) bool { return distances[i] < distances[j] })
+	return distances[samples/2]
+}
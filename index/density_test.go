@@ -0,0 +1,76 @@
+package index
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestDensityPeaks(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	const blobs, size = 3, 150
+	var points [][]float32
+	for b := 0; b < blobs; b++ {
+		cx, cy := float32(b)*10, float32(b%2)*10
+		for i := 0; i < size; i++ {
+			points = append(points, []float32{cx + float32(r.NormFloat64()), cy + float32(r.NormFloat64())})
+		}
+	}
+	ids := make([]int32, len(points))
+	for i := range ids {
+		ids[i] = int32(i)
+	}
+	metric := func(x, y int32) float32 { return euclidean(points[x], points[y]) }
+	tree := NewVPTree(metric, ids)
+
+	cutoff := DensityCutoff(tree, 0.02, 100, 1)
+	if cutoff <= 0 || cutoff > 2 {
+		t.Fatalf("Cutoff %f", cutoff)
+	}
+	for _, gaussian := range []bool{false, true} {
+		dp := ComputeDensityPeaks(tree, DensityPeaksOptions{Cutoff: cutoff, Gaussian: gaussian})
+
+		// deltas and nearest denser points match brute force
+		densest := int32(-1)
+		for _, i := range ids {
+			if densest < 0 || dp.denser(i, densest) {
+				densest = i
+			}
+		}
+		for _, i := range ids {
+			nearest, delta := int32(-1), float32(0)
+			for _, j := range ids {
+				d := metric(i, j)
+				if i == densest && d > delta {
+					delta = d
+				}
+				if dp.denser(j, i) && (nearest < 0 || d < delta) {
+					nearest, delta = j, d
+				}
+			}
+			if dp.Nearest[i] != nearest && metric(i, dp.Nearest[i]) != delta {
+				t.Fatalf("Point %d nearest denser %d, want %d", i, dp.Nearest[i], nearest)
+			}
+			if dp.Delta[i] != delta {
+				t.Fatalf("Point %d delta %f, want %f", i, dp.Delta[i], delta)
+			}
+		}
+
+		centers := dp.TopCenters(blobs)
+		if len(centers) != blobs || !containsID(centers, densest) {
+			t.Fatalf("Centers %v miss the densest point %d", centers, densest)
+		}
+		checkBlobLabels(t, dp.Assign(centers), blobs, size, 5)
+
+		// thresholds below all centers but the weakest select the others
+		weakest := centers[blobs-1]
+		selected := dp.Centers(0, dp.Delta[weakest])
+		for _, c := range centers[:blobs-1] {
+			if dp.Delta[c] > dp.Delta[weakest] && !containsID(selected, c) {
+				t.Fatalf("Centers %v miss %d", selected, c)
+			}
+		}
+		if labels := dp.Assign(nil); labels[densest] != -1 {
+			t.Fatalf("Point %d labelled %d without centers", densest, labels[densest])
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package index
+
+// Filter reports whether row id may be returned by a search, a nil Filter
+// accepts every row
+type Filter func(id int32) bool
+
+// Bitmap is a set of row ids, usually the rows a search is restricted to
+type Bitmap []uint64
+
+// NewBitmap creates an empty set of row ids below n
+func NewBitmap(n int) Bitmap {
+	return make(Bitmap, (n+63)/64)
+}
+
+// Set adds row id to the set, it must be below the size of the bitmap
+func (b Bitmap) Set(id int32) {
+	b[id>>6] |= 1 << uint(id&63)
+}
+
+// Has reports whether row id is in the set
+func (b Bitmap) Has(id int32) bool {
+	return id >= 0 && int(id>>6) < len(b) && b[id>>6]&(1<<uint(id&63)) != 0
+}
+
+// Filter returns Filter accepting rows of the set
+func (b Bitmap) Filter() Filter {
+	return b.Has
+}
+
+// accepts reports whether filter accepts row id
+func (filter Filter) accepts(id int32) bool {
+	return filter == nil || filter(id)
+}
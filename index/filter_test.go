@@ -0,0 +1,85 @@
+package index
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestBitmap(t *testing.T) {
+	b := NewBitmap(130)
+	for _, id := range []int32{0, 63, 64, 129} {
+		b.Set(id)
+	}
+	for id := int32(-1); id < 200; id++ {
+		want := id == 0 || id == 63 || id == 64 || id == 129
+		if b.Has(id) != want || b.Filter()(id) != want {
+			t.Fatalf("Has(%d) is %v, want %v", id, b.Has(id), want)
+		}
+	}
+}
+
+func TestSearchFilter(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	vectors := randomVectors(r, 2000, 16)
+	// 2% of rows are accepted
+	allowed := NewBitmap(len(vectors))
+	var accepted [][]float32
+	var ids []int32
+	for i := 0; i < len(vectors); i += 50 {
+		allowed.Set(int32(i))
+		accepted = append(accepted, vectors[i])
+		ids = append(ids, int32(i))
+	}
+
+	annoy, _ := NewAnnoy(16, Euclidean, GoKernels)
+	annoy.Trees = 10
+	ivfpq, _ := NewIVFPQ(16, Euclidean, GoKernels, 16, 4)
+	indexes := map[string]Index{
+		"annoy": annoy,
+		"hnsw":  NewHNSW(16, Euclidean, GoKernels, 16, 100),
+		"ivf":   NewIVF(16, Euclidean, GoKernels, 16),
+		"ivfpq": ivfpq,
+		"vp":    NewVPIndex(Euclidean, euclidean, nil),
+	}
+	// minimal recall@10 of accepted rows, IVF probes half of its lists and
+	// PQ distances are approximate
+	minRecall := map[string]float64{"annoy": 0.9, "hnsw": 0.9, "ivf": 0.6, "ivfpq": 0.4, "vp": 1}
+	for name, idx := range indexes {
+		for i, v := range vectors {
+			if e := idx.Add(int32(i), v); e != nil {
+				t.Fatal(e)
+			}
+		}
+		if e := idx.Build(); e != nil {
+			t.Fatal(e)
+		}
+		var total float64
+		for q := 0; q < 20; q++ {
+			query := randomVectors(r, 1, 16)[0]
+			got, e := idx.SearchFilter(query, 10, allowed.Filter())
+			if e != nil {
+				t.Fatal(e)
+			}
+			if len(got) != 10 {
+				t.Fatalf("%s returned %d neighbours, want 10", name, len(got))
+			}
+			for _, n := range got {
+				if !allowed.Has(n.ID) {
+					t.Fatalf("%s returned rejected row %d", name, n.ID)
+				}
+			}
+			want := exactNeighbors(accepted, query, euclidean, 10)
+			for i := range want {
+				want[i].ID = ids[want[i].ID]
+			}
+			total += recall(got, want)
+		}
+		if total/20 < minRecall[name] {
+			t.Fatalf("%s filtered recall@10 %f is too low", name, total/20)
+		}
+		// a filter accepting nothing returns nothing
+		if got, e := idx.SearchFilter(vectors[0], 10, func(id int32) bool { return false }); e != nil || len(got) != 0 {
+			t.Fatalf("%s returned %v, %v for empty filter", name, got, e)
+		}
+	}
+}
@@ -45,6 +45,9 @@ func (pq *PriorityQueue) Pop() interface{} {
 	return item
 }
 
+// Find returns the queued item holding item and panics when there is none.
+//
+// Deprecated: Find scans the whole queue, IndexedHeap finds keys in O(1).
 func (pq *PriorityQueue) Find(item interface{}) *HeapItem {
 	for i := range *pq {
 		if (*pq)[i].Item == item {
@@ -95,6 +98,9 @@ func (pq *MinPriorityQueue) Pop() interface{} {
 	return item
 }
 
+// Find returns the queued item holding item and panics when there is none.
+//
+// Deprecated: Find scans the whole queue, IndexedHeap finds keys in O(1).
 func (pq *MinPriorityQueue) Find(item interface{}) *HeapItem {
 	for i := range *pq {
 		if (*pq)[i].Item == item {
@@ -109,3 +115,232 @@ func (pq *MinPriorityQueue) Print(label func(*HeapItem) string) {
 		fmt.Printf("\t%d %d %f %s\n", i, hi.Index, hi.Dist, label(hi))
 	}
 }
+
+// candidate is an item id with its distance to a query
+type candidate struct {
+	distance float32
+	id       int32
+}
+
+// candidateHeap is a binary heap of candidates, a min heap unless max is
+// set. Unlike PriorityQueue it stores values and does not allocate per item.
+type candidateHeap struct {
+	items []candidate
+	max   bool
+}
+
+func (ch *candidateHeap) len() int {
+	return len(ch.items)
+}
+
+func (ch *candidateHeap) less(i, j int) bool {
+	if ch.max {
+		return ch.items[i].distance > ch.items[j].distance
+	}
+	return ch.items[i].distance < ch.items[j].distance
+}
+
+func (ch *candidateHeap) top() candidate {
+	return ch.items[0]
+}
+
+func (ch *candidateHeap) push(c candidate) {
+	ch.items = append(ch.items, c)
+	for i := len(ch.items) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !ch.less(i, parent) {
+			break
+		}
+		ch.items[i], ch.items[parent] = ch.items[parent], ch.items[i]
+		i = parent
+	}
+}
+
+func (ch *candidateHeap) pop() candidate {
+	top := ch.items[0]
+	last := len(ch.items) - 1
+	ch.items[0] = ch.items[last]
+	ch.items = ch.items[:last]
+	for i := 0; ; {
+		child := 2*i + 1
+		if child >= last {
+			break
+		}
+		if child+1 < last && ch.less(child+1, child) {
+			child++
+		}
+		if !ch.less(child, i) {
+			break
+		}
+		ch.items[i], ch.items[child] = ch.items[child], ch.items[i]
+		i = child
+	}
+	return top
+}
+
+// IndexedHeap is a binary heap of unique keys with float32 priorities. It
+// tracks the position of every key, so Contains is O(1) and DecreaseKey,
+// Update and Remove are O(log n). Pop returns the key with the lowest
+// priority, or the highest one for a heap created with max set.
+type IndexedHeap[K comparable] struct {
+	keys       []K
+	priorities []float32
+	positions  map[K]int
+	max        bool
+}
+
+// NewIndexedHeap creates an empty min heap, or a max heap when max is set
+func NewIndexedHeap[K comparable](max bool) *IndexedHeap[K] {
+	return &IndexedHeap[K]{positions: make(map[K]int), max: max}
+}
+
+// Len returns number of keys in the heap
+func (h *IndexedHeap[K]) Len() int {
+	return len(h.keys)
+}
+
+// Contains reports whether key is in the heap
+func (h *IndexedHeap[K]) Contains(key K) bool {
+	_, ok := h.positions[key]
+	return ok
+}
+
+// Priority returns priority of key and whether key is in the heap
+func (h *IndexedHeap[K]) Priority(key K) (float32, bool) {
+	i, ok := h.positions[key]
+	if !ok {
+		return 0, false
+	}
+	return h.priorities[i], true
+}
+
+// Push adds key with priority, the priority of a key already in the heap is
+// updated instead
+func (h *IndexedHeap[K]) Push(key K, priority float32) {
+	if _, ok := h.positions[key]; ok {
+		h.Update(key, priority)
+		return
+	}
+	h.keys = append(h.keys, key)
+	h.priorities = append(h.priorities, priority)
+	h.positions[key] = len(h.keys) - 1
+	h.up(len(h.keys) - 1)
+}
+
+// Top returns the key Pop would return without removing it, the heap must
+// not be empty
+func (h *IndexedHeap[K]) Top() (K, float32) {
+	return h.keys[0], h.priorities[0]
+}
+
+// Pop removes and returns the top key and its priority, the heap must not
+// be empty
+func (h *IndexedHeap[K]) Pop() (K, float32) {
+	key, priority := h.keys[0], h.priorities[0]
+	h.removeAt(0)
+	return key, priority
+}
+
+// Update sets priority of key and reports whether key is in the heap
+func (h *IndexedHeap[K]) Update(key K, priority float32) bool {
+	i, ok := h.positions[key]
+	if !ok {
+		return false
+	}
+	h.priorities[i] = priority
+	if !h.up(i) {
+		h.down(i)
+	}
+	return true
+}
+
+// DecreaseKey lowers priority of key to priority. It reports false and
+// leaves the heap intact when key is not in the heap or priority is not
+// lower than the current one.
+func (h *IndexedHeap[K]) DecreaseKey(key K, priority float32) bool {
+	i, ok := h.positions[key]
+	if !ok || priority >= h.priorities[i] {
+		return false
+	}
+	return h.Update(key, priority)
+}
+
+// Remove removes key and reports whether it was in the heap
+func (h *IndexedHeap[K]) Remove(key K) bool {
+	i, ok := h.positions[key]
+	if ok {
+		h.removeAt(i)
+	}
+	return ok
+}
+
+// Each calls visit for keys in heap order, which is not sorted by priority,
+// until visit returns false. The heap must not be modified by visit.
+func (h *IndexedHeap[K]) Each(visit func(key K, priority float32) bool) {
+	for i, key := range h.keys {
+		if !visit(key, h.priorities[i]) {
+			return
+		}
+	}
+}
+
+func (h *IndexedHeap[K]) removeAt(i int) {
+	last := len(h.keys) - 1
+	delete(h.positions, h.keys[i])
+	if i != last {
+		h.keys[i], h.priorities[i] = h.keys[last], h.priorities[last]
+		h.positions[h.keys[i]] = i
+	}
+	var zero K
+	h.keys[last] = zero
+	h.keys, h.priorities = h.keys[:last], h.priorities[:last]
+	if i != last && !h.up(i) {
+		h.down(i)
+	}
+}
+
+func (h *IndexedHeap[K]) less(i, j int) bool {
+	if h.max {
+		return h.priorities[i] > h.priorities[j]
+	}
+	return h.priorities[i] < h.priorities[j]
+}
+
+func (h *IndexedHeap[K]) swap(i, j int) {
+	h.keys[i], h.keys[j] = h.keys[j], h.keys[i]
+	h.priorities[i], h.priorities[j] = h.priorities[j], h.priorities[i]
+	h.positions[h.keys[i]] = i
+	h.positions[h.keys[j]] = j
+}
+
+// up moves entry i towards the root and reports whether it moved
+func (h *IndexedHeap[K]) up(i int) bool {
+	moved := false
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !h.less(i, parent) {
+			break
+		}
+		h.swap(i, parent)
+		i = parent
+		moved = true
+	}
+	return moved
+}
+
+func (h *IndexedHeap[K]) down(i int) {
+	for {
+		child := 2*i + 1
+		if child >= len(h.keys) {
+			return
+		}
+		if child+1 < len(h.keys) && h.less(child+1, child) {
+			child++
+		}
+		if !h.less(child, i) {
+			return
+		}
+		h.swap(i, child)
+		i = child
+	}
+}
@@ -0,0 +1,68 @@
+package index
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestIndexedHeap(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+	for _, max := range []bool{false, true} {
+		h := NewIndexedHeap[int32](max)
+		priorities := make(map[int32]float32)
+		for step := 0; step < 5000; step++ {
+			key := int32(r.Intn(300))
+			switch op := r.Intn(4); {
+			case op == 0 || !h.Contains(key):
+				p := r.Float32()
+				h.Push(key, p)
+				priorities[key] = p
+			case op == 1:
+				p := r.Float32()
+				if h.DecreaseKey(key, p) != (p < priorities[key]) {
+					t.Fatalf("DecreaseKey of %d from %f to %f", key, priorities[key], p)
+				}
+				if p < priorities[key] {
+					priorities[key] = p
+				}
+			case op == 2:
+				if !h.Remove(key) || h.Remove(key) {
+					t.Fatalf("Remove of %d", key)
+				}
+				delete(priorities, key)
+			default:
+				top, p := h.Pop()
+				if p != priorities[top] {
+					t.Fatalf("Popped %d with priority %f, want %f", top, p, priorities[top])
+				}
+				for key, q := range priorities {
+					if (!max && q < p) || (max && q > p) {
+						t.Fatalf("Popped %d with priority %f before %d with %f", top, p, key, q)
+					}
+				}
+				delete(priorities, top)
+			}
+			if h.Len() != len(priorities) {
+				t.Fatalf("Len %d, want %d", h.Len(), len(priorities))
+			}
+		}
+
+		var want []float32
+		for key, p := range priorities {
+			if got, ok := h.Priority(key); !ok || got != p {
+				t.Fatalf("Priority of %d is %f, want %f", key, got, p)
+			}
+			want = append(want, p)
+		}
+		sort.Slice(want, func(i, j int) bool { return (want[i] < want[j]) != max })
+		for i := range want {
+			if _, p := h.Pop(); p != want[i] {
+				t.Fatalf("Pop %d returned %f, want %f", i, p, want[i])
+			}
+		}
+		if h.DecreaseKey(1, 0) || h.Remove(1) || h.Contains(1) {
+			t.Fatal("Empty heap has keys")
+		}
+	}
+}
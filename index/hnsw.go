@@ -0,0 +1,654 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/vseledkin/govector/mmap"
+)
+
+const (
+	hnswMagic   = 0x57534e48 // "HNSW"
+	hnswVersion = 1
+	// hnswHeader is the number of 4 byte words before node arrays
+	hnswHeader = 12
+)
+
+// hnswNode is a graph vertex. vector and friends may point into a mapped
+// file, so they are never written in place: link lists are replaced by new
+// slices under mu.
+type hnswNode struct {
+	id      int32
+	level   int
+	vector  []float32
+	deleted atomic.Bool
+	mu      sync.Mutex
+	friends [][]int32
+}
+
+func (n *hnswNode) links(level int) []int32 {
+	n.mu.Lock()
+	links := n.friends[level]
+	n.mu.Unlock()
+	return links
+}
+
+// HNSW is a hierarchical navigable small world graph index. Every vector is
+// a vertex linked to its approximate nearest neighbours on level 0 and, with
+// exponentially decreasing probability, on upper levels which let search
+// descend quickly towards the query. Add is safe to call concurrently with
+// other Add, Delete and Search calls.
+type HNSW struct {
+	dim            int
+	metric         Metric
+	distance       func(x, y []float32) float32
+	m              int
+	maxM0          int
+	efConstruction int
+	levelMult      float64
+
+	// mu guards growth of nodes, byID, the entry point and random
+	mu       sync.Mutex
+	nodes    atomic.Pointer[[]*hnswNode]
+	byID     map[int32]int32
+	entry    atomic.Int32
+	maxLevel atomic.Int32
+	random   *rand.Rand
+	deleted  atomic.Int32
+	mapped   *mmap.ReaderAt
+	visited  sync.Pool
+
+	// EfSearch is the size of the dynamic candidate list during search,
+	// larger values give better recall at the cost of speed
+	EfSearch int
+	// Key labels search results, it may be nil
+	Key func(id int32) string
+}
+
+// NewHNSW creates an empty graph of dim dimensional vectors ranked by metric.
+// M is the number of links a vertex keeps on upper levels, level 0 keeps
+// 2*M. efConstruction is the size of the candidate list used when linking
+// new vertices.
+func NewHNSW(dim int, metric Metric, kernels Kernels, M, efConstruction int) *HNSW {
+	if M < 2 {
+		M = 2
+	}
+	if efConstruction < M {
+		efConstruction = M
+	}
+	h := &HNSW{
+		dim:            dim,
+		metric:         metric,
+		distance:       kernels.Distance(metric),
+		m:              M,
+		maxM0:          2 * M,
+		efConstruction: efConstruction,
+		levelMult:      1 / math.Log(float64(M)),
+		byID:           make(map[int32]int32),
+		random:         rand.New(rand.NewSource(1)),
+		EfSearch:       50,
+	}
+	nodes := make([]*hnswNode, 0, 1024)
+	h.nodes.Store(&nodes)
+	h.entry.Store(-1)
+	return h
+}
+
+// SetSeed seeds random level generator
+func (h *HNSW) SetSeed(seed int64) {
+	h.mu.Lock()
+	h.random.Seed(seed)
+	h.mu.Unlock()
+}
+
+func (h *HNSW) node(i int32) *hnswNode {
+	return (*h.nodes.Load())[i]
+}
+
+func (h *HNSW) count() int {
+	return len(*h.nodes.Load())
+}
+
+// Add inserts vector v under row id
+func (h *HNSW) Add(id int32, v []float32) error {
+	if len(v) != h.dim {
+		return fmt.Errorf("Vector dimension %d does not match index dimension %d", len(v), h.dim)
+	}
+
+	h.mu.Lock()
+	if _, ok := h.byID[id]; ok {
+		h.mu.Unlock()
+		return fmt.Errorf("Item %d is already added", id)
+	}
+	level := int(-math.Log(1-h.random.Float64()) * h.levelMult)
+	n := &hnswNode{id: id, level: level, vector: v, friends: make([][]int32, level+1)}
+	nodes := *h.nodes.Load()
+	if len(nodes) == cap(nodes) {
+		grown := make([]*hnswNode, len(nodes), 2*cap(nodes)+1)
+		copy(grown, nodes)
+		nodes = grown
+	}
+	nodes = append(nodes, n)
+	h.nodes.Store(&nodes)
+	q := int32(len(nodes) - 1)
+	h.byID[id] = q
+	entry, maxLevel := h.entry.Load(), int(h.maxLevel.Load())
+	if entry < 0 {
+		h.entry.Store(q)
+		h.maxLevel.Store(int32(level))
+		h.mu.Unlock()
+		return nil
+	}
+	h.mu.Unlock()
+
+	var calls int
+	ep := h.descend(v, entry, maxLevel, level, &calls)
+	for lc := minInt(level, maxLevel); lc >= 0; lc-- {
+		candidates := h.searchLayer(v, ep, h.efConstruction, lc, nil, &calls)
+		friends := h.selectNeighbors(candidates, h.m)
+		links := make([]int32, len(friends))
+		for i, c := range friends {
+			links[i] = c.id
+		}
+		n.mu.Lock()
+		n.friends[lc] = links
+		n.mu.Unlock()
+		for _, c := range friends {
+			h.link(c.id, q, c.distance, lc)
+		}
+		ep = candidates
+	}
+
+	if level > maxLevel {
+		h.mu.Lock()
+		if int32(level) > h.maxLevel.Load() {
+			h.maxLevel.Store(int32(level))
+			h.entry.Store(q)
+		}
+		h.mu.Unlock()
+	}
+	return nil
+}
+
+// link adds q to the link list of node e on level lc, shrinking the list
+// with the neighbour selection heuristic when it overflows
+func (h *HNSW) link(e, q int32, distance float32, lc int) {
+	max := h.m
+	if lc == 0 {
+		max = h.maxM0
+	}
+	n := h.node(e)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	old := n.friends[lc]
+	if len(old) < max {
+		links := make([]int32, len(old)+1)
+		copy(links, old)
+		links[len(old)] = q
+		n.friends[lc] = links
+		return
+	}
+	candidates := make([]candidate, 0, len(old)+1)
+	candidates = append(candidates, candidate{distance, q})
+	for _, f := range old {
+		candidates = append(candidates, candidate{h.distance(n.vector, h.node(f).vector), f})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+	selected := h.selectNeighbors(candidates, max)
+	links := make([]int32, len(selected))
+	for i, c := range selected {
+		links[i] = c.id
+	}
+	n.friends[lc] = links
+}
+
+// selectNeighbors picks up to m candidates, sorted by distance, skipping
+// candidates which are closer to an already selected one than to the base
+// vector. This keeps links pointing in diverse directions.
+func (h *HNSW) selectNeighbors(candidates []candidate, m int) []candidate {
+	if len(candidates) <= m {
+		return candidates
+	}
+	selected := make([]candidate, 0, m)
+	for _, c := range candidates {
+		if len(selected) == m {
+			break
+		}
+		good := true
+		cv := h.node(c.id).vector
+		for _, s := range selected {
+			if h.distance(cv, h.node(s.id).vector) < c.distance {
+				good = false
+				break
+			}
+		}
+		if good {
+			selected = append(selected, c)
+		}
+	}
+	return selected
+}
+
+// descend greedily walks from entry on level from down to level to+1 and
+// returns the closest vertex found, calls counts computed distances
+func (h *HNSW) descend(v []float32, entry int32, from, to int, calls *int) []candidate {
+	ep := candidate{h.distance(v, h.node(entry).vector), entry}
+	*calls++
+	for lc := from; lc > to; lc-- {
+		for changed := true; changed; {
+			changed = false
+			for _, f := range h.node(ep.id).links(lc) {
+				*calls++
+				if d := h.distance(v, h.node(f).vector); d < ep.distance {
+					ep = candidate{d, f}
+					changed = true
+				}
+			}
+		}
+	}
+	return []candidate{ep}
+}
+
+// searchLayer returns up to ef vertices closest to v on level lc reachable
+// from entry points, sorted by distance. Vertices rejected by accept are
+// walked through but not returned, a nil accept returns every vertex.
+// calls counts computed distances.
+func (h *HNSW) searchLayer(v []float32, entry []candidate, ef, lc int, accept func(n *hnswNode) bool, calls *int) []candidate {
+	visited := h.visitedSet()
+	defer h.visited.Put(visited)
+
+	var candidates, results candidateHeap
+	results.max = true
+	for _, e := range entry {
+		visited.visit(e.id)
+		candidates.push(e)
+		if accept == nil || accept(h.node(e.id)) {
+			results.push(e)
+		}
+	}
+	for candidates.len() > 0 {
+		c := candidates.pop()
+		if results.len() >= ef && c.distance > results.top().distance {
+			break
+		}
+		for _, f := range h.node(c.id).links(lc) {
+			if visited.visit(f) {
+				continue
+			}
+			n := h.node(f)
+			d := h.distance(v, n.vector)
+			*calls++
+			if results.len() < ef || d < results.top().distance {
+				candidates.push(candidate{d, f})
+				if accept == nil || accept(n) {
+					results.push(candidate{d, f})
+					if results.len() > ef {
+						results.pop()
+					}
+				}
+			}
+		}
+	}
+	sorted := make([]candidate, results.len())
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = results.pop()
+	}
+	return sorted
+}
+
+// Build does nothing, vertices are linked as they are added
+func (h *HNSW) Build() error {
+	return nil
+}
+
+// Delete marks item id deleted. It is still used to navigate the graph but
+// never returned by searches.
+func (h *HNSW) Delete(id int32) error {
+	h.mu.Lock()
+	i, ok := h.byID[id]
+	h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("Item %d is not in index", id)
+	}
+	if !h.node(i).deleted.Swap(true) {
+		h.deleted.Add(1)
+	}
+	return nil
+}
+
+// Search returns up to k neighbours of v
+func (h *HNSW) Search(v []float32, k int) ([]Neighbor, error) {
+	return h.SearchFilter(v, k, nil)
+}
+
+// SearchFilter returns up to k neighbours of v accepted by filter. Rejected
+// vertices are walked through like deleted ones, so the search visits more
+// of the graph when filter is selective. When the graph search finds fewer
+// than k accepted vertices, all vertices are scanned.
+func (h *HNSW) SearchFilter(v []float32, k int, filter Filter) ([]Neighbor, error) {
+	neighbors, _, e := h.SearchWithStats(v, k, filter)
+	return neighbors, e
+}
+
+// SearchWithStats is SearchFilter which also returns search statistics
+func (h *HNSW) SearchWithStats(v []float32, k int, filter Filter) ([]Neighbor, SearchStats, error) {
+	start := time.Now()
+	var calls int
+	if len(v) != h.dim {
+		return nil, SearchStats{}, fmt.Errorf("Query dimension %d does not match index dimension %d", len(v), h.dim)
+	}
+	if k < 1 {
+		return nil, SearchStats{}, nil
+	}
+	h.mu.Lock()
+	entry, maxLevel := h.entry.Load(), int(h.maxLevel.Load())
+	h.mu.Unlock()
+	if entry < 0 {
+		return nil, SearchStats{}, nil
+	}
+	ef := h.EfSearch
+	if ef < k {
+		ef = k
+	}
+	accept := func(n *hnswNode) bool {
+		return !n.deleted.Load() && filter.accepts(n.id)
+	}
+	candidates := h.searchLayer(v, h.descend(v, entry, maxLevel, 0, &calls), ef, 0, accept, &calls)
+	if filter != nil && len(candidates) < k {
+		candidates = h.scan(v, k, accept, &calls)
+	}
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	neighbors := make([]Neighbor, len(candidates))
+	for i, c := range candidates {
+		neighbors[i] = Neighbor{ID: h.node(c.id).id, Distance: c.distance}
+		if h.Key != nil {
+			neighbors[i].Key = h.Key(neighbors[i].ID)
+		}
+	}
+	return neighbors, SearchStats{Distances: calls, Duration: time.Since(start)}, nil
+}
+
+// scan returns up to k vertices closest to v accepted by accept sorted by
+// distance, it computes distances to all vertices
+func (h *HNSW) scan(v []float32, k int, accept func(n *hnswNode) bool, calls *int) []candidate {
+	results := candidateHeap{max: true}
+	for i, n := range *h.nodes.Load() {
+		if !accept(n) {
+			continue
+		}
+		d := h.distance(v, n.vector)
+		*calls++
+		if results.len() < k {
+			results.push(candidate{d, int32(i)})
+		} else if d < results.top().distance {
+			results.pop()
+			results.push(candidate{d, int32(i)})
+		}
+	}
+	sorted := make([]candidate, results.len())
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = results.pop()
+	}
+	return sorted
+}
+
+// SearchID returns up to k neighbours of the vector added under id
+func (h *HNSW) SearchID(id int32, k int) ([]Neighbor, error) {
+	h.mu.Lock()
+	i, ok := h.byID[id]
+	h.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Item %d is not in index", id)
+	}
+	return h.Search(h.node(i).vector, k)
+}
+
+// Len returns number of items not deleted
+func (h *HNSW) Len() int {
+	return h.count() - int(h.deleted.Load())
+}
+
+// Metric returns the metric neighbours are ranked by
+func (h *HNSW) Metric() Metric {
+	return h.metric
+}
+
+// Save writes the graph in a flat little endian layout of 4 byte words:
+//
+//	header: magic, version, dim, metric, M, efConstruction, efSearch,
+//	        count, entry, maxLevel, links length, reserved
+//	ids[count] levels[count] deleted[count] vectors[count*dim]
+//	offsets[count] links[links length]
+//
+// where links of a vertex start at its offset and hold, for every level from
+// 0, the number of links followed by the linked vertices.
+func (h *HNSW) Save(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	nodes := *h.nodes.Load()
+
+	var offsets, links []int32
+	for _, n := range nodes {
+		offsets = append(offsets, int32(len(links)))
+		for l := 0; l <= n.level; l++ {
+			friends := n.links(l)
+			links = append(links, int32(len(friends)))
+			links = append(links, friends...)
+		}
+	}
+
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+	w := bufio.NewWriter(f)
+	write := func(data interface{}) {
+		if e == nil {
+			e = binary.Write(w, binary.LittleEndian, data)
+		}
+	}
+	write([hnswHeader]int32{hnswMagic, hnswVersion, int32(h.dim), int32(h.metric), int32(h.m),
+		int32(h.efConstruction), int32(h.EfSearch), int32(len(nodes)), h.entry.Load(), h.maxLevel.Load(),
+		int32(len(links)), 0})
+	column := make([]int32, len(nodes))
+	for i, n := range nodes {
+		column[i] = n.id
+	}
+	write(column)
+	for i, n := range nodes {
+		column[i] = int32(n.level)
+	}
+	write(column)
+	for i, n := range nodes {
+		column[i] = 0
+		if n.deleted.Load() {
+			column[i] = 1
+		}
+	}
+	write(column)
+	for _, n := range nodes {
+		write(n.vector)
+	}
+	write(offsets)
+	write(links)
+	if e == nil {
+		e = w.Flush()
+	}
+	if e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
+
+// Load memory maps a graph written by Save. Vectors and links are used in
+// place, adding to a loaded graph copies only the link lists it changes.
+func (h *HNSW) Load(path string) error {
+	mapped, e := mmap.Open(path)
+	if e != nil {
+		return e
+	}
+	fail := func(format string, args ...interface{}) error {
+		mapped.Close()
+		return fmt.Errorf(format, args...)
+	}
+	if len(mapped.Data) < 4*hnswHeader {
+		return fail("%s is not HNSW index", path)
+	}
+	words := unsafe.Slice((*int32)(unsafe.Pointer(&mapped.Data[0])), len(mapped.Data)/4)
+	if words[0] != hnswMagic || words[1] != hnswVersion {
+		return fail("%s is not HNSW index", path)
+	}
+	if int(words[2]) != h.dim || Metric(words[3]) != h.metric {
+		return fail("%s holds %d dimensional %s index, want %d dimensional %s", path, words[2], Metric(words[3]), h.dim, h.metric)
+	}
+	// NewHNSW keeps M at least 2 and efConstruction at least M
+	if words[4] < 2 || words[5] < words[4] || words[6] < 0 {
+		return fail("%s has invalid parameters M %d, efConstruction %d and efSearch %d", path, words[4], words[5], words[6])
+	}
+	count, linksLen := int(words[7]), int(words[10])
+	if count < 0 || linksLen < 0 || len(words) != hnswHeader+count*(4+h.dim)+linksLen {
+		return fail("%s is truncated", path)
+	}
+	entry, maxLevel := words[8], words[9]
+	if entry < -1 || int(entry) >= count || (entry == -1) != (count == 0) {
+		return fail("%s has entry point %d out of %d vertices", path, entry, count)
+	}
+
+	ids := words[hnswHeader : hnswHeader+count]
+	levels := words[hnswHeader+count : hnswHeader+2*count]
+	deleted := words[hnswHeader+2*count : hnswHeader+3*count]
+	var vectors []float32
+	if count > 0 {
+		vectors = unsafe.Slice((*float32)(unsafe.Pointer(&words[hnswHeader+3*count])), count*h.dim)
+	}
+	offsets := words[hnswHeader+3*count+count*h.dim : hnswHeader+4*count+count*h.dim]
+	links := words[hnswHeader+4*count+count*h.dim:]
+	if entry >= 0 && levels[entry] != maxLevel {
+		return fail("%s has entry point of level %d, want maximum level %d", path, levels[entry], maxLevel)
+	}
+
+	nodes := make([]*hnswNode, count)
+	byID := make(map[int32]int32, count)
+	var deletedCount int32
+	for i := range nodes {
+		if levels[i] < 0 || levels[i] > maxLevel {
+			return fail("%s has vertex %d of level %d out of range 0 to %d", path, i, levels[i], maxLevel)
+		}
+		n := &hnswNode{
+			id:      ids[i],
+			level:   int(levels[i]),
+			vector:  vectors[i*h.dim : (i+1)*h.dim : (i+1)*h.dim],
+			friends: make([][]int32, levels[i]+1),
+		}
+		offset := int(offsets[i])
+		for l := range n.friends {
+			if offset < 0 || offset >= linksLen {
+				return fail("%s has links of vertex %d out of range", path, i)
+			}
+			size := int(links[offset])
+			if size < 0 || offset+1+size > linksLen {
+				return fail("%s has links of vertex %d out of range", path, i)
+			}
+			n.friends[l] = links[offset+1 : offset+1+size : offset+1+size]
+			for _, friend := range n.friends[l] {
+				if friend < 0 || int(friend) >= count {
+					return fail("%s links vertex %d to missing vertex %d", path, i, friend)
+				}
+			}
+			offset += 1 + size
+		}
+		if deleted[i] != 0 {
+			n.deleted.Store(true)
+			deletedCount++
+		}
+		nodes[i] = n
+		byID[n.id] = int32(i)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.mapped != nil {
+		h.mapped.Close()
+	}
+	h.m, h.maxM0, h.efConstruction, h.EfSearch = int(words[4]), 2*int(words[4]), int(words[5]), int(words[6])
+	h.levelMult = 1 / math.Log(float64(h.m))
+	h.mapped = mapped
+	h.nodes.Store(&nodes)
+	h.byID = byID
+	h.entry.Store(entry)
+	h.maxLevel.Store(maxLevel)
+	h.deleted.Store(deletedCount)
+	return nil
+}
+
+// Close unmaps a loaded graph and empties the index
+func (h *HNSW) Close() (e error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	nodes := make([]*hnswNode, 0, 1024)
+	h.nodes.Store(&nodes)
+	h.byID = make(map[int32]int32)
+	h.entry.Store(-1)
+	h.maxLevel.Store(0)
+	h.deleted.Store(0)
+	if h.mapped != nil {
+		e = h.mapped.Close()
+		h.mapped = nil
+	}
+	return
+}
+
+// visitedSet marks vertices with the current epoch so it is cleared in O(1)
+type visitedSet struct {
+	marks []uint32
+	epoch uint32
+}
+
+func (h *HNSW) visitedSet() *visitedSet {
+	vs, _ := h.visited.Get().(*visitedSet)
+	if vs == nil {
+		vs = &visitedSet{}
+	}
+	vs.epoch++
+	if vs.epoch == 0 {
+		// epoch wrapped around, forget old marks
+		for i := range vs.marks {
+			vs.marks[i] = 0
+		}
+		vs.epoch = 1
+	}
+	return vs
+}
+
+// visit marks vertex i and reports whether it was already visited
+func (vs *visitedSet) visit(i int32) bool {
+	if int(i) >= len(vs.marks) {
+		grown := make([]uint32, 2*int(i)+1)
+		copy(grown, vs.marks)
+		vs.marks = grown
+	}
+	if vs.marks[i] == vs.epoch {
+		return true
+	}
+	vs.marks[i] = vs.epoch
+	return false
+}
+
+func minInt(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,172 @@
+package index
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func buildHNSW(t *testing.T, vectors [][]float32, metric Metric, workers int) *HNSW {
+	idx := NewHNSW(len(vectors[0]), metric, GoKernels, 16, 100)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(vectors); i += workers {
+				if e := idx.Add(int32(i), vectors[i]); e != nil {
+					t.Error(e)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return idx
+}
+
+func TestHNSWRecall(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	vectors := randomVectors(r, 2000, 16)
+	for _, metric := range []Metric{Angular, Euclidean, Cosine} {
+		idx := buildHNSW(t, vectors, metric, 4)
+		if idx.Len() != len(vectors) {
+			t.Fatalf("Len %d, want %d", idx.Len(), len(vectors))
+		}
+		idx.EfSearch = 64
+		distance := GoKernels.Distance(metric)
+		var total float64
+		for q := 0; q < 50; q++ {
+			query := randomVectors(r, 1, 16)[0]
+			got, e := idx.Search(query, 10)
+			if e != nil {
+				t.Fatal(e)
+			}
+			total += recall(got, exactNeighbors(vectors, query, distance, 10))
+		}
+		if total/50 < 0.9 {
+			t.Fatalf("%s recall@10 %f is too low", metric, total/50)
+		}
+	}
+}
+
+func TestHNSWDelete(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	vectors := randomVectors(r, 500, 8)
+	idx := buildHNSW(t, vectors, Euclidean, 1)
+	for i := 0; i < len(vectors); i += 2 {
+		if e := idx.Delete(int32(i)); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if idx.Len() != len(vectors)/2 {
+		t.Fatalf("Len %d after deletes, want %d", idx.Len(), len(vectors)/2)
+	}
+	if e := idx.Delete(int32(len(vectors))); e == nil {
+		t.Fatal("Deleting unknown item must fail")
+	}
+	for q := 0; q < 20; q++ {
+		got, e := idx.Search(randomVectors(r, 1, 8)[0], 10)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if len(got) != 10 {
+			t.Fatalf("Got %d neighbours, want 10", len(got))
+		}
+		for _, n := range got {
+			if n.ID%2 == 0 {
+				t.Fatalf("Deleted item %d returned", n.ID)
+			}
+		}
+	}
+}
+
+func TestHNSWSaveLoad(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	vectors := randomVectors(r, 1000, 12)
+	idx := buildHNSW(t, vectors, Angular, 1)
+	idx.Delete(7)
+	path := filepath.Join(t.TempDir(), "words.hnsw")
+	if e := idx.Save(path); e != nil {
+		t.Fatal(e)
+	}
+
+	loaded := NewHNSW(12, Angular, GoKernels, 4, 4)
+	if e := loaded.Load(path); e != nil {
+		t.Fatal(e)
+	}
+	defer loaded.Close()
+	if loaded.Len() != idx.Len() {
+		t.Fatalf("Loaded %d items, want %d", loaded.Len(), idx.Len())
+	}
+	for q := 0; q < 20; q++ {
+		query := randomVectors(r, 1, 12)[0]
+		want, _ := idx.Search(query, 5)
+		got, e := loaded.Search(query, 5)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Loaded index returned %v, want %v", got, want)
+		}
+	}
+
+	// adding to a mapped graph must not write to the file
+	extra := randomVectors(r, 100, 12)
+	for i, v := range extra {
+		if e := loaded.Add(int32(len(vectors)+i), v); e != nil {
+			t.Fatal(e)
+		}
+	}
+	got, e := loaded.SearchID(int32(len(vectors)), 1)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(got) != 1 || got[0].ID != int32(len(vectors)) {
+		t.Fatalf("Added item is not its own nearest neighbour: %v", got)
+	}
+
+	if e := NewHNSW(8, Angular, GoKernels, 16, 100).Load(path); e == nil {
+		t.Fatal("Loading index of other dimension must fail")
+	}
+	empty := filepath.Join(t.TempDir(), "empty.hnsw")
+	if e := os.WriteFile(empty, nil, 0644); e != nil {
+		t.Fatal(e)
+	}
+	if e := NewHNSW(12, Angular, GoKernels, 16, 100).Load(empty); e == nil {
+		t.Fatal("Loading empty file must fail")
+	}
+
+	// corrupted levels and links must be reported, not panic
+	data, e := os.ReadFile(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	count := len(vectors)
+	levels := hnswHeader + count
+	offsets := hnswHeader + 3*count + count*12
+	links := offsets + count
+	for name, word := range map[string]struct{ at, value int }{
+		"M":              {4, 1},
+		"efConstruction": {5, 0},
+		"efSearch":       {6, -1},
+		"level":          {levels, 1000},
+		"offset":         {offsets, 1 << 30},
+		"size":           {links, -1},
+		"link":           {links + 1, count},
+	} {
+		corrupted := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint32(corrupted[4*word.at:], uint32(int32(word.value)))
+		bad := filepath.Join(t.TempDir(), name+".hnsw")
+		if e := os.WriteFile(bad, corrupted, 0644); e != nil {
+			t.Fatal(e)
+		}
+		if e := NewHNSW(12, Angular, GoKernels, 16, 100).Load(bad); e == nil {
+			t.Fatalf("Loading index with corrupted %s must fail", name)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package index
+
+import "errors"
+
+// ErrNotSupported is returned by indexes for operations they can not perform
+var ErrNotSupported = errors.New("Operation is not supported by index")
+
+// Neighbor is a single search result
+type Neighbor struct {
+	// ID is the row id the vector was added under
+	ID int32
+	// Key is the label of the row, usually a word, empty when the index has
+	// no key provider
+	Key      string
+	Distance float32
+}
+
+// Index is a nearest neighbour index over vectors identified by row ids.
+// Vectors are added first, then the index is built once and searched.
+type Index interface {
+	// Add adds vector v under row id, it must be called before Build
+	Add(id int32, v []float32) error
+	// Build prepares index for searching all added vectors
+	Build() error
+	// Search returns up to k neighbours of v ordered from the closest
+	Search(v []float32, k int) ([]Neighbor, error)
+	// SearchFilter returns up to k neighbours of v accepted by filter
+	// ordered from the closest. Indexes keep searching until k accepted
+	// neighbours are found, so selective filters cost time, not results.
+	SearchFilter(v []float32, k int, filter Filter) ([]Neighbor, error)
+	// SearchID returns up to k neighbours of the vector added under id
+	// ordered from the closest, the item itself included
+	SearchID(id int32, k int) ([]Neighbor, error)
+	// Save writes built index to the file path
+	Save(path string) error
+	// Load replaces index content with the one saved to the file path
+	Load(path string) error
+	// Len returns number of indexed vectors
+	Len() int
+	// Metric returns the distance neighbours are ranked by
+	Metric() Metric
+}
@@ -0,0 +1,635 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/vseledkin/govector/mmap"
+)
+
+const (
+	ivfMagic   = 0x31465649 // "IVF1"
+	ivfVersion = 1
+	// ivfHeader is the number of 4 byte words before centroids
+	ivfHeader = 12
+	// pqCentroids is the number of centroids of every PQ subquantizer so
+	// that a code fits in a byte
+	pqCentroids = 256
+)
+
+// IVF is an inverted file index. A k-means coarse quantizer splits vectors
+// into lists by the closest centroid and search scans only the Nprobe lists
+// whose centroids are closest to the query. IVF-Flat lists keep vectors as
+// they are and return exact distances, IVF-PQ lists keep product quantization
+// codes of residuals to centroids, one byte per subquantizer, and return
+// approximate distances.
+type IVF struct {
+	dim           int
+	metric        Metric
+	kernels       Kernels
+	distance      func(x, y []float32) float32
+	nlist         int
+	subquantizers int
+	centroids     []float32
+	codebooks     []float32
+	ids           [][]int32
+	vectors       [][]float32
+	codes         [][]byte
+	count         int
+	pendingIDs    []int32
+	pending       []float32
+	built         bool
+	random        *rand.Rand
+	mapped        *mmap.ReaderAt
+	// added holds ids of pending and listed vectors, it is filled on first
+	// Add so that loading a mapped index does not walk its ids
+	added map[int32]struct{}
+
+	// Nprobe is the number of lists scanned by search, defaults to 8
+	Nprobe int
+	// Iterations limits Lloyd iterations of k-means training, defaults to 20
+	Iterations int
+	// TrainSize is the maximum number of added vectors sampled to train
+	// quantizers, defaults to 256 per list
+	TrainSize int
+	// Key labels search results, it may be nil
+	Key func(id int32) string
+}
+
+// NewIVF creates an empty IVF-Flat index with nlist inverted lists
+func NewIVF(dim int, metric Metric, kernels Kernels, nlist int) *IVF {
+	if nlist < 1 {
+		nlist = 1
+	}
+	return &IVF{
+		dim:        dim,
+		metric:     metric,
+		kernels:    kernels,
+		distance:   kernels.Distance(metric),
+		nlist:      nlist,
+		random:     rand.New(rand.NewSource(1)),
+		Nprobe:     8,
+		Iterations: 20,
+		TrainSize:  256 * nlist,
+	}
+}
+
+// NewIVFPQ creates an empty IVF-PQ index with nlist inverted lists, vectors
+// are split into subquantizers parts each encoded with a byte
+func NewIVFPQ(dim int, metric Metric, kernels Kernels, nlist, subquantizers int) (*IVF, error) {
+	if subquantizers < 1 || dim%subquantizers != 0 {
+		return nil, fmt.Errorf("Dimension %d is not a multiple of %d subquantizers", dim, subquantizers)
+	}
+	ivf := NewIVF(dim, metric, kernels, nlist)
+	ivf.subquantizers = subquantizers
+	return ivf, nil
+}
+
+// SetSeed seeds the random generator used to train quantizers
+func (ivf *IVF) SetSeed(seed int64) {
+	ivf.random.Seed(seed)
+}
+
+// prepare normalizes vectors for cosine and angular metrics so that lists
+// can be ranked by Euclidean distance between unit vectors
+func (ivf *IVF) prepare(v []float32) []float32 {
+	if ivf.metric != Cosine && ivf.metric != Angular {
+		return v
+	}
+	norm := float32(math.Sqrt(float64(ivf.kernels.Dot(v, v))))
+	normalized := make([]float32, len(v))
+	if norm > 0 {
+		for i, x := range v {
+			normalized[i] = x / norm
+		}
+	}
+	return normalized
+}
+
+// coarse is the distance between a vector and a centroid
+func (ivf *IVF) coarse(x, y []float32) float32 {
+	if ivf.metric == DotProduct {
+		return -ivf.kernels.Dot(x, y)
+	}
+	return ivf.kernels.SqDist(x, y)
+}
+
+func (ivf *IVF) centroid(l int32) []float32 {
+	return ivf.centroids[int(l)*ivf.dim : int(l+1)*ivf.dim]
+}
+
+// Add adds vector v under row id. Vectors added before Build are used to
+// train quantizers, later ones are put straight into lists.
+func (ivf *IVF) Add(id int32, v []float32) error {
+	if len(v) != ivf.dim {
+		return fmt.Errorf("Vector dimension %d does not match index dimension %d", len(v), ivf.dim)
+	}
+	if ivf.added == nil {
+		ivf.added = make(map[int32]struct{}, ivf.count+len(ivf.pendingIDs))
+		for _, ids := range ivf.ids {
+			for _, listed := range ids {
+				ivf.added[listed] = struct{}{}
+			}
+		}
+		for _, pending := range ivf.pendingIDs {
+			ivf.added[pending] = struct{}{}
+		}
+	}
+	if _, ok := ivf.added[id]; ok {
+		return fmt.Errorf("Item %d is already added", id)
+	}
+	ivf.added[id] = struct{}{}
+	v = ivf.prepare(v)
+	if !ivf.built {
+		ivf.pendingIDs = append(ivf.pendingIDs, id)
+		ivf.pending = append(ivf.pending, v...)
+		return nil
+	}
+	l, _ := nearestCentroid(v, ivf.centroids, ivf.dim, ivf.coarse)
+	var code []byte
+	if ivf.subquantizers > 0 {
+		code = ivf.encode(v, l)
+	}
+	ivf.insert(id, l, v, code)
+	return nil
+}
+
+func (ivf *IVF) insert(id, l int32, v []float32, code []byte) {
+	ivf.ids[l] = append(ivf.ids[l], id)
+	if ivf.subquantizers > 0 {
+		ivf.codes[l] = append(ivf.codes[l], code...)
+	} else {
+		ivf.vectors[l] = append(ivf.vectors[l], v...)
+	}
+	ivf.count++
+}
+
+// encode quantizes residual of v to the centroid of list l
+func (ivf *IVF) encode(v []float32, l int32) []byte {
+	dsub := ivf.dim / ivf.subquantizers
+	residual := make([]float32, ivf.dim)
+	for i, c := range ivf.centroid(l) {
+		residual[i] = v[i] - c
+	}
+	code := make([]byte, ivf.subquantizers)
+	for s := range code {
+		c, _ := nearestCentroid(residual[s*dsub:(s+1)*dsub], ivf.codebook(s), dsub, ivf.kernels.SqDist)
+		code[s] = byte(c)
+	}
+	return code
+}
+
+func (ivf *IVF) codebook(s int) []float32 {
+	size := pqCentroids * ivf.dim / ivf.subquantizers
+	return ivf.codebooks[s*size : (s+1)*size]
+}
+
+// Build trains the coarse quantizer and PQ codebooks on a sample of added
+// vectors and puts all of them into lists
+func (ivf *IVF) Build() error {
+	if ivf.built {
+		return nil
+	}
+	n := len(ivf.pendingIDs)
+	if n == 0 {
+		return fmt.Errorf("No vectors to train index")
+	}
+	sample := ivf.pending
+	if ivf.TrainSize > 0 && n > ivf.TrainSize {
+		sample = make([]float32, 0, ivf.TrainSize*ivf.dim)
+		for _, i := range ivf.random.Perm(n)[:ivf.TrainSize] {
+			sample = append(sample, ivf.pending[i*ivf.dim:(i+1)*ivf.dim]...)
+		}
+	}
+	ivf.centroids = trainCentroids(sample, ivf.dim, ivf.nlist, ivf.Iterations, ivf.kernels, ivf.random)
+	ivf.nlist = len(ivf.centroids) / ivf.dim
+
+	if ivf.subquantizers > 0 {
+		ivf.trainCodebooks(sample)
+	}
+
+	lists := make([]int32, n)
+	var codes []byte
+	if ivf.subquantizers > 0 {
+		codes = make([]byte, n*ivf.subquantizers)
+	}
+	parallel(n, func(start, end int) {
+		for i := start; i < end; i++ {
+			v := ivf.pending[i*ivf.dim : (i+1)*ivf.dim]
+			lists[i], _ = nearestCentroid(v, ivf.centroids, ivf.dim, ivf.coarse)
+			if codes != nil {
+				copy(codes[i*ivf.subquantizers:], ivf.encode(v, lists[i]))
+			}
+		}
+	})
+
+	ivf.ids = make([][]int32, ivf.nlist)
+	ivf.vectors = make([][]float32, ivf.nlist)
+	ivf.codes = make([][]byte, ivf.nlist)
+	ivf.built = true
+	for i, id := range ivf.pendingIDs {
+		var code []byte
+		if codes != nil {
+			code = codes[i*ivf.subquantizers : (i+1)*ivf.subquantizers]
+		}
+		ivf.insert(id, lists[i], ivf.pending[i*ivf.dim:(i+1)*ivf.dim], code)
+	}
+	ivf.pendingIDs, ivf.pending = nil, nil
+	return nil
+}
+
+// trainCodebooks trains a k-means codebook for every subquantizer on
+// residuals of sample vectors to their closest centroids
+func (ivf *IVF) trainCodebooks(sample []float32) {
+	n := len(sample) / ivf.dim
+	dsub := ivf.dim / ivf.subquantizers
+	residuals := make([]float32, len(sample))
+	parallel(n, func(start, end int) {
+		for i := start; i < end; i++ {
+			v := sample[i*ivf.dim : (i+1)*ivf.dim]
+			l, _ := nearestCentroid(v, ivf.centroids, ivf.dim, ivf.coarse)
+			for j, c := range ivf.centroid(l) {
+				residuals[i*ivf.dim+j] = v[j] - c
+			}
+		}
+	})
+	ivf.codebooks = make([]float32, pqCentroids*ivf.dim)
+	part := make([]float32, n*dsub)
+	for s := 0; s < ivf.subquantizers; s++ {
+		for i := 0; i < n; i++ {
+			copy(part[i*dsub:(i+1)*dsub], residuals[i*ivf.dim+s*dsub:i*ivf.dim+(s+1)*dsub])
+		}
+		trained := trainCentroids(part, dsub, pqCentroids, ivf.Iterations, ivf.kernels, ivf.random)
+		// with fewer training vectors than centroids the rest repeat the
+		// first one and are never chosen by encode
+		codebook := ivf.codebook(s)
+		for c := 0; c < pqCentroids; c++ {
+			if c*dsub < len(trained) {
+				copy(codebook[c*dsub:(c+1)*dsub], trained[c*dsub:])
+			} else {
+				copy(codebook[c*dsub:(c+1)*dsub], trained[:dsub])
+			}
+		}
+	}
+}
+
+// probe returns all lists ordered by distance of their centroids from v
+// and the number of leading lists to search
+func (ivf *IVF) probe(v []float32) ([]int32, int) {
+	candidates := make([]candidate, ivf.nlist)
+	for l := range candidates {
+		candidates[l] = candidate{ivf.coarse(v, ivf.centroid(int32(l))), int32(l)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+	nprobe := ivf.Nprobe
+	if nprobe < 1 {
+		nprobe = 1
+	} else if nprobe > ivf.nlist {
+		nprobe = ivf.nlist
+	}
+	lists := make([]int32, len(candidates))
+	for i := range lists {
+		lists[i] = candidates[i].id
+	}
+	return lists, nprobe
+}
+
+// table returns distances between parts of v and every codebook centroid
+// and the distance offset of list l. For dot product both are negated dot
+// products, otherwise they are squared distances to the residual of v.
+func (ivf *IVF) table(v []float32, l int32) ([]float32, float32) {
+	dsub := ivf.dim / ivf.subquantizers
+	table := make([]float32, ivf.subquantizers*pqCentroids)
+	var offset float32
+	query := v
+	if ivf.metric == DotProduct {
+		offset = -ivf.kernels.Dot(v, ivf.centroid(l))
+	} else {
+		query = make([]float32, ivf.dim)
+		for i, c := range ivf.centroid(l) {
+			query[i] = v[i] - c
+		}
+	}
+	for s := 0; s < ivf.subquantizers; s++ {
+		part := query[s*dsub : (s+1)*dsub]
+		codebook := ivf.codebook(s)
+		for c := 0; c < pqCentroids; c++ {
+			centroid := codebook[c*dsub : (c+1)*dsub]
+			if ivf.metric == DotProduct {
+				table[s*pqCentroids+c] = -ivf.kernels.Dot(part, centroid)
+			} else {
+				table[s*pqCentroids+c] = ivf.kernels.SqDist(part, centroid)
+			}
+		}
+	}
+	return table, offset
+}
+
+// Search returns up to k neighbours of v found in Nprobe closest lists
+func (ivf *IVF) Search(v []float32, k int) ([]Neighbor, error) {
+	return ivf.SearchFilter(v, k, nil)
+}
+
+// SearchFilter returns up to k neighbours of v accepted by filter found in
+// Nprobe closest lists, further lists are searched in order of distance
+// until k accepted vectors are found
+func (ivf *IVF) SearchFilter(v []float32, k int, filter Filter) ([]Neighbor, error) {
+	neighbors, _, e := ivf.SearchWithStats(v, k, filter)
+	return neighbors, e
+}
+
+// SearchWithStats is SearchFilter which also returns search statistics.
+// Distances to coarse and PQ centroids count as well as scanned vectors.
+func (ivf *IVF) SearchWithStats(v []float32, k int, filter Filter) ([]Neighbor, SearchStats, error) {
+	start := time.Now()
+	if len(v) != ivf.dim {
+		return nil, SearchStats{}, fmt.Errorf("Query dimension %d does not match index dimension %d", len(v), ivf.dim)
+	}
+	if !ivf.built {
+		return nil, SearchStats{}, fmt.Errorf("Index is not built")
+	}
+	if k < 1 {
+		return nil, SearchStats{}, nil
+	}
+	calls := ivf.nlist
+	v = ivf.prepare(v)
+	results := candidateHeap{max: true}
+	push := func(id int32, d float32) {
+		calls++
+		if results.len() < k {
+			results.push(candidate{d, id})
+		} else if d < results.top().distance {
+			results.pop()
+			results.push(candidate{d, id})
+		}
+	}
+	lists, nprobe := ivf.probe(v)
+	for probed, l := range lists {
+		if probed >= nprobe && (filter == nil || results.len() == k) {
+			break
+		}
+		ids := ivf.ids[l]
+		if ivf.subquantizers == 0 {
+			vectors := ivf.vectors[l]
+			for i, id := range ids {
+				if filter.accepts(id) {
+					push(id, ivf.distance(v, vectors[i*ivf.dim:(i+1)*ivf.dim]))
+				}
+			}
+			continue
+		}
+		table, offset := ivf.table(v, l)
+		calls += ivf.subquantizers * pqCentroids
+		codes := ivf.codes[l]
+		for i, id := range ids {
+			if !filter.accepts(id) {
+				continue
+			}
+			d := offset
+			for s, c := range codes[i*ivf.subquantizers : (i+1)*ivf.subquantizers] {
+				d += table[s*pqCentroids+int(c)]
+			}
+			push(id, d)
+		}
+	}
+
+	neighbors := make([]Neighbor, results.len())
+	for i := len(neighbors) - 1; i >= 0; i-- {
+		c := results.pop()
+		neighbors[i] = Neighbor{ID: c.id, Distance: ivf.finish(c.distance)}
+		if ivf.Key != nil {
+			neighbors[i].Key = ivf.Key(c.id)
+		}
+	}
+	return neighbors, SearchStats{Distances: calls, Duration: time.Since(start)}, nil
+}
+
+// finish converts PQ distances, which are squared distances between unit
+// vectors for cosine and angular metrics, to distances of the metric
+func (ivf *IVF) finish(d float32) float32 {
+	if ivf.subquantizers == 0 {
+		return d
+	}
+	switch ivf.metric {
+	case Euclidean:
+		if d < 0 {
+			d = 0
+		}
+		return float32(math.Sqrt(float64(d)))
+	case Cosine:
+		return d / 2
+	case Angular:
+		return CosineToAngular(d / 2)
+	}
+	return d
+}
+
+// SearchID returns up to k neighbours of the vector added under id. IVF-PQ
+// searches the vector reconstructed from its code.
+func (ivf *IVF) SearchID(id int32, k int) ([]Neighbor, error) {
+	for l, ids := range ivf.ids {
+		for i, x := range ids {
+			if x == id {
+				return ivf.Search(ivf.reconstruct(int32(l), i), k)
+			}
+		}
+	}
+	return nil, fmt.Errorf("Item %d is not in index", id)
+}
+
+// reconstruct returns i-th vector of list l
+func (ivf *IVF) reconstruct(l int32, i int) []float32 {
+	if ivf.subquantizers == 0 {
+		return ivf.vectors[l][i*ivf.dim : (i+1)*ivf.dim]
+	}
+	dsub := ivf.dim / ivf.subquantizers
+	v := make([]float32, ivf.dim)
+	copy(v, ivf.centroid(l))
+	for s, c := range ivf.codes[l][i*ivf.subquantizers : (i+1)*ivf.subquantizers] {
+		for j, x := range ivf.codebook(s)[int(c)*dsub : int(c+1)*dsub] {
+			v[s*dsub+j] += x
+		}
+	}
+	return v
+}
+
+// Len returns number of vectors in the index
+func (ivf *IVF) Len() int {
+	return ivf.count + len(ivf.pendingIDs)
+}
+
+// Lists returns the number of inverted lists
+func (ivf *IVF) Lists() int {
+	return ivf.nlist
+}
+
+// Subquantizers returns the number of PQ subquantizers, 0 for IVF-Flat
+func (ivf *IVF) Subquantizers() int {
+	return ivf.subquantizers
+}
+
+// Metric returns the metric neighbours are ranked by
+func (ivf *IVF) Metric() Metric {
+	return ivf.metric
+}
+
+// Save writes the built index in a flat little endian layout of 4 byte
+// words:
+//
+//	header: magic, version, dim, metric, nlist, subquantizers, count,
+//	        nprobe, 4 reserved
+//	centroids[nlist*dim] codebooks[256*dim] (IVF-PQ only)
+//	offsets[nlist+1] ids[count]
+//	vectors[count*dim] (IVF-Flat) or codes[count*subquantizers] bytes
+//	padded to 4 (IVF-PQ)
+//
+// where ids, vectors and codes of list l start at offsets[l].
+func (ivf *IVF) Save(path string) error {
+	if !ivf.built {
+		return fmt.Errorf("Index is not built")
+	}
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+	w := bufio.NewWriter(f)
+	write := func(data interface{}) {
+		if e == nil {
+			e = binary.Write(w, binary.LittleEndian, data)
+		}
+	}
+	write([ivfHeader]int32{ivfMagic, ivfVersion, int32(ivf.dim), int32(ivf.metric), int32(ivf.nlist),
+		int32(ivf.subquantizers), int32(ivf.count), int32(ivf.Nprobe)})
+	write(ivf.centroids)
+	write(ivf.codebooks)
+	offsets := make([]int32, ivf.nlist+1)
+	for l, ids := range ivf.ids {
+		offsets[l+1] = offsets[l] + int32(len(ids))
+	}
+	write(offsets)
+	for _, ids := range ivf.ids {
+		write(ids)
+	}
+	if ivf.subquantizers == 0 {
+		for _, vectors := range ivf.vectors {
+			write(vectors)
+		}
+	} else {
+		for _, codes := range ivf.codes {
+			write(codes)
+		}
+		write(make([]byte, (4-ivf.count*ivf.subquantizers%4)%4))
+	}
+	if e == nil {
+		e = w.Flush()
+	}
+	if e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
+
+// Load memory maps an index written by Save. Lists are used in place,
+// vectors added later are appended to copies of the lists they go to.
+func (ivf *IVF) Load(path string) error {
+	mapped, e := mmap.Open(path)
+	if e != nil {
+		return e
+	}
+	fail := func(format string, args ...interface{}) error {
+		mapped.Close()
+		return fmt.Errorf(format, args...)
+	}
+	if len(mapped.Data) < 4*ivfHeader {
+		return fail("%s is not IVF index", path)
+	}
+	words := unsafe.Slice((*int32)(unsafe.Pointer(&mapped.Data[0])), len(mapped.Data)/4)
+	if words[0] != ivfMagic || words[1] != ivfVersion {
+		return fail("%s is not IVF index", path)
+	}
+	if int(words[2]) != ivf.dim || Metric(words[3]) != ivf.metric {
+		return fail("%s holds %d dimensional %s index, want %d dimensional %s", path, words[2], Metric(words[3]), ivf.dim, ivf.metric)
+	}
+	nlist, subquantizers, count := int(words[4]), int(words[5]), int(words[6])
+	if nlist < 1 || count < 0 || subquantizers < 0 || (subquantizers > 0 && ivf.dim%subquantizers != 0) {
+		return fail("%s has invalid parameters", path)
+	}
+	size := ivfHeader + nlist*ivf.dim + nlist + 1 + count
+	if subquantizers > 0 {
+		size += pqCentroids*ivf.dim + (count*subquantizers+3)/4
+	} else {
+		size += count * ivf.dim
+	}
+	if len(words) != size {
+		return fail("%s is truncated", path)
+	}
+	floats := unsafe.Slice((*float32)(unsafe.Pointer(&words[0])), len(words))
+
+	at := ivfHeader
+	centroids := floats[at : at+nlist*ivf.dim]
+	at += nlist * ivf.dim
+	var codebooks []float32
+	if subquantizers > 0 {
+		codebooks = floats[at : at+pqCentroids*ivf.dim]
+		at += pqCentroids * ivf.dim
+	}
+	offsets := words[at : at+nlist+1]
+	at += nlist + 1
+	ids := words[at : at+count]
+	at += count
+	// lists are consecutive parts of ids
+	if offsets[0] != 0 || int(offsets[nlist]) != count {
+		return fail("%s has list offsets out of range", path)
+	}
+	for l := 0; l < nlist; l++ {
+		if offsets[l] > offsets[l+1] {
+			return fail("%s has list offsets out of range", path)
+		}
+	}
+
+	ivf.centroids, ivf.codebooks = centroids, codebooks
+	ivf.nlist, ivf.subquantizers, ivf.count, ivf.Nprobe = nlist, subquantizers, count, int(words[7])
+	ivf.ids = make([][]int32, nlist)
+	ivf.vectors = make([][]float32, nlist)
+	ivf.codes = make([][]byte, nlist)
+	for l := 0; l < nlist; l++ {
+		start, end := int(offsets[l]), int(offsets[l+1])
+		ivf.ids[l] = ids[start:end:end]
+		if subquantizers > 0 {
+			codes := mapped.Data[4*at:]
+			ivf.codes[l] = codes[start*subquantizers : end*subquantizers : end*subquantizers]
+		} else {
+			vectors := floats[at:]
+			ivf.vectors[l] = vectors[start*ivf.dim : end*ivf.dim : end*ivf.dim]
+		}
+	}
+	if ivf.mapped != nil {
+		ivf.mapped.Close()
+	}
+	ivf.mapped = mapped
+	ivf.pendingIDs, ivf.pending, ivf.added = nil, nil, nil
+	ivf.built = true
+	return nil
+}
+
+// Close unmaps a loaded index and empties it
+func (ivf *IVF) Close() (e error) {
+	ivf.centroids, ivf.codebooks = nil, nil
+	ivf.ids, ivf.vectors, ivf.codes = nil, nil, nil
+	ivf.count = 0
+	ivf.added = nil
+	ivf.built = false
+	if ivf.mapped != nil {
+		e = ivf.mapped.Close()
+		ivf.mapped = nil
+	}
+	return
+}
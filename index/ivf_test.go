@@ -0,0 +1,193 @@
+package index
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func buildIVF(t *testing.T, ivf *IVF, vectors [][]float32) {
+	for i, v := range vectors {
+		if e := ivf.Add(int32(i), v); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := ivf.Build(); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestTrainCentroids(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	// three well separated blobs
+	var vectors []float32
+	for i := 0; i < 300; i++ {
+		center := float32(i%3) * 10
+		vectors = append(vectors, center+float32(r.NormFloat64()), center+float32(r.NormFloat64()))
+	}
+	centroids := trainCentroids(vectors, 2, 3, 20, GoKernels, r)
+	found := make(map[int]bool)
+	for c := 0; c < 3; c++ {
+		x := centroids[2*c]
+		for blob := 0; blob < 3; blob++ {
+			if d := x - float32(blob)*10; d > -1 && d < 1 {
+				found[blob] = true
+			}
+		}
+	}
+	if len(found) != 3 {
+		t.Fatalf("Centroids %v do not match blobs", centroids)
+	}
+}
+
+func TestIVFFlat(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	vectors := randomVectors(r, 2000, 16)
+	for _, metric := range []Metric{Angular, Euclidean, DotProduct} {
+		ivf := NewIVF(16, metric, GoKernels, 16)
+		buildIVF(t, ivf, vectors)
+		if ivf.Len() != len(vectors) {
+			t.Fatalf("Len %d, want %d", ivf.Len(), len(vectors))
+		}
+		distance := GoKernels.Distance(metric)
+		var total float64
+		for q := 0; q < 50; q++ {
+			query := randomVectors(r, 1, 16)[0]
+			want := exactNeighbors(vectors, query, distance, 10)
+			// probing every list is exhaustive search
+			ivf.Nprobe = 16
+			got, e := ivf.Search(query, 10)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if recall(got, want) != 1 {
+				t.Fatalf("%s exhaustive search returned %v, want %v", metric, got, want)
+			}
+			ivf.Nprobe = 8
+			got, _ = ivf.Search(query, 10)
+			total += recall(got, want)
+		}
+		if total/50 < 0.8 {
+			t.Fatalf("%s recall@10 %f with half of lists probed is too low", metric, total/50)
+		}
+	}
+}
+
+func TestIVFPQ(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	vectors := randomVectors(r, 3000, 16)
+	if _, e := NewIVFPQ(16, Euclidean, GoKernels, 8, 5); e == nil {
+		t.Fatal("Subquantizers must divide dimension")
+	}
+	for _, metric := range []Metric{Angular, Euclidean} {
+		ivf, e := NewIVFPQ(16, metric, GoKernels, 8, 8)
+		if e != nil {
+			t.Fatal(e)
+		}
+		buildIVF(t, ivf, vectors)
+		ivf.Nprobe = 8
+		distance := GoKernels.Distance(metric)
+		var total float64
+		for q := 0; q < 50; q++ {
+			query := randomVectors(r, 1, 16)[0]
+			// PQ distances are approximate, true neighbours must be among
+			// the top candidates
+			got, e := ivf.Search(query, 50)
+			if e != nil {
+				t.Fatal(e)
+			}
+			total += recall(got, exactNeighbors(vectors, query, distance, 10))
+		}
+		if total/50 < 0.8 {
+			t.Fatalf("%s recall 10@50 %f is too low", metric, total/50)
+		}
+	}
+}
+
+func TestIVFSaveLoad(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	vectors := randomVectors(r, 1000, 8)
+	for _, subquantizers := range []int{0, 4} {
+		ivf := NewIVF(8, Angular, GoKernels, 8)
+		if subquantizers > 0 {
+			ivf, _ = NewIVFPQ(8, Angular, GoKernels, 8, subquantizers)
+		}
+		// odd number of vectors makes PQ codes need padding
+		buildIVF(t, ivf, vectors[:999])
+		ivf.Nprobe = 3
+		path := filepath.Join(t.TempDir(), "words.ivf")
+		if e := ivf.Save(path); e != nil {
+			t.Fatal(e)
+		}
+
+		loaded := NewIVF(8, Angular, GoKernels, 1)
+		if e := loaded.Load(path); e != nil {
+			t.Fatal(e)
+		}
+		if loaded.Len() != ivf.Len() || loaded.Nprobe != 3 {
+			t.Fatalf("Loaded %d items probing %d lists, want %d items probing 3", loaded.Len(), loaded.Nprobe, ivf.Len())
+		}
+		for q := 0; q < 20; q++ {
+			query := randomVectors(r, 1, 8)[0]
+			want, _ := ivf.Search(query, 5)
+			got, e := loaded.Search(query, 5)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("Loaded index returned %v, want %v", got, want)
+			}
+		}
+
+		// adding to a mapped index must not write to the file
+		if e := loaded.Add(999, vectors[999]); e != nil {
+			t.Fatal(e)
+		}
+		for _, id := range []int32{0, 999} {
+			if e := loaded.Add(id, vectors[id]); e == nil {
+				t.Fatalf("Adding item %d twice must fail", id)
+			}
+		}
+		loaded.Nprobe = 8
+		got, e := loaded.SearchID(999, 1)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if len(got) != 1 || got[0].ID != 999 {
+			t.Fatalf("Added item is not its own nearest neighbour: %v", got)
+		}
+		loaded.Close()
+
+		if e := NewIVF(4, Angular, GoKernels, 8).Load(path); e == nil {
+			t.Fatal("Loading index of other dimension must fail")
+		}
+
+		// list offsets out of order or past the ids must be reported
+		data, e := os.ReadFile(path)
+		if e != nil {
+			t.Fatal(e)
+		}
+		offsets := ivfHeader + 8*8
+		if subquantizers > 0 {
+			offsets += pqCentroids * 8
+		}
+		for _, word := range []struct{ at, value int }{
+			{offsets + 1, 1 << 30},
+			{offsets + 1, -1},
+			{offsets + 8, 1000},
+		} {
+			corrupted := append([]byte(nil), data...)
+			binary.LittleEndian.PutUint32(corrupted[4*word.at:], uint32(int32(word.value)))
+			bad := filepath.Join(t.TempDir(), "corrupted.ivf")
+			if e := os.WriteFile(bad, corrupted, 0644); e != nil {
+				t.Fatal(e)
+			}
+			if e := NewIVF(8, Angular, GoKernels, 8).Load(bad); e == nil {
+				t.Fatalf("Loading index with offset %d at word %d must fail", word.value, word.at)
+			}
+		}
+	}
+}
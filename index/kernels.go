@@ -0,0 +1,108 @@
+package index
+
+import (
+	"fmt"
+	"math"
+)
+
+// Kernels are the vector primitives indexes compute distances with. The
+// govector package provides SIMD implementations, GoKernels is the portable
+// fallback.
+type Kernels struct {
+	// Dot returns x^T y
+	Dot func(x, y []float32) float32
+	// SqDist returns ||x - y||^2
+	SqDist func(x, y []float32) float32
+	// Xpy adds x to y, pure Go one is used when it is nil
+	Xpy func(x, y []float32)
+	// Axpy adds alpha * x to y, pure Go one is used when it is nil
+	Axpy func(alpha float32, x, y []float32)
+}
+
+// GoKernels are pure Go Kernels
+var GoKernels = Kernels{Dot: dot, SqDist: sqDist, Xpy: xpy, Axpy: axpy}
+
+func dot(x, y []float32) (d float32) {
+	for i, v := range x {
+		d += v * y[i]
+	}
+	return
+}
+
+func sqDist(x, y []float32) (d float32) {
+	for i, v := range x {
+		diff := v - y[i]
+		d += diff * diff
+	}
+	return
+}
+
+func xpy(x, y []float32) {
+	for i, v := range x {
+		y[i] += v
+	}
+}
+
+func axpy(alpha float32, x, y []float32) {
+	for i, v := range x {
+		y[i] += alpha * v
+	}
+}
+
+func (k Kernels) xpy(x, y []float32) {
+	if k.Xpy == nil {
+		xpy(x, y)
+		return
+	}
+	k.Xpy(x, y)
+}
+
+func (k Kernels) axpy(alpha float32, x, y []float32) {
+	if k.Axpy == nil {
+		axpy(alpha, x, y)
+		return
+	}
+	k.Axpy(alpha, x, y)
+}
+
+// Distance returns function computing metric between two vectors
+func (k Kernels) Distance(metric Metric) func(x, y []float32) float32 {
+	switch metric {
+	case Angular:
+		return func(x, y []float32) float32 {
+			return CosineToAngular(1 - k.cosine(x, y))
+		}
+	case Cosine:
+		return func(x, y []float32) float32 {
+			return 1 - k.cosine(x, y)
+		}
+	case DotProduct:
+		return func(x, y []float32) float32 {
+			return -k.Dot(x, y)
+		}
+	case Euclidean:
+		return func(x, y []float32) float32 {
+			return float32(math.Sqrt(float64(k.SqDist(x, y))))
+		}
+	}
+	panic(fmt.Errorf("Unknown metric %s", metric))
+}
+
+func (k Kernels) cosine(x, y []float32) float32 {
+	n := math.Sqrt(float64(k.Dot(x, x)) * float64(k.Dot(y, y)))
+	if n == 0 {
+		return 0
+	}
+	return float32(float64(k.Dot(x, y)) / n)
+}
+
+// CosineToAngular converts cosine distance 1 - cos to Angular distance
+func CosineToAngular(d float32) float32 {
+	c := 1 - float64(d)
+	if c > 1 {
+		c = 1
+	} else if c < -1 {
+		c = -1
+	}
+	return float32(math.Acos(c) / math.Pi)
+}
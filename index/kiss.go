@@ -0,0 +1,45 @@
+package index
+
+// kiss64 is Annoy's 64 bit KISS random number generator, using it keeps tree
+// construction reproducible with the same seed.
+type kiss64 struct {
+	x, y, z, c uint64
+}
+
+func newKiss64(seed uint64) *kiss64 {
+	return &kiss64{
+		x: seed,
+		y: 362436362436362436,
+		z: 1066149217761810,
+		c: 123456123456123456,
+	}
+}
+
+func (r *kiss64) kiss() uint64 {
+	// Linear congruence generator
+	r.z = 6906969069*r.z + 1234567
+
+	// Xor shift
+	r.y ^= r.y << 13
+	r.y ^= r.y >> 17
+	r.y ^= r.y << 43
+
+	// Multiply-with-carry
+	t := (r.x << 58) + r.c
+	r.c = r.x >> 6
+	r.x += t
+	if r.x < t {
+		r.c++
+	}
+	return r.x + r.y + r.z
+}
+
+// flip draws random 0 or 1
+func (r *kiss64) flip() int {
+	return int(r.kiss() & 1)
+}
+
+// index draws random integer in [0, n)
+func (r *kiss64) index(n int) int {
+	return int(r.kiss() % uint64(n))
+}
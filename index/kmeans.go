@@ -0,0 +1,374 @@
+package index
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// parallel calls f on runtime.NumCPU() contiguous parts of [0, n)
+func parallel(n int, f func(start, end int)) {
+	workers := runtime.NumCPU()
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		f(0, n)
+		return
+	}
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += chunk {
+		end := start + chunk
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			f(start, end)
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+// nearestCentroid returns the centroid closest to v by distance and the
+// distance itself
+func nearestCentroid(v, centroids []float32, dim int, distance func(x, y []float32) float32) (int32, float32) {
+	best, bestDistance := int32(0), distance(v, centroids[:dim])
+	for c := 1; c < len(centroids)/dim; c++ {
+		if d := distance(v, centroids[c*dim:(c+1)*dim]); d < bestDistance {
+			best, bestDistance = int32(c), d
+		}
+	}
+	return best, bestDistance
+}
+
+// KMeansOptions controls TrainKMeans
+type KMeansOptions struct {
+	// K is the number of centroids, fewer are trained from fewer rows
+	K int
+	// Iterations limits Lloyd iterations, which stop early when no row
+	// changes its centroid, or is the number of mini-batches
+	Iterations int
+	// Spherical trains unit centroids of cosine k-means, rows are assigned
+	// to the centroid of the largest dot product
+	Spherical bool
+	// BatchSize > 0 selects mini-batch k-means, which moves centroids
+	// towards BatchSize random rows per iteration instead of passing over
+	// all rows, see Sculley "Web-Scale K-Means Clustering"
+	BatchSize int
+	// Seed seeds k-means++ and row sampling
+	Seed int64
+}
+
+// DefaultKMeansOptions trains 100 centroids with up to 20 Lloyd iterations
+var DefaultKMeansOptions = KMeansOptions{K: 100, Iterations: 20}
+
+// KMeans holds centroids trained by TrainKMeans and assignments of the
+// training rows to them
+type KMeans struct {
+	Dim       int
+	Spherical bool
+	// Centroids is a row-major K() by Dim matrix
+	Centroids []float32
+	// Assignments holds the centroid of every training row
+	Assignments []int32
+	// Inertia is the sum of distances of training rows to their centroids,
+	// squared Euclidean or cosine distance for spherical k-means
+	Inertia float64
+	// Iterations is the number of Lloyd iterations or mini-batches done
+	Iterations int
+	kernels    Kernels
+}
+
+// TrainKMeans clusters n rows of dim values returned by row, centroids are
+// seeded with k-means++ and refined by Lloyd iterations or mini-batches.
+// Rows are read concurrently and must not be modified.
+func TrainKMeans(n, dim int, row func(i int) []float32, opts KMeansOptions, kernels Kernels) (*KMeans, error) {
+	if opts.K < 1 {
+		return nil, fmt.Errorf("K %d is less than 1", opts.K)
+	}
+	if dim < 1 {
+		return nil, fmt.Errorf("Dimension %d is less than 1", dim)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("No rows to cluster")
+	}
+	return trainKMeans(n, dim, row, opts, kernels, rand.New(rand.NewSource(opts.Seed))), nil
+}
+
+// trainCentroids clusters rows of dim values in vectors into at most k
+// centroids with squared Euclidean k-means and returns the centroids
+func trainCentroids(vectors []float32, dim, k, iterations int, kernels Kernels, r *rand.Rand) []float32 {
+	row := func(i int) []float32 {
+		return vectors[i*dim : (i+1)*dim]
+	}
+	return trainKMeans(len(vectors)/dim, dim, row, KMeansOptions{K: k, Iterations: iterations}, kernels, r).Centroids
+}
+
+func trainKMeans(n, dim int, row func(i int) []float32, opts KMeansOptions, kernels Kernels, r *rand.Rand) *KMeans {
+	k := opts.K
+	if k > n {
+		k = n
+	}
+	km := &KMeans{
+		Dim:         dim,
+		Spherical:   opts.Spherical,
+		Centroids:   make([]float32, k*dim),
+		Assignments: make([]int32, n),
+		kernels:     kernels,
+	}
+	if opts.BatchSize > 0 {
+		// mini-batch k-means is seeded from a sample, k-means++ over
+		// millions of rows would take longer than training
+		size := 3 * opts.BatchSize
+		if size < k {
+			size = k
+		}
+		if size > n {
+			size = n
+		}
+		sample := r.Perm(n)[:size]
+		km.seed(size, func(i int) []float32 { return row(sample[i]) }, r)
+		km.miniBatch(n, row, opts, r)
+	} else {
+		km.seed(n, row, r)
+		km.lloyd(n, row, opts.Iterations, r)
+	}
+
+	var mutex sync.Mutex
+	parallel(n, func(start, end int) {
+		var inertia float64
+		for i := start; i < end; i++ {
+			c, d := km.Assign(row(i))
+			km.Assignments[i] = c
+			inertia += float64(d)
+		}
+		mutex.Lock()
+		km.Inertia += inertia
+		mutex.Unlock()
+	})
+	return km
+}
+
+// K returns the number of centroids
+func (km *KMeans) K() int {
+	return len(km.Centroids) / km.Dim
+}
+
+// Centroid returns centroid c without copying
+func (km *KMeans) Centroid(c int32) []float32 {
+	return km.Centroids[int(c)*km.Dim : int(c+1)*km.Dim]
+}
+
+// Assign returns the centroid closest to v and the distance to it
+func (km *KMeans) Assign(v []float32) (int32, float32) {
+	if !km.Spherical {
+		return nearestCentroid(v, km.Centroids, km.Dim, km.kernels.SqDist)
+	}
+	// unit centroids rank by dot product as by cosine
+	best, bestDot := int32(0), km.kernels.Dot(v, km.Centroid(0))
+	for c := int32(1); c < int32(km.K()); c++ {
+		if d := km.kernels.Dot(v, km.Centroid(c)); d > bestDot {
+			best, bestDot = c, d
+		}
+	}
+	return best, km.cosine(v, bestDot)
+}
+
+// cosine returns cosine distance of v to a unit centroid of dot product dot
+func (km *KMeans) cosine(v []float32, dot float32) float32 {
+	norm := km.kernels.Dot(v, v)
+	if norm == 0 {
+		return 1
+	}
+	// rounding must not make distances negative, they weight k-means++
+	if d := 1 - dot/float32(math.Sqrt(float64(norm))); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// distance returns distance of v to centroid
+func (km *KMeans) distance(v, centroid []float32) float32 {
+	if !km.Spherical {
+		return km.kernels.SqDist(v, centroid)
+	}
+	return km.cosine(v, km.kernels.Dot(v, centroid))
+}
+
+// normalize scales centroid to unit length for spherical k-means
+func (km *KMeans) normalize(centroid []float32) {
+	if !km.Spherical {
+		return
+	}
+	norm := km.kernels.Dot(centroid, centroid)
+	if norm == 0 {
+		return
+	}
+	scale(centroid, 1/float32(math.Sqrt(float64(norm))))
+}
+
+func scale(v []float32, alpha float32) {
+	for i := range v {
+		v[i] *= alpha
+	}
+}
+
+// setCentroid copies v to centroid c
+func (km *KMeans) setCentroid(c int, v []float32) {
+	centroid := km.Centroid(int32(c))
+	copy(centroid, v)
+	km.normalize(centroid)
+}
+
+// seed picks centroids among n rows with k-means++, every next centroid is
+// chosen with probability proportional to the distance to the closest one
+// already picked
+func (km *KMeans) seed(n int, row func(i int) []float32, r *rand.Rand) {
+	km.setCentroid(0, row(r.Intn(n)))
+	closest := make([]float32, n)
+	parallel(n, func(start, end int) {
+		for i := start; i < end; i++ {
+			closest[i] = km.distance(row(i), km.Centroid(0))
+		}
+	})
+	for c := 1; c < km.K(); c++ {
+		var sum float64
+		for _, d := range closest {
+			sum += float64(d)
+		}
+		pick := r.Intn(n)
+		if sum > 0 {
+			target := r.Float64() * sum
+			for pick = 0; pick < n-1; pick++ {
+				if target -= float64(closest[pick]); target < 0 {
+					break
+				}
+			}
+		}
+		km.setCentroid(c, row(pick))
+		centroid := km.Centroid(int32(c))
+		parallel(n, func(start, end int) {
+			for i := start; i < end; i++ {
+				if d := km.distance(row(i), centroid); d < closest[i] {
+					closest[i] = d
+				}
+			}
+		})
+	}
+}
+
+// accumulate adds v to sum of centroid rows, unit v for spherical k-means
+func (km *KMeans) accumulate(v, sum []float32) {
+	if !km.Spherical {
+		km.kernels.xpy(v, sum)
+		return
+	}
+	if norm := km.kernels.Dot(v, v); norm > 0 {
+		km.kernels.axpy(1/float32(math.Sqrt(float64(norm))), v, sum)
+	}
+}
+
+// lloyd refines centroids by moving them to the mean of their rows until
+// no row changes its centroid
+func (km *KMeans) lloyd(n int, row func(i int) []float32, iterations int, r *rand.Rand) {
+	k := km.K()
+	sums := make([]float32, len(km.Centroids))
+	counts := make([]int, k)
+	var mutex sync.Mutex
+	for it := 0; it < iterations; it++ {
+		for i := range sums {
+			sums[i] = 0
+		}
+		for i := range counts {
+			counts[i] = 0
+		}
+		var changed int64
+		parallel(n, func(start, end int) {
+			localSums := make([]float32, len(sums))
+			localCounts := make([]int, k)
+			var local int64
+			for i := start; i < end; i++ {
+				v := row(i)
+				c, _ := km.Assign(v)
+				if c != km.Assignments[i] || it == 0 {
+					km.Assignments[i] = c
+					local++
+				}
+				localCounts[c]++
+				km.accumulate(v, localSums[int(c)*km.Dim:int(c+1)*km.Dim])
+			}
+			atomic.AddInt64(&changed, local)
+			mutex.Lock()
+			km.kernels.xpy(localSums, sums)
+			for c, count := range localCounts {
+				counts[c] += count
+			}
+			mutex.Unlock()
+		})
+		if changed == 0 {
+			break
+		}
+		km.Iterations++
+		for c, count := range counts {
+			if count == 0 {
+				// reseed empty cluster with a random row
+				km.setCentroid(c, row(r.Intn(n)))
+				continue
+			}
+			centroid := km.Centroid(int32(c))
+			copy(centroid, sums[c*km.Dim:(c+1)*km.Dim])
+			if km.Spherical {
+				km.normalize(centroid)
+			} else {
+				scale(centroid, 1/float32(count))
+			}
+		}
+	}
+}
+
+// miniBatch moves every centroid towards rows of random batches assigned
+// to it with learning rate decreasing with the number of rows it got
+func (km *KMeans) miniBatch(n int, row func(i int) []float32, opts KMeansOptions, r *rand.Rand) {
+	counts := make([]int, km.K())
+	batch := make([]int, opts.BatchSize)
+	assignments := make([]int32, opts.BatchSize)
+	for it := 0; it < opts.Iterations; it++ {
+		for j := range batch {
+			batch[j] = r.Intn(n)
+		}
+		parallel(len(batch), func(start, end int) {
+			for j := start; j < end; j++ {
+				assignments[j], _ = km.Assign(row(batch[j]))
+			}
+		})
+		for j, i := range batch {
+			v := row(i)
+			length := float32(1)
+			if km.Spherical {
+				norm := km.kernels.Dot(v, v)
+				if norm == 0 {
+					continue
+				}
+				length = float32(math.Sqrt(float64(norm)))
+			}
+			c := assignments[j]
+			counts[c]++
+			rate := 1 / float32(counts[c])
+			centroid := km.Centroid(c)
+			scale(centroid, 1-rate)
+			km.kernels.axpy(rate/length, v, centroid)
+		}
+		if km.Spherical {
+			for c := 0; c < km.K(); c++ {
+				km.normalize(km.Centroid(int32(c)))
+			}
+		}
+		km.Iterations++
+	}
+}
@@ -0,0 +1,125 @@
+package index
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// checkBlobLabels fails unless blobs of size consecutive points are
+// labelled with clusters of their own, at most misses points of a blob may
+// be labelled otherwise or with a negative label, which is no cluster
+func checkBlobLabels(t *testing.T, labels []int, blobs, size, misses int) {
+	t.Helper()
+	seen := make(map[int]int)
+	for b := 0; b < blobs; b++ {
+		counts := make(map[int]int)
+		for _, label := range labels[b*size : (b+1)*size] {
+			counts[label]++
+		}
+		best := -1
+		for label, count := range counts {
+			if label >= 0 && (best < 0 || count > counts[best]) {
+				best = label
+			}
+		}
+		if best < 0 || counts[best] < size-misses {
+			t.Fatalf("Blob %d labelled %v", b, counts)
+		}
+		if other, ok := seen[best]; ok {
+			t.Fatalf("Blobs %d and %d share cluster %d", other, b, best)
+		}
+		seen[best] = b
+	}
+}
+
+// checkBlobs fails unless every blob of size consecutive rows is assigned
+// to a single cluster of its own
+func checkBlobs(t *testing.T, km *KMeans, blobs, size int) {
+	t.Helper()
+	labels := make([]int, len(km.Assignments))
+	for i, c := range km.Assignments {
+		labels[i] = int(c)
+	}
+	checkBlobLabels(t, labels, blobs, size, 0)
+}
+
+func TestKMeans(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	const blobs, size, dim = 4, 200, 8
+	var vectors []float32
+	for b := 0; b < blobs; b++ {
+		for i := 0; i < size; i++ {
+			for j := 0; j < dim; j++ {
+				center := float32(0)
+				if j == b {
+					center = 20
+				}
+				vectors = append(vectors, center+float32(r.NormFloat64()))
+			}
+		}
+	}
+	row := func(i int) []float32 { return vectors[i*dim : (i+1)*dim] }
+	n := len(vectors) / dim
+
+	// k-means++ seeds two centroids in one blob now and then, which Lloyd
+	// iterations do not fix, seeds are chosen so that it does not
+	for _, opts := range []KMeansOptions{
+		{K: blobs, Iterations: 20, Seed: 1},
+		{K: blobs, Iterations: 50, BatchSize: 64, Seed: 1},
+		{K: blobs, Iterations: 20, Spherical: true, Seed: 2},
+		{K: blobs, Iterations: 50, BatchSize: 64, Spherical: true, Seed: 1},
+	} {
+		km, e := TrainKMeans(n, dim, row, opts, GoKernels)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if km.K() != blobs || len(km.Assignments) != n {
+			t.Fatalf("%+v: %d centroids and %d assignments", opts, km.K(), len(km.Assignments))
+		}
+		checkBlobs(t, km, blobs, size)
+		var inertia float64
+		for i := 0; i < n; i++ {
+			c, d := km.Assign(row(i))
+			if c != km.Assignments[i] {
+				t.Fatalf("%+v: row %d assigned to %d, Assign returns %d", opts, i, km.Assignments[i], c)
+			}
+			inertia += float64(d)
+		}
+		if math.Abs(inertia-km.Inertia) > 1e-3*inertia {
+			t.Fatalf("%+v: inertia %f, want %f", opts, km.Inertia, inertia)
+		}
+		if opts.Spherical {
+			for c := int32(0); c < int32(km.K()); c++ {
+				if norm := GoKernels.Dot(km.Centroid(c), km.Centroid(c)); math.Abs(float64(norm)-1) > 1e-4 {
+					t.Fatalf("%+v: centroid %d has squared norm %f", opts, c, norm)
+				}
+			}
+		}
+	}
+
+	// rows scaled arbitrarily keep their spherical clusters
+	scaled := make([]float32, len(vectors))
+	for i := 0; i < n; i++ {
+		alpha := 0.1 + 10*r.Float32()
+		for j, x := range row(i) {
+			scaled[i*dim+j] = alpha * x
+		}
+	}
+	km, e := TrainKMeans(n, dim, func(i int) []float32 { return scaled[i*dim : (i+1)*dim] }, KMeansOptions{K: blobs, Iterations: 20, Spherical: true, Seed: 2}, GoKernels)
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkBlobs(t, km, blobs, size)
+
+	// fewer rows than centroids
+	if km, e := TrainKMeans(3, dim, row, KMeansOptions{K: 10, Iterations: 5}, GoKernels); e != nil || km.K() != 3 {
+		t.Fatalf("K %d from 3 rows, error %v", km.K(), e)
+	}
+	if _, e := TrainKMeans(n, dim, row, KMeansOptions{Iterations: 5}, GoKernels); e == nil {
+		t.Fatal("K 0 must fail")
+	}
+	if _, e := TrainKMeans(0, dim, row, DefaultKMeansOptions, GoKernels); e == nil {
+		t.Fatal("No rows must fail")
+	}
+}
@@ -0,0 +1,43 @@
+package index
+
+import "fmt"
+
+// Metric identifies the distance an index ranks neighbours by. Smaller
+// distances are always closer.
+type Metric int
+
+const (
+	// Angular distance acos(cos(x, y)) / Pi, the angle between vectors
+	// scaled to [0, 1], unlike Cosine it satisfies triangle inequality
+	Angular Metric = iota
+	// Cosine distance 1 - cos(x, y)
+	Cosine
+	// DotProduct distance -x^T y, for maximum inner product search
+	DotProduct
+	// Euclidean distance ||x - y||
+	Euclidean
+)
+
+func (m Metric) String() string {
+	switch m {
+	case Angular:
+		return "angular"
+	case Cosine:
+		return "cosine"
+	case DotProduct:
+		return "dot"
+	case Euclidean:
+		return "euclidean"
+	}
+	return fmt.Sprintf("Metric(%d)", int(m))
+}
+
+// ParseMetric returns the metric with the given name as printed by String
+func ParseMetric(name string) (Metric, error) {
+	for _, m := range []Metric{Angular, Cosine, DotProduct, Euclidean} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown metric %q", name)
+}
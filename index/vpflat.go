@@ -0,0 +1,301 @@
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/vseledkin/govector/mmap"
+)
+
+const (
+	vpMagic   = 0x31545056 // "VPT1"
+	vpVersion = 1
+	// vpHeader is the number of 4 byte words before nodes
+	vpHeader = 4
+)
+
+// RowItem is implemented by VPTree items which are persisted by row id,
+// items of type int32 are row ids themselves
+type RowItem interface {
+	RowID() int32
+}
+
+func rowID(item interface{}) (int32, error) {
+	switch t := item.(type) {
+	case int32:
+		return t, nil
+	case RowItem:
+		return t.RowID(), nil
+	}
+	return 0, fmt.Errorf("Item of type %T has no row id", item)
+}
+
+// vpFlatNode is a VP-tree node in the flat layout, children are node
+// indexes or -1
+type vpFlatNode struct {
+	ID        int32
+	Threshold float32
+	Left      int32
+	Right     int32
+}
+
+// Save writes the tree in a flat little endian layout of 4 byte words:
+//
+//	header: magic, version, count, reserved
+//	nodes[count]: row id, threshold, left, right
+//
+// where nodes are in preorder starting with the root and children are node
+// indexes or -1. Items must be int32 row ids or implement RowItem.
+func (vp *VPTree[T]) Save(w io.Writer) error {
+	nodes, e := vp.flatten()
+	if e != nil {
+		return e
+	}
+	return writeVPNodes(w, nodes)
+}
+
+// flatten lays the tree out in preorder, a tree with deleted items is
+// rebuilt without them first
+func (vp *VPTree[T]) flatten() ([]vpFlatNode, error) {
+	if vp.root != nil && vp.root.Tombstones > 0 {
+		return NewVPTreeWithOptions(vp.distanceMetric, vp.Items(), vp.opts).flatten()
+	}
+	var nodes []vpFlatNode
+	if vp.root != nil {
+		nodes = make([]vpFlatNode, 0, vp.root.Size)
+	}
+	var flatten func(n *Node[T]) (int32, error)
+	flatten = func(n *Node[T]) (int32, error) {
+		if n == nil {
+			return -1, nil
+		}
+		id, e := rowID(n.Item)
+		if e != nil {
+			return -1, e
+		}
+		i := int32(len(nodes))
+		nodes = append(nodes, vpFlatNode{ID: id, Threshold: n.Threshold})
+		if nodes[i].Left, e = flatten(n.Left); e != nil {
+			return -1, e
+		}
+		nodes[i].Right, e = flatten(n.Right)
+		return i, e
+	}
+	_, e := flatten(vp.root)
+	return nodes, e
+}
+
+func writeVPNodes(w io.Writer, nodes []vpFlatNode) error {
+	bw := bufio.NewWriter(w)
+	if e := binary.Write(bw, binary.LittleEndian, [vpHeader]int32{vpMagic, vpVersion, int32(len(nodes))}); e != nil {
+		return e
+	}
+	if e := binary.Write(bw, binary.LittleEndian, nodes); e != nil {
+		return e
+	}
+	return bw.Flush()
+}
+
+// FlatVPTree is a VP-tree over row ids in the layout written by
+// VPTree.Save. It is searched in place, so a mapped file needs no decoding.
+type FlatVPTree struct {
+	nodes  []vpFlatNode
+	mapped *mmap.ReaderAt
+	metric func(x, y int32) float32
+}
+
+// LoadVPTree reads a tree written by VPTree.Save, metric is the distance
+// between rows used by SearchID
+func LoadVPTree(r io.Reader, metric func(x, y int32) float32) (*FlatVPTree, error) {
+	var header [vpHeader]int32
+	if e := binary.Read(r, binary.LittleEndian, &header); e != nil {
+		return nil, e
+	}
+	if header[0] != vpMagic || header[1] != vpVersion || header[2] < 0 {
+		return nil, fmt.Errorf("Not VP-tree data")
+	}
+	nodes := make([]vpFlatNode, header[2])
+	if e := binary.Read(r, binary.LittleEndian, nodes); e != nil {
+		return nil, e
+	}
+	return newFlatVPTree(nodes, metric)
+}
+
+// MapVPTree memory maps a tree file written by VPTree.Save
+func MapVPTree(path string, metric func(x, y int32) float32) (*FlatVPTree, error) {
+	mapped, e := mmap.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	words := len(mapped.Data) / 4
+	if words < vpHeader {
+		mapped.Close()
+		return nil, fmt.Errorf("%s is not VP-tree", path)
+	}
+	header := unsafe.Slice((*int32)(unsafe.Pointer(&mapped.Data[0])), vpHeader)
+	if header[0] != vpMagic || header[1] != vpVersion || header[2] < 0 {
+		mapped.Close()
+		return nil, fmt.Errorf("%s is not VP-tree", path)
+	}
+	count := int(header[2])
+	if words != vpHeader+4*count {
+		mapped.Close()
+		return nil, fmt.Errorf("%s is truncated", path)
+	}
+	var nodes []vpFlatNode
+	if count > 0 {
+		nodes = unsafe.Slice((*vpFlatNode)(unsafe.Pointer(&mapped.Data[4*vpHeader])), count)
+	}
+	t, e := newFlatVPTree(nodes, metric)
+	if e != nil {
+		mapped.Close()
+		return nil, e
+	}
+	t.mapped = mapped
+	return t, nil
+}
+
+func newFlatVPTree(nodes []vpFlatNode, metric func(x, y int32) float32) (*FlatVPTree, error) {
+	// children always follow their parent in preorder, so a search can not
+	// loop over corrupted data
+	for i, n := range nodes {
+		if (n.Left != -1 && (int(n.Left) <= i || int(n.Left) >= len(nodes))) ||
+			(n.Right != -1 && (int(n.Right) <= i || int(n.Right) >= len(nodes))) {
+			return nil, fmt.Errorf("VP-tree node %d has invalid children", i)
+		}
+	}
+	return &FlatVPTree{nodes: nodes, metric: metric}, nil
+}
+
+// Close unmaps a mapped tree
+func (t *FlatVPTree) Close() (e error) {
+	if t.mapped != nil {
+		e = t.mapped.Close()
+		t.mapped = nil
+	}
+	t.nodes = nil
+	return
+}
+
+// Len returns number of rows in the tree
+func (t *FlatVPTree) Len() int {
+	return len(t.nodes)
+}
+
+// IDs returns row ids of the tree in storage order
+func (t *FlatVPTree) IDs() []int32 {
+	ids := make([]int32, len(t.nodes))
+	for i, n := range t.nodes {
+		ids[i] = n.ID
+	}
+	return ids
+}
+
+// Search returns up to k row ids closest to a target and the corresponding
+// distances ordered from the closest, distance returns the distance from
+// the target to a row
+func (t *FlatVPTree) Search(distance func(id int32) float32, k int) (ids []int32, distances []float32) {
+	return t.SearchFilter(distance, k, nil)
+}
+
+// SearchFilter is Search which returns only rows accepted by filter
+func (t *FlatVPTree) SearchFilter(distance func(id int32) float32, k int, filter Filter) (ids []int32, distances []float32) {
+	ids, distances, _ = t.searchCount(distance, k, 0, filter)
+	return
+}
+
+// searchCount is SearchFilter which also returns the number of computed
+// distances, rows farther than a positive cutoff are not returned
+func (t *FlatVPTree) searchCount(distance func(id int32) float32, k int, cutoff float32, filter Filter) (ids []int32, distances []float32, calls int) {
+	if k < 1 || len(t.nodes) == 0 {
+		return
+	}
+	h := candidateHeap{items: make([]candidate, 0, k), max: true}
+	var tau float32 = math.MaxFloat32
+	if cutoff > 0 {
+		tau = cutoff
+	}
+	t.search(0, distance, k, filter, &h, &tau, &calls)
+	atomic.AddInt64(&MetricCalls, int64(calls))
+	ids = make([]int32, h.len())
+	distances = make([]float32, h.len())
+	for i := len(ids) - 1; i >= 0; i-- {
+		c := h.pop()
+		ids[i], distances[i] = c.id, c.distance
+	}
+	return
+}
+
+// SearchID returns up to k row ids closest to row id by the tree metric
+func (t *FlatVPTree) SearchID(id int32, k int) (ids []int32, distances []float32) {
+	return t.Search(func(row int32) float32 { return t.metric(id, row) }, k)
+}
+
+func (t *FlatVPTree) search(i int32, distance func(id int32) float32, k int, filter Filter, h *candidateHeap, tau *float32, calls *int) {
+	n := &t.nodes[i]
+	*calls++
+	d := distance(n.ID)
+	if d < *tau && filter.accepts(n.ID) {
+		if h.len() == k {
+			h.pop()
+		}
+		h.push(candidate{d, n.ID})
+		if h.len() == k {
+			*tau = h.top().distance
+		}
+	}
+
+	if d < n.Threshold {
+		if d-*tau <= n.Threshold && n.Left != -1 {
+			t.search(n.Left, distance, k, filter, h, tau, calls)
+		}
+		if d+*tau >= n.Threshold && n.Right != -1 {
+			t.search(n.Right, distance, k, filter, h, tau, calls)
+		}
+	} else {
+		if d+*tau >= n.Threshold && n.Right != -1 {
+			t.search(n.Right, distance, k, filter, h, tau, calls)
+		}
+		if d-*tau <= n.Threshold && n.Left != -1 {
+			t.search(n.Left, distance, k, filter, h, tau, calls)
+		}
+	}
+}
+
+// SearchRadius returns all row ids within radius of a target and the
+// corresponding distances ordered from the closest
+func (t *FlatVPTree) SearchRadius(distance func(id int32) float32, radius float32) (ids []int32, distances []float32) {
+	if len(t.nodes) == 0 || radius < 0 {
+		return
+	}
+	var hits []candidate
+	t.searchRadius(0, distance, radius, &hits)
+	sort.Slice(hits, func(i, j int) bool { return hits[i].distance < hits[j].distance })
+	ids = make([]int32, len(hits))
+	distances = make([]float32, len(hits))
+	for i, c := range hits {
+		ids[i], distances[i] = c.id, c.distance
+	}
+	return
+}
+
+func (t *FlatVPTree) searchRadius(i int32, distance func(id int32) float32, radius float32, hits *[]candidate) {
+	n := &t.nodes[i]
+	atomic.AddInt64(&MetricCalls, 1)
+	d := distance(n.ID)
+	if d <= radius {
+		*hits = append(*hits, candidate{d, n.ID})
+	}
+	if d-radius <= n.Threshold && n.Left != -1 {
+		t.searchRadius(n.Left, distance, radius, hits)
+	}
+	if d+radius >= n.Threshold && n.Right != -1 {
+		t.searchRadius(n.Right, distance, radius, hits)
+	}
+}
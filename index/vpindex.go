@@ -0,0 +1,199 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// vpItem is a VPTree item of VPIndex, query vectors are wrapped with id -1
+type vpItem struct {
+	id     int32
+	vector []float32
+}
+
+// RowID returns the row id of the item
+func (item *vpItem) RowID() int32 {
+	return item.id
+}
+
+// VPIndex adapts VPTree to Index. Tree items are row ids with their vectors
+// and distance is computed between vectors, so it must be a true metric for
+// searches to be exact. A saved tree holds only row ids, so vectors must be
+// added before Load.
+type VPIndex struct {
+	tree     *VPTree[*vpItem]
+	flat     *FlatVPTree
+	items    []*vpItem
+	byID     map[int32]*vpItem
+	metric   Metric
+	distance func(x, y []float32) float32
+	key      func(id int32) string
+	// Cutoff makes searches skip items farther than it from the query when
+	// positive, they compute fewer distances but may return fewer than k
+	// neighbours
+	Cutoff float32
+}
+
+// NewVPIndex creates an empty VPIndex ranking by metric which is computed by
+// distance. key labels search results, it may be nil.
+func NewVPIndex(metric Metric, distance func(x, y []float32) float32, key func(id int32) string) *VPIndex {
+	return &VPIndex{
+		byID:     make(map[int32]*vpItem),
+		metric:   metric,
+		distance: distance,
+		key:      key,
+	}
+}
+
+func (vi *VPIndex) itemDistance(x, y *vpItem) float32 {
+	return vi.distance(x.vector, y.vector)
+}
+
+// Add adds vector v under row id
+func (vi *VPIndex) Add(id int32, v []float32) error {
+	if vi.tree != nil || vi.flat != nil {
+		return fmt.Errorf("Can not add item %d to built index", id)
+	}
+	if _, ok := vi.byID[id]; ok {
+		return fmt.Errorf("Item %d is already added", id)
+	}
+	item := &vpItem{id, v}
+	vi.byID[id] = item
+	vi.items = append(vi.items, item)
+	return nil
+}
+
+// Build builds VPTree over all added vectors
+func (vi *VPIndex) Build() error {
+	if vi.flat != nil {
+		vi.flat.Close()
+		vi.flat = nil
+	}
+	vi.tree = NewVPTree(vi.itemDistance, vi.items)
+	return nil
+}
+
+// Search returns up to k neighbours of v
+func (vi *VPIndex) Search(v []float32, k int) ([]Neighbor, error) {
+	return vi.SearchFilter(v, k, nil)
+}
+
+// SearchFilter returns up to k neighbours of v accepted by filter, the
+// search is exact whatever the filter accepts
+func (vi *VPIndex) SearchFilter(v []float32, k int, filter Filter) ([]Neighbor, error) {
+	neighbors, _, e := vi.SearchWithStats(v, k, filter)
+	return neighbors, e
+}
+
+// SearchWithStats is SearchFilter which also returns search statistics
+func (vi *VPIndex) SearchWithStats(v []float32, k int, filter Filter) ([]Neighbor, SearchStats, error) {
+	start := time.Now()
+	var stats SearchStats
+	if vi.flat != nil {
+		ids, distances, calls := vi.flat.searchCount(func(id int32) float32 {
+			return vi.distance(v, vi.byID[id].vector)
+		}, k, vi.Cutoff, filter)
+		items := make([]*vpItem, len(ids))
+		for i, id := range ids {
+			items[i] = vi.byID[id]
+		}
+		stats = SearchStats{Distances: calls, Duration: time.Since(start)}
+		return vi.neighbors(items, distances), stats, nil
+	}
+	if vi.tree == nil {
+		return nil, stats, fmt.Errorf("Index is not built")
+	}
+	var accepts func(item *vpItem) bool
+	if filter != nil {
+		accepts = func(item *vpItem) bool { return filter(item.id) }
+	}
+	items, distances, calls := vi.tree.searchCount(&vpItem{-1, v}, k, vi.Cutoff, accepts)
+	stats = SearchStats{Distances: calls, Duration: time.Since(start)}
+	return vi.neighbors(items, distances), stats, nil
+}
+
+// SearchID returns up to k neighbours of the vector added under id
+func (vi *VPIndex) SearchID(id int32, k int) ([]Neighbor, error) {
+	item, ok := vi.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("Item %d is not in index", id)
+	}
+	return vi.Search(item.vector, k)
+}
+
+func (vi *VPIndex) neighbors(items []*vpItem, distances []float32) []Neighbor {
+	neighbors := make([]Neighbor, len(items))
+	for i, item := range items {
+		neighbors[i] = Neighbor{ID: item.id, Distance: distances[i]}
+		if vi.key != nil {
+			neighbors[i].Key = vi.key(neighbors[i].ID)
+		}
+	}
+	return neighbors
+}
+
+// Save writes the built or loaded tree to path
+func (vi *VPIndex) Save(path string) error {
+	var nodes []vpFlatNode
+	switch {
+	case vi.tree != nil:
+	case vi.flat != nil:
+		// path may be the mapped file itself, nodes are copied before it is
+		// truncated
+		nodes = append([]vpFlatNode(nil), vi.flat.nodes...)
+	default:
+		return fmt.Errorf("Index is not built")
+	}
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+	if vi.tree != nil {
+		e = vi.tree.Save(f)
+	} else {
+		e = writeVPNodes(f, nodes)
+	}
+	if e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
+
+// Load maps a tree saved to path instead of building it, the tree must hold
+// exactly the added row ids
+func (vi *VPIndex) Load(path string) error {
+	flat, e := MapVPTree(path, func(x, y int32) float32 {
+		return vi.distance(vi.byID[x].vector, vi.byID[y].vector)
+	})
+	if e != nil {
+		return e
+	}
+	if flat.Len() != len(vi.items) {
+		flat.Close()
+		return fmt.Errorf("%s holds %d items, index has %d", path, flat.Len(), len(vi.items))
+	}
+	for _, id := range flat.IDs() {
+		if _, ok := vi.byID[id]; !ok {
+			flat.Close()
+			return fmt.Errorf("%s holds item %d which is not in index", path, id)
+		}
+	}
+	if vi.flat != nil {
+		vi.flat.Close()
+	}
+	vi.flat = flat
+	vi.tree = nil
+	return nil
+}
+
+// Len returns number of added vectors
+func (vi *VPIndex) Len() int {
+	return len(vi.items)
+}
+
+// Metric returns the metric distances are computed by
+func (vi *VPIndex) Metric() Metric {
+	return vi.metric
+}
@@ -0,0 +1,510 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func euclidean(x, y []float32) float32 {
+	var d float32
+	for i := range x {
+		d += (x[i] - y[i]) * (x[i] - y[i])
+	}
+	return float32(math.Sqrt(float64(d)))
+}
+
+func randomVectors(r *rand.Rand, n, dim int) [][]float32 {
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = r.Float32()
+		}
+	}
+	return vectors
+}
+
+// exact returns ids of k vectors closest to query
+func exact(vectors [][]float32, query []float32, k int) []int32 {
+	ids := make([]int32, len(vectors))
+	for i := range ids {
+		ids[i] = int32(i)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return euclidean(vectors[ids[i]], query) < euclidean(vectors[ids[j]], query)
+	})
+	if len(ids) > k {
+		ids = ids[:k]
+	}
+	return ids
+}
+
+func TestVPIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	vectors := randomVectors(r, 2000, 8)
+	var idx Index = NewVPIndex(Euclidean, euclidean, nil)
+	for i, v := range vectors {
+		if e := idx.Add(int32(i), v); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := idx.Build(); e != nil {
+		t.Fatal(e)
+	}
+	if idx.Len() != len(vectors) {
+		t.Fatalf("want %d items got %d", len(vectors), idx.Len())
+	}
+	for q := 0; q < 20; q++ {
+		query := randomVectors(r, 1, 8)[0]
+		neighbors, e := idx.Search(query, 10)
+		if e != nil {
+			t.Fatal(e)
+		}
+		for i, id := range exact(vectors, query, 10) {
+			if neighbors[i].ID != id {
+				t.Fatalf("query %d result %d want %d got %d", q, i, id, neighbors[i].ID)
+			}
+		}
+	}
+	neighbors, e := idx.SearchID(7, 1)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if neighbors[0].ID != 7 || neighbors[0].Distance != 0 {
+		t.Fatalf("item itself expected first, got %v", neighbors[0])
+	}
+}
+
+func TestVPTreeSaveLoad(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	vectors := randomVectors(r, 1000, 8)
+	items := make([]int32, len(vectors))
+	for i := range items {
+		items[i] = int32(i)
+	}
+	metric := func(x, y int32) float32 {
+		return euclidean(vectors[x], vectors[y])
+	}
+	tree := NewVPTree(metric, items)
+	var buf bytes.Buffer
+	if e := tree.Save(&buf); e != nil {
+		t.Fatal(e)
+	}
+	path := filepath.Join(t.TempDir(), "words.vpt")
+	if e := os.WriteFile(path, buf.Bytes(), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	loaded, e := LoadVPTree(bytes.NewReader(buf.Bytes()), metric)
+	if e != nil {
+		t.Fatal(e)
+	}
+	mapped, e := MapVPTree(path, metric)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer mapped.Close()
+	for _, flat := range []*FlatVPTree{loaded, mapped} {
+		if flat.Len() != len(vectors) {
+			t.Fatalf("Loaded %d rows, want %d", flat.Len(), len(vectors))
+		}
+		for q := int32(0); q < 20; q++ {
+			ids, distances := flat.SearchID(q, 5)
+			items, want := tree.Search(q, 5, 0)
+			for i := range items {
+				if ids[i] != items[i] || distances[i] != want[i] {
+					t.Fatalf("Row %d neighbour %d is %d at %f, want %d at %f", q, i, ids[i], distances[i], items[i], want[i])
+				}
+			}
+		}
+	}
+
+	strings := NewVPTree(func(x, y string) float32 { return 0 }, []string{"a"})
+	if e := strings.Save(&buf); e == nil {
+		t.Fatal("Saving items without row ids must fail")
+	}
+	if _, e := LoadVPTree(bytes.NewReader(buf.Bytes()[4:]), metric); e == nil {
+		t.Fatal("Loading corrupted data must fail")
+	}
+}
+
+func TestVPIndexSaveLoad(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	vectors := randomVectors(r, 500, 8)
+	build := func(n int) *VPIndex {
+		idx := NewVPIndex(Euclidean, euclidean, nil)
+		for i, v := range vectors[:n] {
+			if e := idx.Add(int32(i), v); e != nil {
+				t.Fatal(e)
+			}
+		}
+		return idx
+	}
+	idx := build(len(vectors))
+	path := filepath.Join(t.TempDir(), "words.vpt")
+	if e := idx.Save(path); e == nil {
+		t.Fatal("Saving index which is not built must fail")
+	}
+	idx.Build()
+	if e := idx.Save(path); e != nil {
+		t.Fatal(e)
+	}
+
+	loaded := build(len(vectors))
+	if e := loaded.Load(path); e != nil {
+		t.Fatal(e)
+	}
+	for q := 0; q < 20; q++ {
+		query := randomVectors(r, 1, 8)[0]
+		want, _ := idx.Search(query, 10)
+		got, e := loaded.Search(query, 10)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Loaded index returned %v, want %v", got, want)
+		}
+	}
+	if e := loaded.Add(int32(len(vectors)), vectors[0]); e == nil {
+		t.Fatal("Adding to loaded index must fail")
+	}
+
+	// loaded tree is saved as it was mapped
+	saved, e := os.ReadFile(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	again := filepath.Join(t.TempDir(), "again.vpt")
+	if e := loaded.Save(again); e != nil {
+		t.Fatal(e)
+	}
+	if data, e := os.ReadFile(again); e != nil || !bytes.Equal(data, saved) {
+		t.Fatalf("Loaded index saved other data: %v", e)
+	}
+	if e := build(len(vectors) - 1).Load(path); e == nil {
+		t.Fatal("Loading tree over other items must fail")
+	}
+}
+
+func TestRowVPTree(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	vectors := randomVectors(r, 1500, 8)
+	var matrix []float32
+	for _, v := range vectors {
+		matrix = append(matrix, v...)
+	}
+	tree := NewRowVPTree(matrix, 8, euclidean, nil)
+	if tree.Len() != len(vectors) {
+		t.Fatalf("Len %d, want %d", tree.Len(), len(vectors))
+	}
+	for q := 0; q < 20; q++ {
+		query := randomVectors(r, 1, 8)[0]
+		ids, distances := tree.Search(query, 10)
+		for i, id := range exact(vectors, query, 10) {
+			if ids[i] != id || distances[i] != euclidean(query, vectors[id]) {
+				t.Fatalf("Query %d result %d is %d at %f, want %d", q, i, ids[i], distances[i], id)
+			}
+		}
+	}
+
+	// a subset of rows
+	odd := make([]int32, 0, len(vectors)/2)
+	for i := 1; i < len(vectors); i += 2 {
+		odd = append(odd, int32(i))
+	}
+	tree = NewRowVPTree(matrix, 8, euclidean, odd)
+	ids, _ := tree.SearchID(1, 50)
+	if ids[0] != 1 {
+		t.Fatalf("Row itself expected first, got %d", ids[0])
+	}
+	for _, id := range ids {
+		if id%2 == 0 {
+			t.Fatalf("Row %d is not in tree", id)
+		}
+	}
+}
+
+func TestVPTreeOptions(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	vectors := randomVectors(r, 5000, 8)
+	items := make([]int32, len(vectors))
+	for i := range items {
+		items[i] = int32(i)
+	}
+	metric := func(x, y int32) float32 {
+		return euclidean(vectors[x], vectors[y])
+	}
+	opts := DefaultVPTreeOptions
+	opts.Workers = 1
+	serial, e := NewVPTreeWithOptions(metric, items, opts).flatten()
+	if e != nil {
+		t.Fatal(e)
+	}
+	opts.Workers = 8
+	tree := NewVPTreeWithOptions(metric, items, opts)
+	parallel, _ := tree.flatten()
+	if !reflect.DeepEqual(serial, parallel) {
+		t.Fatal("Trees built with the same seed differ")
+	}
+	opts.Seed = 2
+	if other, _ := NewVPTreeWithOptions(metric, items, opts).flatten(); reflect.DeepEqual(serial, other) {
+		t.Fatal("Trees built with different seeds are identical")
+	}
+	if tree.root.Size != len(items) {
+		t.Fatalf("Root size %d, want %d", tree.root.Size, len(items))
+	}
+	for q := int32(0); q < 20; q++ {
+		got, _ := tree.Search(q, 10, 0)
+		for i, id := range exact(vectors, vectors[q], 10) {
+			if got[i] != id {
+				t.Fatalf("Query %d result %d is %d, want %d", q, i, got[i], id)
+			}
+		}
+	}
+
+	// random vantage points
+	opts.Candidates = 0
+	tree = NewVPTreeWithOptions(metric, items, opts)
+	got, _ := tree.Search(7, 1, 0)
+	if got[0] != 7 {
+		t.Fatalf("Item itself expected first, got %d", got[0])
+	}
+}
+
+func BenchmarkVPTreeBuild(b *testing.B) {
+	r := rand.New(rand.NewSource(6))
+	vectors := randomVectors(r, 50000, 32)
+	items := make([]int32, len(vectors))
+	for i := range items {
+		items[i] = int32(i)
+	}
+	metric := func(x, y int32) float32 {
+		return euclidean(vectors[x], vectors[y])
+	}
+	for _, workers := range []int{1, DefaultVPTreeOptions.Workers} {
+		opts := DefaultVPTreeOptions
+		opts.Workers = workers
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				NewVPTreeWithOptions(metric, items, opts)
+			}
+		})
+	}
+}
+
+func TestVPTreeRadiusFilterIterate(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	vectors := randomVectors(r, 2000, 4)
+	items := make([]int32, len(vectors))
+	var matrix []float32
+	for i, v := range vectors {
+		items[i] = int32(i)
+		matrix = append(matrix, v...)
+	}
+	metric := func(x, y int32) float32 {
+		return euclidean(vectors[x], vectors[y])
+	}
+	tree := NewVPTree(metric, items)
+	rows := NewRowVPTree(matrix, 4, euclidean, nil)
+	const radius = 0.3
+	for q := int32(0); q < 20; q++ {
+		var want []int32
+		for _, id := range exact(vectors, vectors[q], len(vectors)) {
+			if metric(q, id) <= radius {
+				want = append(want, id)
+			}
+		}
+		got, distances := tree.SearchRadius(q, radius)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Item %d within %f has %v, want %v", q, radius, got, want)
+		}
+		if !sort.SliceIsSorted(distances, func(i, j int) bool { return distances[i] < distances[j] }) {
+			t.Fatal("Radius search distances are not sorted")
+		}
+		if ids, _ := rows.SearchRadius(vectors[q], radius); !reflect.DeepEqual(ids, want) {
+			t.Fatalf("Row %d within %f has %v, want %v", q, radius, ids, want)
+		}
+
+		// exclude the query itself and even items
+		filter := func(id int32) bool { return id != q && id%2 == 1 }
+		got, _ = tree.SearchFilter(q, 10, 0, filter)
+		var filtered []int32
+		for _, id := range exact(vectors, vectors[q], len(vectors)) {
+			if filter(id) && len(filtered) < 10 {
+				filtered = append(filtered, id)
+			}
+		}
+		if !reflect.DeepEqual(got, filtered) {
+			t.Fatalf("Filtered neighbours of %d are %v, want %v", q, got, filtered)
+		}
+
+		it := tree.Iterate(q)
+		all := exact(vectors, vectors[q], len(vectors))
+		last := float32(-1)
+		for i := 0; i < 100; i++ {
+			id, d, ok := it.Next()
+			if !ok || d < last || d != metric(q, all[i]) {
+				t.Fatalf("Iterator item %d of %d is %d at %f, want %d at %f", i, q, id, d, all[i], metric(q, all[i]))
+			}
+			last = d
+		}
+	}
+
+	it := NewVPTree(metric, items[:50]).Iterate(0)
+	count := 0
+	for _, _, ok := it.Next(); ok; _, _, ok = it.Next() {
+		count++
+	}
+	if count != 50 {
+		t.Fatalf("Iterator returned %d items, want 50", count)
+	}
+}
+
+// checkVPTree verifies sizes, tombstones and thresholds of subtree n
+func checkVPTree(t *testing.T, n *Node[int32], metric func(x, y int32) float32) (size, tombstones int) {
+	if n == nil {
+		return 0, 0
+	}
+	var walk func(c *Node[int32], visit func(id int32))
+	walk = func(c *Node[int32], visit func(id int32)) {
+		if c != nil {
+			visit(c.Item)
+			walk(c.Left, visit)
+			walk(c.Right, visit)
+		}
+	}
+	walk(n.Left, func(id int32) {
+		if metric(n.Item, id) > n.Threshold {
+			t.Fatalf("Left item %d is farther than threshold %f from %d", id, n.Threshold, n.Item)
+		}
+	})
+	walk(n.Right, func(id int32) {
+		if metric(n.Item, id) < n.Threshold {
+			t.Fatalf("Right item %d is closer than threshold %f to %d", id, n.Threshold, n.Item)
+		}
+	})
+	ls, lt := checkVPTree(t, n.Left, metric)
+	rs, rt := checkVPTree(t, n.Right, metric)
+	size, tombstones = 1+ls+rs, lt+rt
+	if n.Deleted {
+		tombstones++
+	}
+	if n.Size != size || n.Tombstones != tombstones {
+		t.Fatalf("Node %d has size %d and %d tombstones, want %d and %d", n.Item, n.Size, n.Tombstones, size, tombstones)
+	}
+	return
+}
+
+func TestVPTreeInsertDelete(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	vectors := randomVectors(r, 3000, 4)
+	metric := func(x, y int32) float32 {
+		return euclidean(vectors[x], vectors[y])
+	}
+	var items []int32
+	for i := int32(0); i < 500; i++ {
+		items = append(items, i)
+	}
+	tree := NewVPTree(metric, items)
+	live := make(map[int32]bool)
+	for _, id := range items {
+		live[id] = true
+	}
+	next := int32(len(items))
+	for step := 0; step < 4000; step++ {
+		if r.Intn(3) > 0 && int(next) < len(vectors) {
+			tree.Insert(next)
+			live[next] = true
+			next++
+		} else {
+			id := int32(r.Intn(int(next)))
+			if tree.Delete(id, Equal[int32]) != live[id] {
+				t.Fatalf("Delete of %d reported %v", id, !live[id])
+			}
+			delete(live, id)
+		}
+	}
+	checkVPTree(t, tree.root, metric)
+	if tree.Len() != len(live) {
+		t.Fatalf("Len %d, want %d", tree.Len(), len(live))
+	}
+	if len(tree.Items()) != len(live) {
+		t.Fatalf("Items returned %d, want %d", len(tree.Items()), len(live))
+	}
+	// rebuilds keep the tree shallow
+	var depth func(n *Node[int32]) int
+	depth = func(n *Node[int32]) int {
+		if n == nil {
+			return 0
+		}
+		l, r := depth(n.Left), depth(n.Right)
+		if l > r {
+			return l + 1
+		}
+		return r + 1
+	}
+	if d := depth(tree.root); d > 60 {
+		t.Fatalf("Tree depth %d is too large", d)
+	}
+
+	var remaining [][]float32
+	var ids []int32
+	for id := range live {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		remaining = append(remaining, vectors[id])
+	}
+	for q := 0; q < 20; q++ {
+		target := int32(r.Intn(len(vectors)))
+		got, _ := tree.Search(target, 10, 0)
+		for i, j := range exact(remaining, vectors[target], 10) {
+			if got[i] != ids[j] && metric(target, got[i]) != metric(target, ids[j]) {
+				t.Fatalf("Query %d result %d is %d, want %d", target, i, got[i], ids[j])
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if e := tree.Save(&buf); e != nil {
+		t.Fatal(e)
+	}
+	flat, e := LoadVPTree(&buf, metric)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if flat.Len() != len(live) {
+		t.Fatalf("Saved %d rows, want %d", flat.Len(), len(live))
+	}
+}
+
+func TestVPTreeDeleteSlices(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	vectors := randomVectors(r, 200, 4)
+	// a duplicate is at zero distance from the item but is not the item
+	vectors = append(vectors, append([]float32(nil), vectors[5]...))
+	tree := NewVPTree(euclidean, vectors)
+	same := func(x, y []float32) bool { return &x[0] == &y[0] }
+
+	if !tree.Delete(vectors[5], same) || tree.Delete(vectors[5], same) {
+		t.Fatal("Item must be deleted once")
+	}
+	if tree.Len() != len(vectors)-1 {
+		t.Fatalf("Len %d, want %d", tree.Len(), len(vectors)-1)
+	}
+	got, distances := tree.Search(vectors[5], 1, 0)
+	if len(got) != 1 || &got[0][0] != &vectors[200][0] || distances[0] != 0 {
+		t.Fatal("Duplicate of deleted item must be found")
+	}
+	if !tree.Delete(vectors[200], same) || tree.Len() != len(vectors)-2 {
+		t.Fatal("Duplicate must be deleted")
+	}
+}
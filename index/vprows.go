@@ -0,0 +1,79 @@
+package index
+
+import (
+	"fmt"
+	"io"
+)
+
+// RowVPTree is a VP-tree over row ids of a row-major vector matrix, usually
+// the mapped vector section of a model. Items are plain int32 ids and the
+// tree is kept in the flat layout of FlatVPTree, so neither building nor
+// searching boxes items into interfaces.
+type RowVPTree struct {
+	tree     *FlatVPTree
+	matrix   []float32
+	dim      int
+	distance func(x, y []float32) float32
+}
+
+// NewRowVPTree builds a tree over row ids of matrix, a row-major matrix
+// with rows of dim values, all rows are indexed when ids is nil. The matrix
+// is referenced, not copied, and distance must be a true metric for
+// searches to be exact.
+func NewRowVPTree(matrix []float32, dim int, distance func(x, y []float32) float32, ids []int32) *RowVPTree {
+	if dim <= 0 || len(matrix)%dim != 0 {
+		panic(fmt.Errorf("Matrix length %d is not a multiple of dimension %d", len(matrix), dim))
+	}
+	t := &RowVPTree{matrix: matrix, dim: dim, distance: distance}
+	if ids == nil {
+		ids = make([]int32, len(matrix)/dim)
+		for i := range ids {
+			ids[i] = int32(i)
+		}
+	}
+	// int32 items always have row ids, so flatten can not fail
+	nodes, _ := NewVPTree(t.rowDistance, ids).flatten()
+	t.tree = &FlatVPTree{nodes: nodes, metric: t.rowDistance}
+	return t
+}
+
+func (t *RowVPTree) row(id int32) []float32 {
+	return t.matrix[int(id)*t.dim : int(id+1)*t.dim]
+}
+
+func (t *RowVPTree) rowDistance(x, y int32) float32 {
+	return t.distance(t.row(x), t.row(y))
+}
+
+// Len returns number of rows in the tree
+func (t *RowVPTree) Len() int {
+	return t.tree.Len()
+}
+
+// Search returns ids of up to k rows closest to query and the corresponding
+// distances ordered from the closest
+func (t *RowVPTree) Search(query []float32, k int) (ids []int32, distances []float32) {
+	return t.tree.Search(func(id int32) float32 { return t.distance(query, t.row(id)) }, k)
+}
+
+// SearchFilter is Search which returns only rows accepted by filter
+func (t *RowVPTree) SearchFilter(query []float32, k int, filter Filter) (ids []int32, distances []float32) {
+	return t.tree.SearchFilter(func(id int32) float32 { return t.distance(query, t.row(id)) }, k, filter)
+}
+
+// SearchID returns ids of up to k rows closest to row id, the row itself
+// included
+func (t *RowVPTree) SearchID(id int32, k int) (ids []int32, distances []float32) {
+	return t.tree.SearchID(id, k)
+}
+
+// SearchRadius returns ids of all rows within radius of query and the
+// corresponding distances ordered from the closest
+func (t *RowVPTree) SearchRadius(query []float32, radius float32) (ids []int32, distances []float32) {
+	return t.tree.SearchRadius(func(id int32) float32 { return t.distance(query, t.row(id)) }, radius)
+}
+
+// Save writes the tree in the layout of VPTree.Save, MapVPTree loads it
+func (t *RowVPTree) Save(w io.Writer) error {
+	return writeVPNodes(w, t.tree.nodes)
+}
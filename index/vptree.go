@@ -5,50 +5,114 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"runtime"
+	"sort"
 	"strings"
+	"sync/atomic"
 )
 
-//Node VPTree node
-type Node struct {
-	Threshold float32
-	Left      *Node
-	Right     *Node
-	Size      int
-	Item      interface{}
+//Node VPTree node, Size is the number of nodes in its subtree including
+//deleted ones and Tombstones is the number of deleted ones
+type Node[T any] struct {
+	Threshold  float32
+	Left       *Node[T]
+	Right      *Node[T]
+	Size       int
+	Item       T
+	Deleted    bool
+	Tombstones int
 }
 
 // A VPTree struct represents a Vantage-point tree. Vantage-point trees are
 // useful for nearest-neighbour searches in high-dimensional metric spaces.
-type VPTree struct {
-	root           *Node
-	distanceMetric func(x, y interface{}) float32
+// Insert and Delete must not be called concurrently with other methods.
+type VPTree[T any] struct {
+	root           *Node[T]
+	distanceMetric func(x, y T) float32
+	opts           VPTreeOptions
+	random         *rand.Rand
+	slots          chan struct{}
 }
 
-//MetricCalls increases every time metric of two vectors evaluated
-var MetricCalls int
+//MetricCalls increases every time metric of two vectors evaluated, it is
+//updated atomically
+//
+// Deprecated: it is shared by all searches, use SearchWithStats of indexes
+// to count distances of a single search.
+var MetricCalls int64
+
+// VPTreeOptions controls VP-tree construction
+type VPTreeOptions struct {
+	// Workers limits the number of goroutines building subtrees, values
+	// below 2 build serially
+	Workers int
+	// Seed seeds vantage point selection, trees built with the same seed
+	// over the same items are identical whatever the number of workers
+	Seed int64
+	// Candidates is the number of randomly chosen vantage point candidates,
+	// the one whose distances to Samples random items have the largest
+	// variance is taken. Values below 2 take a random item.
+	Candidates int
+	Samples    int
+	// Imbalance makes Insert rebuild a subtree when one of its children
+	// holds more than this fraction of its nodes, zero disables rebuilds
+	Imbalance float32
+	// Tombstones makes Delete rebuild a subtree when more than this
+	// fraction of its nodes is deleted, zero disables rebuilds
+	Tombstones float32
+}
+
+// DefaultVPTreeOptions builds on all CPUs
+var DefaultVPTreeOptions = VPTreeOptions{
+	Workers:    runtime.NumCPU(),
+	Seed:       1,
+	Candidates: 5,
+	Samples:    32,
+	Imbalance:  0.8,
+	Tombstones: 0.5,
+}
+
+// vpRebuildSize is the smallest subtree rebuilt because of imbalance
+const vpRebuildSize = 32
+
+// vpParallelSize is the smallest number of items which subtrees are built
+// concurrently for
+const vpParallelSize = 1024
 
 // NewVPTree creates a new VP-tree using the metric and items provided. The metric
 // measures the distance between two items, so that the VP-tree can find the
-// nearest neighbour(s) of a target item.
-func NewVPTree(metric func(x, y interface{}) float32, items []interface{}) (t *VPTree) {
+// nearest neighbour(s) of a target item. The tree is built with
+// DefaultVPTreeOptions.
+func NewVPTree[T any](metric func(x, y T) float32, items []T) (t *VPTree[T]) {
+	return NewVPTreeWithOptions(metric, items, DefaultVPTreeOptions)
+}
+
+// NewVPTreeWithOptions creates a new VP-tree like NewVPTree built according
+// to opts
+func NewVPTreeWithOptions[T any](metric func(x, y T) float32, items []T, opts VPTreeOptions) (t *VPTree[T]) {
 	// make copy of items to not damage original data
-	t = &VPTree{
+	t = &VPTree[T]{
 		distanceMetric: metric,
+		opts:           opts,
+		random:         rand.New(rand.NewSource(opts.Seed)),
 	}
-	treeItems := make([]interface{}, len(items))
+	treeItems := make([]T, len(items))
 	copy(treeItems, items)
-	t.root = t.buildFromPoints(treeItems)
+	if opts.Workers > 1 {
+		t.slots = make(chan struct{}, opts.Workers-1)
+	}
+	t.root = t.buildFromPoints(treeItems, rand.New(rand.NewSource(t.random.Int63())))
 	return
 }
 
 //PrintTree prints tree structure on the console
-func (vp *VPTree) PrintTree(n *Node, level, maxLevel int) {
+func (vp *VPTree[T]) PrintTree(n *Node[T], level, maxLevel int) {
 	if n == nil {
 		n = vp.root
 		fmt.Printf("Tree:\n")
 	}
 	shift := strings.Repeat(" ", level)
-	fmt.Printf("%sNode: %s Size:%d Level:%d Thr:%0.2f\n", shift, n.Item, n.Size, level, n.Threshold)
+	fmt.Printf("%sNode: %v Size:%d Level:%d Thr:%0.2f\n", shift, n.Item, n.Size, level, n.Threshold)
 
 	if level < maxLevel {
 		level = level + 1
@@ -65,15 +129,18 @@ func (vp *VPTree) PrintTree(n *Node, level, maxLevel int) {
 
 }
 
-func (vp *VPTree) buildFromPoints(items []interface{}) (n *Node) {
+// buildFromPoints builds subtree over items. Subtrees are built with their
+// own random generators seeded from r, so the tree does not depend on which
+// of them are built concurrently.
+func (vp *VPTree[T]) buildFromPoints(items []T, r *rand.Rand) (n *Node[T]) {
 	if len(items) == 0 {
 		return nil
 	}
 
-	n = &Node{}
+	n = &Node[T]{}
 
-	// Take a random item out of the items slice and make it this node's item
-	idx := rand.Intn(len(items))
+	// Take a vantage item out of the items slice and make it this node's item
+	idx := vp.selectVantage(items, r)
 	n.Item = items[idx]
 	n.Size = len(items)
 
@@ -86,12 +153,9 @@ func (vp *VPTree) buildFromPoints(items []interface{}) (n *Node) {
 		// closer to the node's item than the median, and one farther
 		// away.
 		median := len(items) / 2
-		MetricCalls++
 		// distance to random median item
 		pivotDist := vp.distanceMetric(items[median], n.Item)
 
-		//fmt.Printf("Median l:%d %d %s pivotd: %0.2f\n", len(items), median, items[median], pivotDist)
-
 		// put median item to the end of slice and
 		// end item replaces previous median
 		items[median], items[len(items)-1] = items[len(items)-1], items[median]
@@ -99,7 +163,6 @@ func (vp *VPTree) buildFromPoints(items []interface{}) (n *Node) {
 		storeIndex := 0
 		// go thought all items excluding median and now excluding item itself
 		for i := 0; i < len(items)-1; i++ {
-			MetricCalls++
 			if vp.distanceMetric(items[i], n.Item) <= pivotDist {
 				// if some item closer than median to the item itself
 				// then put this item to the starting part of a slice
@@ -108,255 +171,395 @@ func (vp *VPTree) buildFromPoints(items []interface{}) (n *Node) {
 				storeIndex++
 			}
 		}
+		atomic.AddInt64(&MetricCalls, int64(len(items)))
 		// swap median item (which is at the end of slice) and item at the end of closer items list
 		items[len(items)-1], items[storeIndex] = items[storeIndex], items[len(items)-1]
 		// so now median is at storeIndex position of a slice
 		median = storeIndex
-		MetricCalls++
 		// we can reuse threshold
-
 		n.Threshold = pivotDist
-		// save calculations
-		//n.Threshold = vp.distanceMetric(items[median], n.Item)
 
-		n.Left = vp.buildFromPoints(items[:median])
-		n.Right = vp.buildFromPoints(items[median:])
+		left, right := items[:median], items[median:]
+		leftRandom, rightRandom := rand.New(rand.NewSource(r.Int63())), rand.New(rand.NewSource(r.Int63()))
+		if len(items) >= vpParallelSize {
+			select {
+			case vp.slots <- struct{}{}:
+				done := make(chan struct{})
+				go func() {
+					n.Left = vp.buildFromPoints(left, leftRandom)
+					<-vp.slots
+					close(done)
+				}()
+				n.Right = vp.buildFromPoints(right, rightRandom)
+				<-done
+				return
+			default:
+			}
+		}
+		n.Left = vp.buildFromPoints(left, leftRandom)
+		n.Right = vp.buildFromPoints(right, rightRandom)
 	}
 	return
 }
 
-/*
-//ComputeDensity compute density for all points for specific cutoff
-func (vp *VPTree) computeDensity(progress bool, k int, cutoff float32, labelProvider func(id uint32) string) {
-	if k < 1 {
-		return
-	}
-
-	sync := make(chan *PriorityQueue, runtime.NumCPU())
-	for i := 0; i < runtime.NumCPU(); i++ {
-		h := make(PriorityQueue, 0, k)
-		sync <- &h
+// selectVantage returns index of the candidate item with the largest
+// variance of distances to sampled items, distant items spread the rest
+// more evenly between subtrees than random ones
+func (vp *VPTree[T]) selectVantage(items []T, r *rand.Rand) int {
+	opts := &vp.opts
+	if opts.Candidates < 2 || opts.Samples < 1 || len(items) <= opts.Samples {
+		return r.Intn(len(items))
 	}
-
-	for _, n := range vp.ID2node {
-
-		go func(n *Node, sync chan *PriorityQueue, pq *PriorityQueue) {
-			tau := cutoff
-			vp.search(vp.root, n.Item, k, pq, &tau)
-			//println(len(*pq))
-			for len(*pq) > 1 { // except itself is for 1
-				//hi :=
-				(*pq).Pop()
-				//n.Item.SetDensity(n.Item.Density() + 1 + 1/(1+hi.Dist))
-				//if vp.distanceMetric == P.CosineMetric {
-				//	n.Item.Density += 1 + (1 - hi.Dist) // max distance is 1!!!!!!!
-			}
-
-			*pq = (*pq)[:0] // clear priority queue
-			sync <- pq
-		}(n, sync, <-sync)
-	}
-	for i := 0; i < runtime.NumCPU(); i++ {
-		<-sync
+	best, bestSpread := 0, -1.0
+	for c := 0; c < opts.Candidates; c++ {
+		candidate := r.Intn(len(items))
+		var sum, sumSquares float64
+		for s := 0; s < opts.Samples; s++ {
+			d := float64(vp.distanceMetric(items[candidate], items[r.Intn(len(items))]))
+			sum += d
+			sumSquares += d * d
+		}
+		mean := sum / float64(opts.Samples)
+		if spread := sumSquares/float64(opts.Samples) - mean*mean; spread > bestSpread {
+			best, bestSpread = candidate, spread
+		}
 	}
-
+	atomic.AddInt64(&MetricCalls, int64(opts.Candidates*opts.Samples))
+	return best
 }
-*/
+
 // Search searches the VP-tree for the k nearest neighbours of target. It
 // returns the up to k narest neighbours and the corresponding distances in
 // order of least distance to largest distance.
-func (vp *VPTree) Search(target interface{}, k int, cutoff float32) (results []interface{}, distances []float32) {
-	if k < 1 {
+func (vp *VPTree[T]) Search(target T, k int, cutoff float32) (results []T, distances []float32) {
+	return vp.SearchFilter(target, k, cutoff, nil)
+}
+
+// SearchFilter searches like Search but skips items for which filter
+// returns false, they are not counted in k. A nil filter accepts every item,
+// a filter rejecting target itself excludes it from its own neighbours.
+func (vp *VPTree[T]) SearchFilter(target T, k int, cutoff float32, filter func(item T) bool) (results []T, distances []float32) {
+	results, distances, _ = vp.searchCount(target, k, cutoff, filter)
+	return
+}
+
+// searchCount is SearchFilter which also returns the number of computed
+// distances
+func (vp *VPTree[T]) searchCount(target T, k int, cutoff float32, filter func(item T) bool) (results []T, distances []float32, calls int) {
+	if k < 1 || vp.root == nil {
 		return
 	}
 
-	h := make(PriorityQueue, 0, k)
+	var h vpCandidates[T]
 	var tau float32 = math.MaxFloat32
 	if cutoff > 0 {
 		tau = cutoff
 	}
-	// we search k + 1 because we will exclude item itself from search result
-	vp.search(vp.root, target, k, &h, &tau)
-
-	for len(h) > 0 {
-		hi := heap.Pop(&h).(*HeapItem)
-		results = append(results, hi.Item)
-		distances = append(distances, hi.Dist)
-	}
-
-	// Reverse results and distances, because we popped them from the heap
-	// in large-to-small order
-	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
-		results[i], results[j] = results[j], results[i]
-		distances[i], distances[j] = distances[j], distances[i]
+	vp.search(vp.root, target, k, filter, &h, &tau, &calls)
+	atomic.AddInt64(&MetricCalls, int64(calls))
+
+	// the heap pops the farthest first
+	results = make([]T, h.Len())
+	distances = make([]float32, h.Len())
+	for i := len(results) - 1; i >= 0; i-- {
+		entry := heap.Pop(&h).(vpEntry[T])
+		results[i], distances[i] = entry.node.Item, entry.distance
 	}
-
 	return
 }
 
-func (vp *VPTree) search(n *Node, target interface{}, k int, h *PriorityQueue, tau *float32) {
-	MetricCalls++
+// vpCandidates holds the nearest items found by Search, the farthest on top
+type vpCandidates[T any] []vpEntry[T]
+
+func (q vpCandidates[T]) Len() int            { return len(q) }
+func (q vpCandidates[T]) Less(i, j int) bool  { return q[i].distance > q[j].distance }
+func (q vpCandidates[T]) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *vpCandidates[T]) Push(x interface{}) { *q = append(*q, x.(vpEntry[T])) }
+func (q *vpCandidates[T]) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+func (vp *VPTree[T]) search(n *Node[T], target T, k int, filter func(item T) bool, h *vpCandidates[T], tau *float32, calls *int) {
+	*calls++
 
 	d := vp.distanceMetric(n.Item, target)
-	if d < *tau {
-		if len(*h) == k {
-			heap.Pop(h)
+	if d < *tau && !n.Deleted && (filter == nil || filter(n.Item)) {
+		entry := vpEntry[T]{node: n, item: true, distance: d}
+		if h.Len() == k {
+			(*h)[0] = entry
+			heap.Fix(h, 0)
+		} else {
+			heap.Push(h, entry)
 		}
-		heap.Push(h, &HeapItem{n.Item, d, 0})
-		if len(*h) == k {
-			*tau = h.Top().Dist
+		if h.Len() == k {
+			*tau = (*h)[0].distance
 		}
 	}
 
-	//fmt.Printf("%s %s %f \n", n.Item, target, d)
 	if d < n.Threshold {
 		if d-*tau <= n.Threshold && n.Left != nil {
-			//fmt.Printf("11 d:%0.2f th:%0.2f tau:%0.2f domain:%s\n", d, n.Threshold, *tau, n.Item)
-			vp.search(n.Left, target, k, h, tau)
+			vp.search(n.Left, target, k, filter, h, tau, calls)
 		}
 
 		if d+*tau >= n.Threshold && n.Right != nil {
-			//fmt.Printf("12 d:%0.2f th:%0.2f tau:%0.2f domain:%s\n", d, n.Threshold, *tau, n.Item)
-			vp.search(n.Right, target, k, h, tau)
+			vp.search(n.Right, target, k, filter, h, tau, calls)
 		}
 	} else {
 		if d+*tau >= n.Threshold && n.Right != nil {
-			//fmt.Printf("21 d:%0.2f th:%0.2f tau:%0.2f domain:%s\n", d, n.Threshold, *tau, n.Item)
-			vp.search(n.Right, target, k, h, tau)
+			vp.search(n.Right, target, k, filter, h, tau, calls)
 		}
 
 		if d-*tau <= n.Threshold && n.Left != nil {
-			//fmt.Printf("22 d:%0.2f th:%0.2f tau:%0.2f domain:%s\n", d, n.Threshold, *tau, n.Item)
-			vp.search(n.Left, target, k, h, tau)
+			vp.search(n.Left, target, k, filter, h, tau, calls)
 		}
 	}
 }
 
-/*
-//ComputeDelta compute delta for all points for specific cutoff
-func (vp *VPTree) computeDelta(progress bool, k int, cutoff float32, labelProvider func(id uint32) string) {
-	if k < 1 {
+// SearchRadius returns all items within radius of target, however many
+// there are, and the corresponding distances in order of least distance to
+// largest distance
+func (vp *VPTree[T]) SearchRadius(target T, radius float32) (results []T, distances []float32) {
+	return vp.SearchRadiusFilter(target, radius, nil)
+}
+
+// SearchRadiusFilter searches like SearchRadius but skips items for which
+// filter returns false
+func (vp *VPTree[T]) SearchRadiusFilter(target T, radius float32, filter func(item T) bool) (results []T, distances []float32) {
+	if vp.root == nil || radius < 0 {
 		return
 	}
+	var hits vpHits[T]
+	vp.searchRadius(vp.root, target, radius, filter, &hits)
+	sort.Sort(&hits)
+	return hits.items, hits.distances
+}
+
+// vpHits are items found by SearchRadius, sorted by distance
+type vpHits[T any] struct {
+	items     []T
+	distances []float32
+}
 
-	sync := make(chan *PriorityQueue, runtime.NumCPU())
-	for i := 0; i < runtime.NumCPU(); i++ {
-		h := make(PriorityQueue, 0, k)
-		sync <- &h
+func (h *vpHits[T]) Len() int           { return len(h.items) }
+func (h *vpHits[T]) Less(i, j int) bool { return h.distances[i] < h.distances[j] }
+func (h *vpHits[T]) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.distances[i], h.distances[j] = h.distances[j], h.distances[i]
+}
+
+func (vp *VPTree[T]) searchRadius(n *Node[T], target T, radius float32, filter func(item T) bool, hits *vpHits[T]) {
+	atomic.AddInt64(&MetricCalls, 1)
+	d := vp.distanceMetric(n.Item, target)
+	if d <= radius && !n.Deleted && (filter == nil || filter(n.Item)) {
+		hits.items = append(hits.items, n.Item)
+		hits.distances = append(hits.distances, d)
+	}
+	// left items are not farther than threshold from the vantage point and
+	// right ones are not closer
+	if d-radius <= n.Threshold && n.Left != nil {
+		vp.searchRadius(n.Left, target, radius, filter, hits)
+	}
+	if d+radius >= n.Threshold && n.Right != nil {
+		vp.searchRadius(n.Right, target, radius, filter, hits)
 	}
+}
 
-	for _, n := range vp.ID2node {
+// vpEntry is a queued node of VPIterator or Search, with item set its
+// distance is exact, otherwise it is a lower bound of distances to items of
+// the subtree
+type vpEntry[T any] struct {
+	node     *Node[T]
+	item     bool
+	distance float32
+}
 
-		//if n.Item.Density > 0 && n.Item.Delta == -1 {
-		go func(n *Node, sync chan *PriorityQueue, pq *PriorityQueue) {
-			tau := cutoff
-			vp.search(vp.root, n.Item, k, pq, &tau)
+type vpQueue[T any] []vpEntry[T]
+
+func (q vpQueue[T]) Len() int            { return len(q) }
+func (q vpQueue[T]) Less(i, j int) bool  { return q[i].distance < q[j].distance }
+func (q vpQueue[T]) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *vpQueue[T]) Push(x interface{}) { *q = append(*q, x.(vpEntry[T])) }
+func (q *vpQueue[T]) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
 
-			for len(*pq) > 1 {
-				//hi :=
+// VPIterator streams items of a VP-tree in order of increasing distance to a
+// target, computing only distances needed for the next item
+type VPIterator[T any] struct {
+	tree   *VPTree[T]
+	target T
+	queue  vpQueue[T]
+}
 
-				(*pq).Pop()
-				//println(hi.Item.Density, n.Item.Density)
-				//if hi.Item.Density() > n.Item.Density() {
-				//	n.Item.SetDelta(hi.Dist)
-				//	n.Item.SetNearest(hi.Item.ID())
-				//	break
-				//}
-			}
+// Iterate returns iterator over all items in order of increasing distance
+// to target
+func (vp *VPTree[T]) Iterate(target T) *VPIterator[T] {
+	it := &VPIterator[T]{tree: vp, target: target}
+	if vp.root != nil {
+		it.queue = vpQueue[T]{{node: vp.root}}
+	}
+	return it
+}
 
-			*pq = (*pq)[:0] // clear priority queue
-			//if n.Item.Density > 1 {
-			//	fmt.Println(n.Item.Density, n.Item.Delta, n.Item.Nearest, labelProvider(n.Item.ID))
-			//}
-			sync <- pq
-		}(n, sync, <-sync)
-		//}
-	}
-	for i := 0; i < runtime.NumCPU(); i++ {
-		<-sync
-	}
-
-}
-*/
-/*
-//Assign assign point to cluster
-func (vp *VPTree) assign(p P.Point, cutoff float32) uint32 {
-	switch {
-	case p.Cluster() != math.MaxUint32: // point already assigned to cluster
-		return p.Cluster()
-	case p.Density() == 0: // point is singleton
-		return math.MaxUint32
-	case p.Delta() >= cutoff: // this point is GROSS-CENTROID
-		// mark all points under this point as part of a cluster within cutoff
-		//fmt.Println("Centroid!", p.Density, p.ID)
-		p.SetCluster(p.ID())
-		suzerens, _ := vp.Search(p, 10000, cutoff)
-		for _, suzeren := range suzerens {
-			suzeren.SetCluster(p.ID())
+// Next returns the next closest item and its distance, ok is false when all
+// items are returned
+func (it *VPIterator[T]) Next() (item T, distance float32, ok bool) {
+	for len(it.queue) > 0 {
+		entry := heap.Pop(&it.queue).(vpEntry[T])
+		if entry.item {
+			return entry.node.Item, entry.distance, true
+		}
+		n := entry.node
+		atomic.AddInt64(&MetricCalls, 1)
+		d := it.tree.distanceMetric(n.Item, it.target)
+		if !n.Deleted {
+			heap.Push(&it.queue, vpEntry[T]{node: n, item: true, distance: d})
 		}
-		return p.Cluster()
-	case p.Delta() < cutoff: // point is part of a cluster
-		//println(p.Delta(), cutoff, p.Nearest)
-		p.SetCluster(vp.assign(vp.ID2node[p.Nearest()].Item, cutoff))
-		return p.Cluster()
-
-	/*case p.Density > 0 && p.Delta < cutoff: // point is part of a cluster
-		p.Cluster = vp.assign(vp.ID2node[p.Nearest].Item, cutoff)
-		return p.Cluster
-	case p.Density > 0 && p.Delta > cutoff: // this is a small cluster
-		// mark all points under this point as part of a cluster within cutoff
-		p.Cluster = p.ID
-		suzerens, _ := vp.Search(p, int(p.Density)+1, cutoff)
-		for _, suzeren := range suzerens {
-			suzeren.Cluster = p.ID
+		if n.Left != nil {
+			heap.Push(&it.queue, vpEntry[T]{node: n.Left, distance: maxFloat32(entry.distance, d-n.Threshold)})
+		}
+		if n.Right != nil {
+			heap.Push(&it.queue, vpEntry[T]{node: n.Right, distance: maxFloat32(entry.distance, n.Threshold-d)})
 		}
-		return p.Cluster*/
-/*default:
-		fmt.Println("PANICEBT")
-		panic("HM!!!!")
 	}
+	return
 }
 
-//ComputeClusters compute delta for all points for specific cutoff
-func (vp *VPTree) computeClusters(progress bool, k int, cutoff float32, labelProvider func(id uint32) string) {
-
-	bar := pb.New(len(vp.ID2node))
-	bar.SetRefreshRate(time.Second)
-	bar.ShowTimeLeft = true
-	bar.ShowSpeed = true
-	if progress {
-		bar.Start()
+// Len returns number of items not deleted
+func (vp *VPTree[T]) Len() int {
+	if vp.root == nil {
+		return 0
 	}
-	for _, n := range vp.ID2node {
-		bar.Increment()
-		vp.assign(n.Item, cutoff)
+	return vp.root.Size - vp.root.Tombstones
+}
+
+// Items returns all items not deleted
+func (vp *VPTree[T]) Items() []T {
+	items := make([]T, 0, vp.Len())
+	return vp.collect(vp.root, items)
+}
+
+func (vp *VPTree[T]) collect(n *Node[T], items []T) []T {
+	if n == nil {
+		return items
 	}
-	if progress {
-		bar.FinishPrint("Cluster computation finished!")
+	if !n.Deleted {
+		items = append(items, n.Item)
 	}
+	items = vp.collect(n.Left, items)
+	return vp.collect(n.Right, items)
+}
+
+// rebuild builds subtree n anew over its items which are not deleted and
+// extra items, it returns the new subtree and the number of dropped nodes
+func (vp *VPTree[T]) rebuild(n *Node[T], extra ...T) (*Node[T], int) {
+	items := make([]T, 0, n.Size-n.Tombstones+len(extra))
+	items = append(vp.collect(n, items), extra...)
+	return vp.buildFromPoints(items, rand.New(rand.NewSource(vp.random.Int63()))), n.Tombstones
+}
+
+// Insert adds item to the tree. It descends like a search and becomes a
+// leaf, the topmost subtree on the way which gets unbalanced by more than
+// Imbalance is rebuilt.
+func (vp *VPTree[T]) Insert(item T) {
+	vp.root, _ = vp.insert(vp.root, item)
 }
 
-//Clusterize - partition points into clusters
-func Clusterize(progress bool, points []P.Point, dc float32, metric P.Metric) (clusters map[uint32][]P.Point) {
-	for _, p := range points {
-		p.SetDelta(float32(math.MaxUint32))
-		p.SetNearest(math.MaxUint32)
-		p.SetCluster(math.MaxUint32)
-		p.SetDensity(0)
+// insert returns the new subtree and the number of deleted nodes dropped by
+// rebuilds
+func (vp *VPTree[T]) insert(n *Node[T], item T) (*Node[T], int) {
+	if n == nil {
+		return &Node[T]{Item: item, Size: 1}, 0
+	}
+	atomic.AddInt64(&MetricCalls, 1)
+	d := vp.distanceMetric(item, n.Item)
+	if n.Left == nil && n.Right == nil {
+		// a leaf has no children to keep consistent with its threshold
+		n.Threshold = d
+	}
+	left := d <= n.Threshold
+	child := n.Right
+	if left {
+		child = n.Left
 	}
+	childSize := 1
+	if child != nil {
+		childSize += child.Size
+	}
+	if vp.opts.Imbalance > 0 && n.Size+1 >= vpRebuildSize && float32(childSize) > vp.opts.Imbalance*float32(n.Size+1) {
+		return vp.rebuild(n, item)
+	}
+
+	var dropped int
+	if left {
+		n.Left, dropped = vp.insert(n.Left, item)
+	} else {
+		n.Right, dropped = vp.insert(n.Right, item)
+	}
+	n.Size += 1 - dropped
+	n.Tombstones -= dropped
+	return n, dropped
+}
+
+// Equal reports whether comparable items x and y are equal, it is the
+// equality Delete takes for such items
+func Equal[T comparable](x, y T) bool {
+	return x == y
+}
 
-	index := NewVPTree(metric, points)
-	index.computeDensity(progress, len(points), dc, nil)
-	index.computeDelta(progress, len(points), dc, nil)
-	index.computeClusters(progress, len(points), dc, nil)
+// Delete marks the item equal to item by equal deleted and reports whether
+// it was found. The topmost subtree on the way where more than Tombstones
+// of nodes are deleted is rebuilt without them.
+func (vp *VPTree[T]) Delete(item T, equal func(x, y T) bool) bool {
+	var found bool
+	vp.root, found, _ = vp.delete(vp.root, item, equal)
+	return found
+}
 
-	clusters = make(map[uint32][]P.Point)
-	// collect clusters
-	for _, p := range points {
-		if p.Cluster() != math.MaxUint32 {
-			clusters[p.Cluster()] = append(clusters[p.Cluster()], p)
+// delete returns the new subtree, whether item was found and the number of
+// deleted nodes dropped by rebuilds
+func (vp *VPTree[T]) delete(n *Node[T], item T, equal func(x, y T) bool) (*Node[T], bool, int) {
+	if n == nil {
+		return nil, false, 0
+	}
+	var found bool
+	var dropped int
+	if !n.Deleted && equal(n.Item, item) {
+		n.Deleted = true
+		found = true
+	} else {
+		atomic.AddInt64(&MetricCalls, 1)
+		d := vp.distanceMetric(n.Item, item)
+		// left items are not farther than threshold from the vantage point
+		// and right ones are not closer
+		if d <= n.Threshold {
+			n.Left, found, dropped = vp.delete(n.Left, item, equal)
+		}
+		if !found && d >= n.Threshold {
+			n.Right, found, dropped = vp.delete(n.Right, item, equal)
 		}
 	}
-	return
+	if !found {
+		return n, false, 0
+	}
+	n.Size -= dropped
+	n.Tombstones += 1 - dropped
+	if vp.opts.Tombstones > 0 && float32(n.Tombstones) > vp.opts.Tombstones*float32(n.Size) {
+		n, rebuilt := vp.rebuild(n)
+		return n, true, dropped + rebuilt
+	}
+	return n, true, dropped
+}
+
+func maxFloat32(x, y float32) float32 {
+	if x > y {
+		return x
+	}
+	return y
 }
-*/
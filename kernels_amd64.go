@@ -0,0 +1,46 @@
+//go:build !purego
+
+package govector
+
+import "golang.org/x/sys/cpu"
+
+// useAVX2 selects the AVX2/FMA code path of the assembly kernels, it is
+// checked on every call so tests can force the SSE path.
+var useAVX2 = cpu.X86.HasAVX && cpu.X86.HasAVX2 && cpu.X86.HasFMA
+
+// Sdot Scalar product: X^T Y
+func Sdot(X, Y []float32) float32
+
+// Sxpy Vector sum: Y += X
+func Sxpy(X, Y []float32)
+
+// Saxpy Scaled vector sum: Y += alpha * X
+func Saxpy(alpha float32, X, Y []float32)
+
+// Sscale Vector scale: X *= alpha
+func Sscale(alpha float32, X []float32)
+
+// L2 Euclidean norm: ||X||
+func L2(X []float32) float32
+
+// Ssqdist Squared Euclidean distance: ||X - Y||^2
+func Ssqdist(X, Y []float32) float32
+
+// sdot4AVX2 sets out to dot products of four queries, which start stride
+// values apart in q, with the first len(y) &^ 7 values of y. It needs AVX2.
+//
+//go:noescape
+func sdot4AVX2(q []float32, stride int, y []float32, out *[4]float32)
+
+// sdot4 sets out to dot products of four queries, which start stride values
+// apart in q, with y
+func sdot4(q []float32, stride int, y []float32, out *[4]float32) {
+	n := len(y) &^ 7
+	if !useAVX2 || n == 0 {
+		*out = [4]float32{}
+		sdot4Go(q, stride, y, 0, out)
+		return
+	}
+	sdot4AVX2(q, stride, y, out)
+	sdot4Go(q, stride, y, n, out)
+}
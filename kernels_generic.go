@@ -0,0 +1,43 @@
+//go:build !amd64 || purego
+
+package govector
+
+// useAVX2 is never set when the assembly kernels are not built.
+var useAVX2 = false
+
+// Sdot Scalar product: X^T Y
+func Sdot(X, Y []float32) float32 {
+	return sdot(X, Y)
+}
+
+// Sxpy Vector sum: Y += X
+func Sxpy(X, Y []float32) {
+	sxpy(X, Y)
+}
+
+// Saxpy Scaled vector sum: Y += alpha * X
+func Saxpy(alpha float32, X, Y []float32) {
+	saxpy(alpha, X, Y)
+}
+
+// Sscale Vector scale: X *= alpha
+func Sscale(alpha float32, X []float32) {
+	sscale(alpha, X)
+}
+
+// L2 Euclidean norm: ||X||
+func L2(X []float32) float32 {
+	return l2(X)
+}
+
+// Ssqdist Squared Euclidean distance: ||X - Y||^2
+func Ssqdist(X, Y []float32) float32 {
+	return ssqdist(X, Y)
+}
+
+// sdot4 sets out to dot products of four queries, which start stride values
+// apart in q, with y
+func sdot4(q []float32, stride int, y []float32, out *[4]float32) {
+	*out = [4]float32{}
+	sdot4Go(q, stride, y, 0, out)
+}
@@ -0,0 +1,147 @@
+package govector
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// kernelPaths runs f once for every code path the assembly kernels can take
+// on this machine.
+func kernelPaths(t *testing.T, f func(t *testing.T)) {
+	saved := useAVX2
+	defer func() { useAVX2 = saved }()
+
+	useAVX2 = false
+	t.Run("noavx2", f)
+	if saved {
+		useAVX2 = true
+		t.Run("avx2", f)
+	}
+}
+
+func randomVector(r *rand.Rand, n int) []float32 {
+	v := make([]float32, n)
+	for i := range v {
+		v[i] = r.Float32()*2 - 1
+	}
+	return v
+}
+
+func close32(x, y float32, n int) bool {
+	// summation order differs between kernels, allow error growing with n
+	tolerance := 1e-5 * float64(n+1)
+	return math.Abs(float64(x-y)) <= tolerance*math.Max(1, math.Abs(float64(y)))
+}
+
+func TestKernels(t *testing.T) {
+	kernelPaths(t, func(t *testing.T) {
+		r := rand.New(rand.NewSource(1))
+		for n := 0; n <= 70; n++ {
+			x, y := randomVector(r, n), randomVector(r, n)
+
+			if got, want := Sdot(x, y), sdot(x, y); !close32(got, want, n) {
+				t.Errorf("Sdot n=%d want %f got %f", n, want, got)
+			}
+			if got, want := L2(x), l2(x); !close32(got, want, n) {
+				t.Errorf("L2 n=%d want %f got %f", n, want, got)
+			}
+			if got, want := Ssqdist(x, y), ssqdist(x, y); !close32(got, want, n) {
+				t.Errorf("Ssqdist n=%d want %f got %f", n, want, got)
+			}
+
+			got, want := append([]float32(nil), y...), append([]float32(nil), y...)
+			Sxpy(x, got)
+			sxpy(x, want)
+			for i := range want {
+				if !close32(got[i], want[i], 1) {
+					t.Fatalf("Sxpy n=%d i=%d want %f got %f", n, i, want[i], got[i])
+				}
+			}
+
+			copy(got, y)
+			copy(want, y)
+			Saxpy(0.3, x, got)
+			saxpy(0.3, x, want)
+			for i := range want {
+				if !close32(got[i], want[i], 1) {
+					t.Fatalf("Saxpy n=%d i=%d want %f got %f", n, i, want[i], got[i])
+				}
+			}
+
+			copy(got, x)
+			copy(want, x)
+			Sscale(-1.7, got)
+			sscale(-1.7, want)
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("Sscale n=%d i=%d want %f got %f", n, i, want[i], got[i])
+				}
+			}
+		}
+	})
+}
+
+func TestKernelsDoNotOverrun(t *testing.T) {
+	kernelPaths(t, func(t *testing.T) {
+		// kernels must touch only len(X) elements even when Y is longer
+		x := []float32{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+		y := make([]float32, len(x)+8)
+		for i := range y {
+			y[i] = 2
+		}
+		Sxpy(x, y)
+		Saxpy(2, x, y)
+		Sscale(3, y[:len(x)])
+		for i := range y {
+			want := float32(2)
+			if i < len(x) {
+				want = 15
+			}
+			if y[i] != want {
+				t.Fatalf("y[%d] want %f got %f", i, want, y[i])
+			}
+		}
+	})
+}
+
+func benchmarkKernel(b *testing.B, avx2 bool, kernel func(x, y []float32)) {
+	if avx2 && !useAVX2 {
+		b.Skip("AVX2/FMA is not supported")
+	}
+	saved := useAVX2
+	defer func() { useAVX2 = saved }()
+	useAVX2 = avx2
+
+	r := rand.New(rand.NewSource(1))
+	x, y := randomVector(r, 128), randomVector(r, 128)
+	b.SetBytes(2 * 4 * 128)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kernel(x, y)
+	}
+}
+
+func BenchmarkSdotSSE(b *testing.B) {
+	benchmarkKernel(b, false, func(x, y []float32) { Sdot(x, y) })
+}
+
+func BenchmarkSdotAVX2(b *testing.B) {
+	benchmarkKernel(b, true, func(x, y []float32) { Sdot(x, y) })
+}
+
+func BenchmarkSsqdistSSE(b *testing.B) {
+	benchmarkKernel(b, false, func(x, y []float32) { Ssqdist(x, y) })
+}
+
+func BenchmarkSsqdistAVX2(b *testing.B) {
+	benchmarkKernel(b, true, func(x, y []float32) { Ssqdist(x, y) })
+}
+
+func BenchmarkSaxpySSE(b *testing.B) {
+	benchmarkKernel(b, false, func(x, y []float32) { Saxpy(0.5, x, y) })
+}
+
+func BenchmarkSaxpyAVX2(b *testing.B) {
+	benchmarkKernel(b, true, func(x, y []float32) { Saxpy(0.5, x, y) })
+}
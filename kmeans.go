@@ -0,0 +1,12 @@
+package govector
+
+import "github.com/vseledkin/govector/index"
+
+// KMeans clusters stored vectors of rows ids with SIMD kernels, Assignments
+// of the result follow ids. Spherical k-means suits word vectors, which are
+// compared by angle.
+func (m *Manifold) KMeans(ids []int32, opts index.KMeansOptions) (*index.KMeans, error) {
+	return index.TrainKMeans(len(ids), m.Dim(), func(i int) []float32 {
+		return m.Row(ids[i])
+	}, opts, SIMDKernels)
+}
@@ -2,7 +2,7 @@ package govector
 
 import "math"
 
-func L2(X []float32) (nrm2 float32) {
+func l2(X []float32) (nrm2 float32) {
 	for _, x := range X {
 		nrm2 += x * x
 	}
@@ -1,50 +1,116 @@
-//func L2(X []float32)
-TEXT ·L2(SB), 7, $0
-	MOVQ	X_data+0(FP), SI
-	MOVQ	X_len+8(FP), BP
+//go:build !purego
+
+#include "textflag.h"
+
+// func L2(X []float32) float32
+TEXT ·L2(SB), NOSPLIT, $0-28
+	MOVQ	X_base+0(FP), SI
+	MOVQ	X_len+8(FP), CX
+
+	CMPB	·useAVX2(SB), $1
+	JE	avx2
 
 	// Clear accumulator
 	XORPS	X0, X0
-	SUBQ	$4, BP
-	JL		rest	// There are less than 4 pairs to process
-
-	simd_loop:
-		// Load four pairs and scale
-		MOVUPS	(SI), X1
-		MULPS	X1, X1
-		// Save sum
-		ADDPS	X1, X0
-		// Update data pointers
-		ADDQ	$16, SI
-
-		SUBQ	$4, BP
-		JGE		simd_loop	// There are 4 or more pairs to process
-	JMP	rest
+	SUBQ	$4, CX
+	JL	rest	// There are less than 4 values to process
+
+simd_loop:
+	// Load four values and square
+	MOVUPS	(SI), X1
+	MULPS	X1, X1
+	// Accumulate
+	ADDPS	X1, X0
+	// Update data pointers
+	ADDQ	$16, SI
+
+	SUBQ	$4, CX
+	JGE	simd_loop	// There are 4 or more values to process
 
 rest:
 	// Horizontal sum
-	MOVHLPS X0, X1
+	MOVHLPS	X0, X1
 	ADDPS	X0, X1
 	MOVSS	X1, X0
 	SHUFPS	$0xe1, X1, X1
 	ADDSS	X1, X0
 	// Undo last SUBQ
-	ADDQ	$4,	BP
+	ADDQ	$4, CX
 	// Check that are there any value to process
 	JE	end
-	loop:
-		// Load from X and scale
-		MOVSS	(SI), X1
-		MULSS	X1, X1
-		// Save sum in Y
-		ADDSS	X1, X0
-
-		// Update data pointers
-		ADDQ	$4, SI
-
-		DECQ	BP
-		JNE	loop
+
+loop:
+	// Load from X and square
+	MOVSS	(SI), X1
+	MULSS	X1, X1
+	// Accumulate
+	ADDSS	X1, X0
+
+	// Update data pointers
+	ADDQ	$4, SI
+
+	DECQ	CX
+	JNE	loop
+
 end:
 	SQRTSS	X0, X0
-	MOVSS	X0, r+24(FP)
+	MOVSS	X0, ret+24(FP)
+	RET
+
+avx2:
+	// Two independent accumulators hide FMA latency
+	VXORPS	Y0, Y0, Y0
+	VXORPS	Y1, Y1, Y1
+
+	SUBQ	$16, CX
+	JL	avx2_tail8	// There are less than 16 values to process
+
+avx2_loop:
+	VMOVUPS	(SI), Y2
+	VMOVUPS	32(SI), Y3
+	VFMADD231PS	Y2, Y2, Y0
+	VFMADD231PS	Y3, Y3, Y1
+
+	ADDQ	$64, SI
+
+	SUBQ	$16, CX
+	JGE	avx2_loop
+
+avx2_tail8:
+	ADDQ	$16, CX
+	CMPQ	CX, $8
+	JL	avx2_reduce	// There are less than 8 values left
+
+	VMOVUPS	(SI), Y2
+	VFMADD231PS	Y2, Y2, Y0
+
+	ADDQ	$32, SI
+	SUBQ	$8, CX
+
+avx2_reduce:
+	// Horizontal sum of eight lanes
+	VADDPS	Y1, Y0, Y0
+	VEXTRACTF128	$1, Y0, X1
+	VADDPS	X1, X0, X0
+	VMOVHLPS	X0, X0, X1
+	VADDPS	X1, X0, X0
+	VMOVSHDUP	X0, X1
+	VADDSS	X1, X0, X0
+
+	TESTQ	CX, CX
+	JE	avx2_end
+
+avx2_loop1:
+	VMOVSS	(SI), X1
+	VFMADD231SS	X1, X1, X0
+
+	ADDQ	$4, SI
+
+	DECQ	CX
+	JNE	avx2_loop1
+
+avx2_end:
+	VZEROUPPER
+	SQRTSS	X0, X0
+	MOVSS	X0, ret+24(FP)
 	RET
@@ -0,0 +1,59 @@
+package govector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// indexMetaMagic marks metadata files of saved indexes, "GIM1"
+const indexMetaMagic = 0x314d4947
+
+// indexMeta is stored next to a saved index in a file with ".meta" suffix,
+// it ties the index to the model and parameters it was built with. Index
+// file formats have no room for it.
+type indexMeta struct {
+	Magic    uint32
+	Checksum uint32
+	Dim      uint32
+	Words    uint32
+	Items    uint32
+	// Trees of Annoy index
+	Trees uint32
+	// Lists and Subquantizers of IVF index
+	Lists         uint32
+	Subquantizers uint32
+}
+
+func readIndexMeta(path string) (meta indexMeta, e error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return
+	}
+	defer f.Close()
+	if e = binary.Read(f, binary.LittleEndian, &meta); e != nil {
+		return
+	}
+	if meta.Magic != indexMetaMagic {
+		e = fmt.Errorf("%s is not index metadata", path)
+	}
+	return
+}
+
+func writeIndexMeta(path string, meta indexMeta) error {
+	f, e := os.Create(path)
+	if e != nil {
+		return e
+	}
+	meta.Magic = indexMetaMagic
+	if e = binary.Write(f, binary.LittleEndian, meta); e != nil {
+		f.Close()
+		return e
+	}
+	return f.Close()
+}
+
+// builtFrom reports whether the index was built from model m of checksum
+func (meta indexMeta) builtFrom(m *Manifold, checksum uint32) bool {
+	return meta.Checksum == checksum && meta.Dim == uint32(m.Dim()) && meta.Words == m.WordCount()
+}
@@ -0,0 +1,159 @@
+package govector
+
+import (
+	"os"
+	"testing"
+)
+
+// modTime returns modification time of path in nanoseconds
+func modTime(t *testing.T, path string) int64 {
+	stat, e := os.Stat(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return stat.ModTime().UnixNano()
+}
+
+// replaceModel overwrites model file path with a model of the same words
+// and other vectors
+func replaceModel(t *testing.T, path string, words []string, seed int64) {
+	data, e := os.ReadFile(writeModel(t, words, seed))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := os.WriteFile(path, data, 0644); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestIVFIndexMeta(t *testing.T) {
+	var words []string
+	for i := 0; i < 300; i++ {
+		words = append(words, string(rune('a'+i%26))+string(rune('a'+i/26)))
+	}
+	path := writeModel(t, words, 1)
+	m := openModel(t, path)
+	if _, e := m.IVFIndex(4, 0); e != nil {
+		t.Fatal(e)
+	}
+	built := modTime(t, path+".ivf")
+
+	if _, e := m.IVFIndex(4, 0); e != nil {
+		t.Fatal(e)
+	}
+	if modTime(t, path+".ivf") != built {
+		t.Fatal("Valid index was rebuilt")
+	}
+
+	idx, e := m.IVFIndex(8, 0)
+	if e != nil {
+		t.Fatal(e)
+	}
+	meta, e := readIndexMeta(path + ".ivf.meta")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if meta.Lists != 8 || idx.Len() != 300 {
+		t.Fatal("Index of other number of lists was not rebuilt")
+	}
+	m.Close()
+
+	replaceModel(t, path, words, 2)
+	other := openModel(t, path)
+	defer other.Close()
+	if _, e := other.IVFIndex(8, 0); e != nil {
+		t.Fatal(e)
+	}
+	if meta, e = readIndexMeta(path + ".ivf.meta"); e != nil {
+		t.Fatal(e)
+	}
+	if meta.Checksum != other.Checksum() {
+		t.Fatal("Index of another model was not rebuilt")
+	}
+}
+
+func TestVPIndexMeta(t *testing.T) {
+	var words []string
+	for i := 0; i < 300; i++ {
+		words = append(words, string(rune('a'+i%26))+string(rune('a'+i/26)))
+	}
+	path := writeModel(t, words, 1)
+	m := openModel(t, path)
+	if _, e := m.VPIndex(); e != nil {
+		t.Fatal(e)
+	}
+	built := modTime(t, path+".vpt")
+
+	if _, e := m.VPIndex(); e != nil {
+		t.Fatal(e)
+	}
+	if modTime(t, path+".vpt") != built {
+		t.Fatal("Valid index was rebuilt")
+	}
+	m.Close()
+
+	replaceModel(t, path, words, 2)
+	other := openModel(t, path)
+	defer other.Close()
+	idx, e := other.VPIndex()
+	if e != nil {
+		t.Fatal(e)
+	}
+	meta, e := readIndexMeta(path + ".vpt.meta")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if meta.Checksum != other.Checksum() {
+		t.Fatal("Index of another model was not rebuilt")
+	}
+	got, e := idx.SearchID(other.WordID("ab"), 1)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(got) != 1 || got[0].ID != other.WordID("ab") {
+		t.Fatalf("Word is not its own nearest neighbour: %v", got)
+	}
+}
+
+func TestHNSWIndexMeta(t *testing.T) {
+	var words []string
+	for i := 0; i < 300; i++ {
+		words = append(words, string(rune('a'+i%26))+string(rune('a'+i/26)))
+	}
+	path := writeModel(t, words, 1)
+	m := openModel(t, path)
+	if _, e := m.HNSWIndex(); e != nil {
+		t.Fatal(e)
+	}
+	built := modTime(t, path+".hnsw")
+
+	if _, e := m.HNSWIndex(); e != nil {
+		t.Fatal(e)
+	}
+	if modTime(t, path+".hnsw") != built {
+		t.Fatal("Valid index was rebuilt")
+	}
+	m.Close()
+
+	replaceModel(t, path, words, 2)
+	other := openModel(t, path)
+	defer other.Close()
+	idx, e := other.HNSWIndex()
+	if e != nil {
+		t.Fatal(e)
+	}
+	meta, e := readIndexMeta(path + ".hnsw.meta")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if meta.Checksum != other.Checksum() {
+		t.Fatal("Index of another model was not rebuilt")
+	}
+	got, e := idx.SearchID(other.WordID("ab"), 1)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(got) != 1 || got[0].ID != other.WordID("ab") {
+		t.Fatalf("Word is not its own nearest neighbour: %v", got)
+	}
+}
@@ -0,0 +1,76 @@
+package govector
+
+import "unicode/utf8"
+
+// NGramMode selects the unit a word is split into before subword n-grams
+// are extracted.
+type NGramMode int
+
+const (
+	// RuneNGrams counts n in decoded code points. Invalid UTF-8 bytes are
+	// replaced by utf8.RuneError.
+	RuneNGrams NGramMode = iota
+	// ByteNGrams reproduces fastText's computeSubwords byte scan: a character
+	// is a leading byte followed by its UTF-8 continuation bytes. Invalid
+	// sequences are kept byte for byte, exactly as fastText stores them.
+	ByteNGrams
+)
+
+// NGramOptions controls subword n-gram extraction.
+type NGramOptions struct {
+	MinN int
+	MaxN int
+	// BOW and EOW are the begin and end of word markers wrapped around the
+	// word before extraction.
+	BOW  string
+	EOW  string
+	Mode NGramMode
+}
+
+// DefaultNGramOptions matches the fastText defaults the stored models are
+// trained with.
+var DefaultNGramOptions = NGramOptions{MinN: 3, MaxN: 6, BOW: "<", EOW: ">", Mode: ByteNGrams}
+
+// NGrams returns the subword n-grams of word wrapped in opts.BOW and opts.EOW
+// in fastText order: grouped by start position, shortest first. Single
+// characters at either end of the wrapped word (the markers themselves) are
+// never emitted.
+func NGrams(word string, opts NGramOptions) (ngrams []string) {
+	s := opts.BOW + word + opts.EOW
+	if opts.Mode == ByteNGrams {
+		return byteNGrams(s, opts.MinN, opts.MaxN)
+	}
+	return runeNGrams(s, opts.MinN, opts.MaxN)
+}
+
+func runeNGrams(s string, minn, maxn int) (ngrams []string) {
+	runes := []rune(s)
+	L := len(runes)
+	for i := 0; i < L; i++ {
+		for n := 1; i+n <= L && n <= maxn; n++ {
+			if n >= minn && !(n == 1 && (i == 0 || i+n == L)) {
+				ngrams = append(ngrams, string(runes[i:i+n]))
+			}
+		}
+	}
+	return
+}
+
+func byteNGrams(s string, minn, maxn int) (ngrams []string) {
+	L := len(s)
+	for i := 0; i < L; i++ {
+		if !utf8.RuneStart(s[i]) {
+			continue
+		}
+		for j, n := i, 1; j < L && n <= maxn; n++ {
+			j++
+			for j < L && !utf8.RuneStart(s[j]) {
+				j++
+			}
+			if n >= minn && !(n == 1 && (i == 0 || j == L)) {
+				ngrams = append(ngrams, s[i:j])
+			}
+		}
+	}
+	return
+}
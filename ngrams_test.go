@@ -0,0 +1,117 @@
+package govector
+
+import (
+	"reflect"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+)
+
+// fastTextSubwords is a line by line port of fastText's
+// Dictionary::computeSubwords used as the reference implementation.
+func fastTextSubwords(word string, minn, maxn int) (ngrams []string) {
+	for i := 0; i < len(word); i++ {
+		ngram := []byte{}
+		if (word[i] & 0xC0) == 0x80 {
+			continue
+		}
+		for j, n := i, 1; j < len(word) && n <= maxn; n++ {
+			ngram = append(ngram, word[j])
+			j++
+			for j < len(word) && (word[j]&0xC0) == 0x80 {
+				ngram = append(ngram, word[j])
+				j++
+			}
+			if n >= minn && !(n == 1 && (i == 0 || j == len(word))) {
+				ngrams = append(ngrams, string(ngram))
+			}
+		}
+	}
+	return
+}
+
+func TestNGramsReference(t *testing.T) {
+	for _, tc := range []struct {
+		word string
+		want []string
+	}{
+		{"where", []string{
+			"<wh", "<whe", "<wher", "<where",
+			"whe", "wher", "where", "where>",
+			"her", "here", "here>",
+			"ere", "ere>",
+			"re>",
+		}},
+		{"Путин", []string{
+			"<Пу", "<Пут", "<Пути", "<Путин",
+			"Пут", "Пути", "Путин", "Путин>",
+			"ути", "утин", "утин>",
+			"тин", "тин>",
+			"ин>",
+		}},
+		{"a", []string{"<a>"}},
+		{"", nil},
+	} {
+		for _, mode := range []NGramMode{RuneNGrams, ByteNGrams} {
+			opts := DefaultNGramOptions
+			opts.Mode = mode
+			if got := NGrams(tc.word, opts); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("NGrams(%q) mode %d\nwant %q\n got %q", tc.word, mode, tc.want, got)
+			}
+		}
+	}
+}
+
+func TestNGramsMarkers(t *testing.T) {
+	opts := NGramOptions{MinN: 2, MaxN: 3, BOW: "^", EOW: "$", Mode: RuneNGrams}
+	want := []string{"^a", "^ab", "ab", "ab$", "b$"}
+	if got := NGrams("ab", opts); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %q got %q", want, got)
+	}
+}
+
+func TestNGramsInvalidUTF8(t *testing.T) {
+	opts := NGramOptions{MinN: 2, MaxN: 3}
+	word := "a\x80b\xff"
+
+	opts.Mode = ByteNGrams
+	if got, want := NGrams(word, opts), fastTextSubwords(word, 2, 3); !reflect.DeepEqual(got, want) {
+		t.Fatalf("byte mode must match fastText: want %q got %q", want, got)
+	}
+	opts.Mode = RuneNGrams
+	for _, ngram := range NGrams(word, opts) {
+		if !utf8.ValidString(ngram) {
+			t.Fatalf("rune mode produced invalid UTF-8 %q", ngram)
+		}
+	}
+}
+
+func TestNGramsProperties(t *testing.T) {
+	property := func(word string, minn, maxn uint8) bool {
+		opts := DefaultNGramOptions
+		opts.MinN = int(minn%6) + 1
+		opts.MaxN = opts.MinN + int(maxn%4)
+
+		opts.Mode = ByteNGrams
+		byteLevel := NGrams(word, opts)
+		if !reflect.DeepEqual(byteLevel, fastTextSubwords(opts.BOW+word+opts.EOW, opts.MinN, opts.MaxN)) {
+			return false
+		}
+		opts.Mode = RuneNGrams
+		runeLevel := NGrams(word, opts)
+		// on valid UTF-8 both modes agree
+		if !reflect.DeepEqual(byteLevel, runeLevel) {
+			return false
+		}
+		for _, ngram := range runeLevel {
+			n := utf8.RuneCountInString(ngram)
+			if n < opts.MinN || n > opts.MaxN {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Fatal(err)
+	}
+}
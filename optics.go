@@ -3,12 +3,10 @@ package govector
 import (
 	"fmt"
 	"log"
-
-	"container/heap"
-
+	"math"
+	"sort"
 	"time"
 
-	"github.com/vseledkin/govector/annoy"
 	"github.com/vseledkin/govector/index"
 )
 
@@ -25,219 +23,453 @@ type Point struct {
 	Vector               []float32
 }
 
-func next(pq index.MinPriorityQueue) *Point {
-	for _, item := range pq {
-		if !item.Item.(*Point).Processed {
-			return item.Item.(*Point)
+// Noise labels points which belong to no cluster
+const Noise = -1
+
+// clusterSearchLimit is the number of nearest neighbours ComputeClusters
+// searches, epsilon neighbourhoods are truncated to it
+const clusterSearchLimit = 200
+
+// ComputeClusters groups all words into density based clusters, epsilon is
+// the maximum distance (radius) to consider and MinPts is the number of
+// points required to form a cluster. Words are ordered by OPTICS with
+// neighbours searched in angular Annoy forest of 16 trees, and the ordering
+// is cut at epsilon, which gives DBSCAN clusters. Words are returned by
+// cluster label, noise words under Noise.
+func (m *Manifold) ComputeClusters(epsilon float32, MinPts int) (map[int][]string, error) {
+	words, points, idx, e := m.wordIndex()
+	if e != nil {
+		return nil, e
+	}
+	start := time.Now()
+	result, e := OPTICS(points, OPTICSOptions{
+		Epsilon:   epsilon,
+		MinPts:    MinPts,
+		Neighbors: IndexNeighbors(idx, points, clusterSearchLimit),
+	})
+	if e != nil {
+		return nil, e
+	}
+	log.Printf("Ordered %d words in %s", len(words), time.Now().Sub(start))
+	return groupWords(words, result.ExtractDBSCAN(epsilon)), nil
+}
+
+// wordIndex returns all words, their vectors and angular Annoy forest of 16
+// trees over the vectors, which holds words under their positions
+func (m *Manifold) wordIndex() (words []string, points [][]float32, idx index.Index, e error) {
+	m.VisitWords(func(key string) bool {
+		words = append(words, key)
+		return true
+	})
+	log.Printf("Indexing %d words", len(words))
+	annoy, e := index.NewAnnoy(m.Dim(), index.Angular, SIMDKernels)
+	if e != nil {
+		return
+	}
+	annoy.Trees = 16
+	points = make([][]float32, len(words))
+	for i, word := range words {
+		points[i] = m.Row(m.WordID(word))
+		if e = annoy.Add(int32(i), points[i]); e != nil {
+			return
 		}
 	}
-	return nil
+	return words, points, annoy, annoy.Build()
+}
+
+// groupWords returns words by their cluster labels
+func groupWords(words []string, labels []int) map[int][]string {
+	clusters := make(map[int][]string)
+	for i, label := range labels {
+		clusters[label] = append(clusters[label], words[i])
+	}
+	return clusters
 }
 
-/*
-ComputeClusters
-	epsilon - the maximum distance (radius) to consider
-	MinPts  - the number of points required to form a cluster.
-*/
-func (m *Manifold) ComputeClusters(epsilon float32, MinPts int) {
-	/*defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered in f", r)
-			fmt.Println("Failed to create index")
+// NeighborSearch returns ids of all points within radius of point id, the
+// point itself included, and their distances ordered from the closest
+type NeighborSearch func(id int32, radius float32) (ids []int32, distances []float32)
 
+// VPNeighbors returns exact NeighborSearch over points backed by VP-tree,
+// distance must be a true metric
+func VPNeighbors(points [][]float32, distance func(x, y []float32) float32) NeighborSearch {
+	ids := make([]int32, len(points))
+	for i := range ids {
+		ids[i] = int32(i)
+	}
+	tree := index.NewVPTree(func(x, y int32) float32 { return distance(points[x], points[y]) }, ids)
+	return func(id int32, radius float32) ([]int32, []float32) {
+		return tree.SearchRadius(id, radius)
+	}
+}
+
+// IndexNeighbors returns approximate NeighborSearch backed by idx which
+// holds points under their ids. Only limit nearest neighbours are searched,
+// so dense neighbourhoods are truncated.
+func IndexNeighbors(idx index.Index, points [][]float32, limit int) NeighborSearch {
+	return func(id int32, radius float32) (ids []int32, distances []float32) {
+		neighbors, e := idx.Search(points[id], limit)
+		if e != nil {
+			panic(e)
 		}
-	}()*/
-	//var max uint32 = 1000
-	var max uint32 = 100000
-	if m.WordCount() < max {
-		max = m.WordCount()
+		for _, n := range neighbors {
+			if n.Distance > radius {
+				break
+			}
+			ids = append(ids, n.ID)
+			distances = append(distances, n.Distance)
+		}
+		return
 	}
+}
 
-	points := make([]interface{}, max)
+// OPTICSOptions controls OPTICS
+type OPTICSOptions struct {
+	// Epsilon is the largest distance neighbours are searched within, all
+	// points are neighbours when it is not positive
+	Epsilon float32
+	// MinPts is the number of points within Epsilon, the point itself
+	// included, which makes a point core point
+	MinPts int
+	// Metric is the distance of the default neighbour search
+	Metric index.Metric
+	// Neighbors searches neighbours of points, exact VPNeighbors with Metric
+	// when nil
+	Neighbors NeighborSearch
+}
 
-	log.Printf("Reading %d words", max)
-	var i uint32 = 0
-	m.VisitWords(func(key string) bool {
-		if len(key) == 0 {
-			panic(fmt.Errorf("Empty key"))
+// DefaultOPTICSOptions searches angular neighbourhoods of any size
+var DefaultOPTICSOptions = OPTICSOptions{MinPts: 5, Metric: index.Angular}
+
+// OPTICSResult is the cluster ordering of points. Distances which are not
+// defined are +Inf.
+type OPTICSResult struct {
+	// Order lists point ids in the order they were processed
+	Order []int32
+	// Reachability is the reachability distance of every point, it is
+	// +Inf for the first point of every connected component
+	Reachability []float32
+	// Core is the core distance of every point, +Inf when the point is not
+	// a core point
+	Core []float32
+	// Predecessor is the point every point was reached from, -1 when none
+	Predecessor []int32
+	// MinPts is the MinPts of OPTICS options
+	MinPts int
+}
+
+// OPTICS orders points so that points of a density based cluster are
+// consecutive and computes their reachability distances, see Ankerst et
+// al. "OPTICS: Ordering Points To Identify the Clustering Structure".
+func OPTICS(points [][]float32, opts OPTICSOptions) (*OPTICSResult, error) {
+	if opts.MinPts < 1 {
+		return nil, fmt.Errorf("MinPts %d is less than 1", opts.MinPts)
+	}
+	for i, p := range points {
+		if len(p) != len(points[0]) {
+			return nil, fmt.Errorf("Point %d has dimension %d, want %d", i, len(p), len(points[0]))
+		}
+	}
+	epsilon := opts.Epsilon
+	if epsilon <= 0 {
+		epsilon = float32(math.Inf(1))
+	}
+	neighbors := opts.Neighbors
+	if neighbors == nil {
+		neighbors = VPNeighbors(points, Distance(opts.Metric))
+	}
+
+	undefined := float32(math.Inf(1))
+	result := &OPTICSResult{
+		Order:        make([]int32, 0, len(points)),
+		Reachability: make([]float32, len(points)),
+		Core:         make([]float32, len(points)),
+		Predecessor:  make([]int32, len(points)),
+		MinPts:       opts.MinPts,
+	}
+	for i := range points {
+		result.Reachability[i] = undefined
+		result.Core[i] = undefined
+		result.Predecessor[i] = -1
+	}
+	processed := make([]bool, len(points))
+	seeds := index.NewIndexedHeap[int32](false)
+	// process appends p to the ordering and offers its unprocessed
+	// neighbours to seeds when p is a core point
+	process := func(p int32) {
+		ids, distances := neighbors(p, epsilon)
+		processed[p] = true
+		result.Order = append(result.Order, p)
+		if len(ids) < opts.MinPts {
+			return
 		}
-		points[i] = &Point{key, UNDEFINED, UNDEFINED, false, 0, nil}
-		i++
-		if i == max {
-			return false
+		core := distances[opts.MinPts-1]
+		result.Core[p] = core
+		for i, o := range ids {
+			if processed[o] {
+				continue
+			}
+			reachability := core
+			if distances[i] > reachability {
+				reachability = distances[i]
+			}
+			if reachability < result.Reachability[o] {
+				result.Reachability[o] = reachability
+				result.Predecessor[o] = p
+				seeds.Push(o, reachability)
+			}
 		}
-		if i%1e4 == 0 {
-			log.Printf("Read %d words", i)
+	}
+	for p := range points {
+		if processed[p] {
+			continue
 		}
-		return true
-	})
+		process(int32(p))
+		for seeds.Len() > 0 {
+			q, _ := seeds.Pop()
+			process(q)
+		}
+	}
+	return result, nil
+}
 
-	log.Printf("Read %d words", i)
-	searchLimit := 200
-	idx := annoy.NewAnnoyIndexAngular(128)
-	start := time.Now()
-	for i, pp := range points {
-		v, e := m.GetVector(pp.(*Point).Item)
-		if e != nil {
-			panic(e)
+// ExtractDBSCAN labels points with clusters DBSCAN finds with MinPts and
+// epsilon, which must not exceed Epsilon of OPTICS. Clusters are numbered
+// from 0 in the order of the ordering and points of no cluster are Noise.
+// Border points reachable from several clusters may be assigned to another
+// cluster than DBSCAN would assign them.
+func (r *OPTICSResult) ExtractDBSCAN(epsilon float32) []int {
+	labels := make([]int, len(r.Order))
+	label := Noise
+	for _, p := range r.Order {
+		if r.Reachability[p] > epsilon {
+			if r.Core[p] > epsilon {
+				labels[p] = Noise
+				continue
+			}
+			label++
 		}
-		pp.(*Point).Vector = v
-		idx.AddItem(i, pp.(*Point).Vector)
-	}
-	readTime := time.Now().Sub(start).Seconds()
-	start = time.Now()
-	idx.Build(16)
-	indexTime := time.Now().Sub(start).Seconds()
-	search := func(v []float32) ([]interface{}, []float32) {
-		var distances []float32
-		var near []int
-		var nearest []interface{}
-		idx.GetNnsByVector(v, searchLimit, -1, &near, &distances)
-		for i, j := range near {
-			if distances[i] > epsilon {
-				return nearest[:i], distances[:i]
+		labels[p] = label
+	}
+	return labels
+}
+
+// XiCluster is a cluster found by ExtractXi, it holds points
+// Order[Start:End+1] of OPTICSResult. Clusters nested in it are Children.
+type XiCluster struct {
+	Start, End int
+	Children   []*XiCluster
+}
+
+// ExtractXi finds clusters as steep areas of the reachability plot, see
+// section 4.3 of the OPTICS paper. xi in (0, 1) is the relative reachability
+// drop which starts or ends a cluster, clusters of fewer than
+// minClusterSize points are ignored. It returns cluster labels of points,
+// the smallest cluster of every point or Noise, and the hierarchy of
+// clusters with the outermost ones at the top. The algorithm follows
+// scikit-learn including its corrections of the paper and predecessor
+// correction.
+func (r *OPTICSResult) ExtractXi(xi float32, minClusterSize int) (labels []int, hierarchy []*XiCluster, e error) {
+	if xi <= 0 || xi >= 1 {
+		return nil, nil, fmt.Errorf("Xi %f is not in (0, 1)", xi)
+	}
+	clusters := r.xiClusters(xi, minClusterSize)
+
+	// clusters come smaller first, so the smallest cluster labels a point
+	labels = make([]int, len(r.Order))
+	for i := range labels {
+		labels[i] = Noise
+	}
+	label := 0
+	for _, c := range clusters {
+		free := true
+		for _, p := range r.Order[c.Start : c.End+1] {
+			if labels[p] != Noise {
+				free = false
+				break
+			}
+		}
+		if free {
+			for _, p := range r.Order[c.Start : c.End+1] {
+				labels[p] = label
 			}
-			nearest = append(nearest, points[j])
+			label++
+		}
+	}
+
+	// clusters are nested or disjoint, so sorting them by start and
+	// outermost first allows to nest them with a stack
+	sorted := make([]*XiCluster, len(clusters))
+	for i := range clusters {
+		sorted[i] = &clusters[i]
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Start != sorted[j].Start {
+			return sorted[i].Start < sorted[j].Start
+		}
+		return sorted[i].End > sorted[j].End
+	})
+	var stack []*XiCluster
+	for _, c := range sorted {
+		for len(stack) > 0 && stack[len(stack)-1].End < c.Start {
+			stack = stack[:len(stack)-1]
 		}
-		return nearest, distances
+		if len(stack) == 0 {
+			hierarchy = append(hierarchy, c)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, c)
+		}
+		stack = append(stack, c)
+	}
+	return
+}
+
+// xiSteepArea is a steep down area of the reachability plot, mib is the
+// maximum reachability between its end and the current point
+type xiSteepArea struct {
+	start, end int
+	mib        float32
+}
+
+// xiClusters returns clusters found by the Xi method, smaller ones first
+func (r *OPTICSResult) xiClusters(xi float32, minClusterSize int) (clusters []XiCluster) {
+	n := len(r.Order)
+	// the trailing +Inf ends clusters reaching the end of the plot
+	plot := make([]float32, n+1)
+	for i, p := range r.Order {
+		plot[i] = r.Reachability[p]
+	}
+	plot[n] = float32(math.Inf(1))
+
+	complement := 1 - xi
+	steepUp := make([]bool, n)
+	steepDown := make([]bool, n)
+	up := make([]bool, n)
+	down := make([]bool, n)
+	for i := 0; i < n; i++ {
+		// ratios of two +Inf are NaN, which is neither steep nor up or down
+		ratio := plot[i] / plot[i+1]
+		steepUp[i] = ratio <= complement
+		steepDown[i] = ratio >= 1/complement
+		down[i] = ratio > 1
+		up[i] = ratio < 1
 	}
-	var orderedList []interface{}
-	// Optics
-	start = time.Now()
-	for _, pp := range points {
-		p := pp.(*Point)
-		//fmt.Printf("PROCESS: p -> %d-%s CoreD:%f ReachD:%f N:%d %v\n", j, p.Item, p.CoreDistance, p.ReachabilityDistance, p.NeiboursCount, p.Processed)
-		if p.Processed {
+
+	var areas []xiSteepArea
+	index := 0
+	var mib float32
+	for steep := 0; steep < n; steep++ {
+		if !steepUp[steep] && !steepDown[steep] || steep < index {
 			continue
 		}
-		N, distances := search(p.Vector)
-		//fmt.Printf("<<<<<<<<<\n")
-		//fmt.Printf("Near: %s are:\n", p.Item)
-		//for i, ppp := range N {
-		//	fmt.Printf("%f %s\n", distances[i], ppp.(*Point).Item)
-
-		//}
-		p.Processed = true
-		p.NeiboursCount = len(N)
-		if len(N) >= MinPts {
-			p.CoreDistance = distances[MinPts-1]
-			p.ReachabilityDistance = 0 //p.CoreDistance // distance to itself is zero
-		}
-		//orderedList = append(orderedList, p)
-		if p.CoreDistance != UNDEFINED {
-
-			var seeds index.MinPriorityQueue
-			heap.Push(&seeds, &index.HeapItem{p, p.ReachabilityDistance, -1})
-
-			m.update(N, p, &seeds, epsilon, MinPts)
-			for q := next(seeds); q != nil; q = next(seeds) {
-				//for _, qhi := range seeds {
-				//q := heap.Pop(&seeds).(*index.HeapItem).Item.(*Point)
-				NQ, nqd := search(q.Vector)
-				//fmt.Printf(">>>>>>>>> %d\n", len(seeds))
-				//fmt.Printf("Near: %s are:\n", q.Item)
-				//for i, ppp := range NQ {
-				//	fmt.Printf("%f %s\n", nqd[i], ppp.(*Point).Item)
-
-				//}
-				q.Processed = true
-				q.NeiboursCount = len(NQ)
-				if len(NQ) >= MinPts {
-					q.CoreDistance = nqd[MinPts-1]
+		for _, x := range plot[index : steep+1] {
+			if x > mib {
+				mib = x
+			}
+		}
+		areas = filterSteepAreas(areas, mib, complement, plot)
+		if steepDown[steep] {
+			end := extendRegion(steepDown, up, steep, r.MinPts)
+			areas = append(areas, xiSteepArea{start: steep, end: end})
+			index = end + 1
+			mib = plot[index]
+			continue
+		}
+
+		upStart := steep
+		upEnd := extendRegion(steepUp, down, steep, r.MinPts)
+		index = upEnd + 1
+		mib = plot[index]
+		var found []XiCluster
+		for _, area := range areas {
+			start, end := area.start, upEnd
+			if plot[end+1]*complement < area.mib {
+				continue
+			}
+			// Definition 11, criterion 4
+			max := plot[area.start]
+			if max*complement >= plot[end+1] {
+				for plot[start+1] > plot[end+1] && start < area.end {
+					start++
 				}
-				//if q.ReachabilityDistance != UNDEFINED {
-				//	heap.Push(&out, &index.HeapItem{q, q.ReachabilityDistance, -1})
-				//}
-				//orderedList = append(orderedList, q)
-				if q.CoreDistance != UNDEFINED {
-					m.update(NQ, q, &seeds, epsilon, MinPts)
+			} else if plot[end+1]*complement >= max {
+				for plot[end-1] > max && end > upStart {
+					end--
 				}
 			}
-			for len(seeds) > 0 {
-				pout := heap.Pop(&seeds).(*index.HeapItem).Item.(*Point)
-				orderedList = append(orderedList, pout)
+			var ok bool
+			if start, end, ok = r.correctPredecessor(plot, start, end); !ok {
+				continue
 			}
-			orderedList = append(orderedList, nil)
-			//fmt.Printf("Finish proc SEEDS l:%d\n", len(seeds))
+			// Definition 11, criteria 3a, 1 and 2
+			if end-start+1 < minClusterSize || start > area.end || end < upStart {
+				continue
+			}
+			found = append(found, XiCluster{Start: start, End: end})
+		}
+		// smaller clusters first
+		for i := len(found) - 1; i >= 0; i-- {
+			clusters = append(clusters, found[i])
 		}
+	}
+	return
+}
 
-		/*
-			if len(nearestPoints) > MinPts {
-				fmt.Printf("%d-%s %f\n", j, p.(*Point).Item, p.(*Point).CoreDistance)
-				for i, np := range nearestPoints {
-					fmt.Printf("\t%d %s %f\n", i, np.(*Point).Item, distances[i])
-				}
-			}*/
-	}
-	//for j, p := range points {
-	//	fmt.Printf("p -> %d-%s CoreD:%f ReachD:%f N:%d %v\n", j, p.(*Point).Item, p.(*Point).CoreDistance, p.(*Point).ReachabilityDistance, p.(*Point).NeiboursCount, p.(*Point).Processed)
-	//}
-	clusterTime := time.Now().Sub(start).Seconds()
-	fmt.Printf("--------\n")
-	outCount := 0
-	for _, p := range orderedList {
-		if p == nil {
-			fmt.Printf("------------------\n")
-			continue
+// filterSteepAreas drops steep down areas which can not start a cluster
+// anymore because mib rose above them and updates mib of the others
+func filterSteepAreas(areas []xiSteepArea, mib, complement float32, plot []float32) []xiSteepArea {
+	if math.IsInf(float64(mib), 1) {
+		return nil
+	}
+	kept := areas[:0]
+	for _, area := range areas {
+		if mib <= plot[area.start]*complement {
+			if mib > area.mib {
+				area.mib = mib
+			}
+			kept = append(kept, area)
 		}
-		if p.(*Point).ReachabilityDistance == UNDEFINED {
-			fmt.Printf("o -> %s %d\n", p.(*Point).Item, p.(*Point).NeiboursCount)
-			//fmt.Printf("o -> %s CoreD:%f ReachD:%f N:%d %v\n", p.(*Point).Item, p.(*Point).CoreDistance, p.(*Point).ReachabilityDistance, p.(*Point).NeiboursCount, p.(*Point).Processed)
+	}
+	return kept
+}
+
+// extendRegion returns the end of the steep area starting at start which
+// holds no more than minPts consecutive points that are not steep
+func extendRegion(steep, xward []bool, start, minPts int) int {
+	end := start
+	nonXward := 0
+	for i := start; i < len(steep); i++ {
+		if steep[i] {
+			nonXward = 0
+			end = i
+		} else if !xward[i] {
+			nonXward++
+			if nonXward > minPts {
+				break
+			}
 		} else {
-			fmt.Printf("o -> %d-%s CoreD:%f ReachD:%f N:%d %v\n", outCount, p.(*Point).Item, p.(*Point).CoreDistance, p.(*Point).ReachabilityDistance, p.(*Point).NeiboursCount, p.(*Point).Processed)
-			outCount++
+			return end
 		}
 	}
-	fmt.Printf("Index time: %f s\n", indexTime)
-	fmt.Printf("Read time: %f s\n", readTime)
-	fmt.Printf("Cluster time: %f s\n", clusterTime)
-
-}
-func labeler(it *index.HeapItem) string {
-	return fmt.Sprintf("%s %v", it.Item.(*Point).Item, it.Item.(*Point).Processed)
+	return end
 }
-func (m *Manifold) update(N []interface{}, P interface{}, seeds *index.MinPriorityQueue, epsilon float32, MinPts int) {
-	p := P.(*Point)
-	for _, oo := range N {
-		o := oo.(*Point)
-		if o.Processed {
-			continue
+
+// correctPredecessor shrinks cluster [start, end] of the plot until its
+// last point was reached from a point of the cluster, it reports false
+// when nothing remains
+func (r *OPTICSResult) correctPredecessor(plot []float32, start, end int) (int, int, bool) {
+	for start < end {
+		if plot[start] > plot[end] {
+			return start, end, true
 		}
-		newReachDist := max(p.CoreDistance, m.Angular(p, o))
-		if o.ReachabilityDistance == UNDEFINED { // o is not in Seeds
-			o.ReachabilityDistance = newReachDist
-			//fmt.Printf("Add %s to SEEDS l:%d with RD %f\n", o.Item, len(*seeds), o.ReachabilityDistance)
-			heap.Push(seeds, &index.HeapItem{o, o.ReachabilityDistance, -1})
-			//seeds.Print(labeler)
-		} else {
-			if newReachDist < o.ReachabilityDistance { // o in Seeds, check for improvement
-				//fmt.Printf("Update RD of %s %v from %f to %f in l:%d \n", o.Item, o.Processed, o.ReachabilityDistance, newReachDist, len(*seeds))
-				o.ReachabilityDistance = newReachDist
-				/// find item position in pqueue
-				///var i int
-				///var hi *index.HeapItem
-				///var ok bool
-				//seeds.Print(labeler)
-				//for i, hi := range *seeds {
-				//	fmt.Printf("\t%d %d %s %f %f\n", i, hi.Index, hi.Item.(*Point).Item, hi.Item.(*Point).ReachabilityDistance, hi.Dist)
-				//	if hi.Item.(*Point).Item == o.Item {
-				//		hi.Dist = o.ReachabilityDistance
-				//	}
-				//}
-				hi := seeds.Find(o)
-				hi.Dist = o.ReachabilityDistance
-				heap.Fix(seeds, hi.Index)
-
-				//fmt.Printf("\n")
-				//seeds.Print(labeler)
-				//for i, hi := range *seeds {
-				//	fmt.Printf("\t%d %d %s %f %f\n", i, hi.Index, hi.Item.(*Point).Item, hi.Item.(*Point).ReachabilityDistance, hi.Dist)
-				//	if hi.Item.(*Point).Item == o.Item {
-				//		hi.Dist = o.ReachabilityDistance
-				//	}
-				//}
+		predecessor := r.Predecessor[r.Order[end]]
+		for _, p := range r.Order[start:end] {
+			if p == predecessor {
+				return start, end, true
 			}
 		}
-
+		end--
 	}
+	return 0, 0, false
 }
@@ -0,0 +1,263 @@
+package govector
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/vseledkin/govector/index"
+)
+
+func TestOPTICS(t *testing.T) {
+	points := [][]float32{{10}, {0}, {30}, {2}, {11}, {1}}
+	calls := 0
+	exact := VPNeighbors(points, Distance(index.Euclidean))
+	result, e := OPTICS(points, OPTICSOptions{
+		Epsilon: 5,
+		MinPts:  2,
+		Neighbors: func(id int32, radius float32) ([]int32, []float32) {
+			calls++
+			return exact(id, radius)
+		},
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	inf := float32(math.Inf(1))
+	want := &OPTICSResult{
+		Order:        []int32{0, 4, 1, 5, 3, 2},
+		Reachability: []float32{inf, inf, inf, 1, 1, 1},
+		Core:         []float32{1, 1, inf, 1, 1, 1},
+		Predecessor:  []int32{-1, -1, -1, 5, 0, 1},
+		MinPts:       2,
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("OPTICS returned %+v, want %+v", result, want)
+	}
+	if calls != len(points) {
+		t.Fatalf("Neighbours searched %d times, want %d", calls, len(points))
+	}
+
+	if _, e := OPTICS(points, OPTICSOptions{}); e == nil {
+		t.Fatal("MinPts 0 must fail")
+	}
+	if _, e := OPTICS([][]float32{{1}, {1, 2}}, DefaultOPTICSOptions); e == nil {
+		t.Fatal("Points of different dimensions must fail")
+	}
+}
+
+// gaussianBlobs returns size points drawn around every center with
+// standard deviation sigma followed by uniform noise points in [-10, 10)
+func gaussianBlobs(r *rand.Rand, centers [][]float32, size int, sigma float32, noise int) [][]float32 {
+	var points [][]float32
+	for _, center := range centers {
+		for i := 0; i < size; i++ {
+			p := make([]float32, len(center))
+			for j := range p {
+				p[j] = center[j] + sigma*float32(r.NormFloat64())
+			}
+			points = append(points, p)
+		}
+	}
+	for i := 0; i < noise; i++ {
+		p := make([]float32, len(centers[0]))
+		for j := range p {
+			p[j] = 20*r.Float32() - 10
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// checkOPTICS replays the ordering of result with brute force OPTICS and
+// fails unless every point is the seed of minimum reachability when it is
+// ordered, or the first unprocessed point when there are no seeds, and
+// reachability and core distances match
+func checkOPTICS(t *testing.T, points [][]float32, distance func(x, y []float32) float32, epsilon float32, minPts int, result *OPTICSResult) {
+	t.Helper()
+	const tolerance = 1e-5
+	inf := float32(math.Inf(1))
+	if len(result.Order) != len(points) {
+		t.Fatalf("Ordering of %d points, want %d", len(result.Order), len(points))
+	}
+	core := make([]float32, len(points))
+	reachability := make([]float32, len(points))
+	for p := range points {
+		var distances []float32
+		for o := range points {
+			if d := distance(points[p], points[o]); d <= epsilon {
+				distances = append(distances, d)
+			}
+		}
+		core[p] = inf
+		if len(distances) >= minPts {
+			sort.Slice(distances, func(i, j int) bool { return distances[i] < distances[j] })
+			core[p] = distances[minPts-1]
+		}
+		reachability[p] = inf
+	}
+	processed := make([]bool, len(points))
+	for step, p := range result.Order {
+		if processed[p] {
+			t.Fatalf("Point %d ordered twice", p)
+		}
+		first, minimum := -1, inf
+		for o := range points {
+			if processed[o] {
+				continue
+			}
+			if first < 0 {
+				first = o
+			}
+			if reachability[o] < minimum {
+				minimum = reachability[o]
+			}
+		}
+		if math.IsInf(float64(minimum), 1) {
+			if int(p) != first {
+				t.Fatalf("Step %d orders point %d without seeds, want %d", step, p, first)
+			}
+		} else if reachability[p]-minimum > tolerance {
+			t.Fatalf("Step %d orders point %d of reachability %f, minimum is %f", step, p, reachability[p], minimum)
+		}
+		processed[p] = true
+		if math.IsInf(float64(core[p]), 1) {
+			continue
+		}
+		for o := range points {
+			d := distance(points[p], points[o])
+			if processed[o] || d > epsilon {
+				continue
+			}
+			if d < core[p] {
+				d = core[p]
+			}
+			if d < reachability[o] {
+				reachability[o] = d
+			}
+		}
+	}
+	for p := range points {
+		if !approximately(result.Core[p], core[p], tolerance) {
+			t.Fatalf("Core distance of point %d is %f, want %f", p, result.Core[p], core[p])
+		}
+		if !approximately(result.Reachability[p], reachability[p], tolerance) {
+			t.Fatalf("Reachability of point %d is %f, want %f", p, result.Reachability[p], reachability[p])
+		}
+	}
+}
+
+func approximately(x, y, tolerance float32) bool {
+	if math.IsInf(float64(x), 1) || math.IsInf(float64(y), 1) {
+		return x == y
+	}
+	return x-y <= tolerance && y-x <= tolerance
+}
+
+// checkBlobLabels fails unless blobs of size consecutive points are
+// labelled with clusters of their own, at most misses points of a blob may
+// be labelled otherwise or with a negative label, which is no cluster
+func checkBlobLabels(t *testing.T, labels []int, blobs, size, misses int) {
+	t.Helper()
+	seen := make(map[int]int)
+	for b := 0; b < blobs; b++ {
+		counts := make(map[int]int)
+		for _, label := range labels[b*size : (b+1)*size] {
+			counts[label]++
+		}
+		best := -1
+		for label, count := range counts {
+			if label >= 0 && (best < 0 || count > counts[best]) {
+				best = label
+			}
+		}
+		if best < 0 || counts[best] < size-misses {
+			t.Fatalf("Blob %d labelled %v", b, counts)
+		}
+		if other, ok := seen[best]; ok {
+			t.Fatalf("Blobs %d and %d share cluster %d", other, b, best)
+		}
+		seen[best] = b
+	}
+}
+
+func TestOPTICSBlobs(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	centers := [][]float32{{-5, -5, 0}, {5, 5, 0}, {5, -5, 5}}
+	points := gaussianBlobs(r, centers, 60, 0.7, 20)
+	distance := Distance(index.Euclidean)
+	for _, opts := range []OPTICSOptions{
+		{MinPts: 5, Metric: index.Euclidean},
+		{Epsilon: 1.5, MinPts: 5, Metric: index.Euclidean},
+		{Epsilon: 3, MinPts: 10, Metric: index.Euclidean},
+	} {
+		result, e := OPTICS(points, opts)
+		if e != nil {
+			t.Fatal(e)
+		}
+		epsilon := opts.Epsilon
+		if epsilon <= 0 {
+			epsilon = float32(math.Inf(1))
+		}
+		checkOPTICS(t, points, distance, epsilon, opts.MinPts, result)
+	}
+
+	// blobs are consecutive in the ordering and separated by reachability
+	// jumps, so cutting at DBSCAN epsilon recovers them
+	result, e := OPTICS(points, OPTICSOptions{MinPts: 5, Metric: index.Euclidean})
+	if e != nil {
+		t.Fatal(e)
+	}
+	checkBlobLabels(t, result.ExtractDBSCAN(1.5), len(centers), 60, 10)
+}
+
+func TestExtractDBSCAN(t *testing.T) {
+	points := [][]float32{{10}, {0}, {30}, {2}, {11}, {1}}
+	result, e := OPTICS(points, OPTICSOptions{Epsilon: 5, MinPts: 2, Metric: index.Euclidean})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if labels, want := result.ExtractDBSCAN(5), []int{0, 1, Noise, 1, 0, 1}; !reflect.DeepEqual(labels, want) {
+		t.Fatalf("DBSCAN labels %v, want %v", labels, want)
+	}
+	if labels, want := result.ExtractDBSCAN(0.5), []int{Noise, Noise, Noise, Noise, Noise, Noise}; !reflect.DeepEqual(labels, want) {
+		t.Fatalf("DBSCAN labels %v, want %v", labels, want)
+	}
+}
+
+func TestExtractXi(t *testing.T) {
+	// two dense groups close to each other, a third far away and an outlier
+	var points [][]float32
+	for _, center := range []float32{0, 3, 50} {
+		for i := 0; i < 5; i++ {
+			points = append(points, []float32{center + float32(i)*0.1})
+		}
+	}
+	points = append(points, []float32{25})
+	result, e := OPTICS(points, OPTICSOptions{MinPts: 3, Metric: index.Euclidean})
+	if e != nil {
+		t.Fatal(e)
+	}
+	labels, hierarchy, e := result.ExtractXi(0.5, 3)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := []int{0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, Noise}; !reflect.DeepEqual(labels, want) {
+		t.Fatalf("Xi labels %v, want %v", labels, want)
+	}
+	// the outermost cluster spans the whole ordering, the close groups are
+	// nested in a cluster of their own
+	want := []*XiCluster{{Start: 0, End: 15, Children: []*XiCluster{
+		{Start: 0, End: 9, Children: []*XiCluster{{Start: 0, End: 4}, {Start: 5, End: 9}}},
+		{Start: 11, End: 15},
+	}}}
+	if !reflect.DeepEqual(hierarchy, want) {
+		t.Fatalf("Xi hierarchy differs, order %v reachability %v", result.Order, result.Reachability)
+	}
+
+	if _, _, e := result.ExtractXi(1, 3); e == nil {
+		t.Fatal("Xi 1 must fail")
+	}
+}
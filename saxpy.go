@@ -0,0 +1,7 @@
+package govector
+
+func saxpy(alpha float32, X, Y []float32) {
+	for i, x := range X {
+		Y[i] += alpha * x
+	}
+}
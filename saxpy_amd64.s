@@ -0,0 +1,112 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func Saxpy(alpha float32, X, Y []float32)
+TEXT ·Saxpy(SB), NOSPLIT, $0-56
+	MOVQ	X_base+8(FP), SI
+	MOVQ	X_len+16(FP), CX
+	MOVQ	Y_base+32(FP), DI
+
+	CMPB	·useAVX2(SB), $1
+	JE	avx2
+
+	// Setup four alphas in X0
+	MOVSS	alpha+0(FP), X0
+	SHUFPS	$0, X0, X0
+
+	SUBQ	$4, CX
+	JL	rest	// There are less than 4 pairs to process
+
+simd_loop:
+	// Load four values of X and scale
+	MOVUPS	(SI), X1
+	MULPS	X0, X1
+	// Save sum in Y
+	MOVUPS	(DI), X2
+	ADDPS	X1, X2
+	MOVUPS	X2, (DI)
+
+	// Update data pointers
+	ADDQ	$16, SI
+	ADDQ	$16, DI
+
+	SUBQ	$4, CX
+	JGE	simd_loop	// There are 4 or more pairs to process
+
+rest:
+	// Undo last SUBQ
+	ADDQ	$4, CX
+	// Check that are there any value to process
+	JE	end
+
+loop:
+	// Load from X and scale
+	MOVSS	(SI), X1
+	MULSS	X0, X1
+	// Save sum in Y
+	ADDSS	(DI), X1
+	MOVSS	X1, (DI)
+
+	// Update data pointers
+	ADDQ	$4, SI
+	ADDQ	$4, DI
+
+	DECQ	CX
+	JNE	loop
+
+end:
+	RET
+
+avx2:
+	// Setup eight alphas in Y2
+	VBROADCASTSS	alpha+0(FP), Y2
+
+	SUBQ	$16, CX
+	JL	avx2_tail8	// There are less than 16 pairs to process
+
+avx2_loop:
+	VMOVUPS	(DI), Y0
+	VMOVUPS	32(DI), Y1
+	VFMADD231PS	(SI), Y2, Y0
+	VFMADD231PS	32(SI), Y2, Y1
+	VMOVUPS	Y0, (DI)
+	VMOVUPS	Y1, 32(DI)
+
+	ADDQ	$64, SI
+	ADDQ	$64, DI
+
+	SUBQ	$16, CX
+	JGE	avx2_loop
+
+avx2_tail8:
+	ADDQ	$16, CX
+	CMPQ	CX, $8
+	JL	avx2_tail1	// There are less than 8 pairs left
+
+	VMOVUPS	(DI), Y0
+	VFMADD231PS	(SI), Y2, Y0
+	VMOVUPS	Y0, (DI)
+
+	ADDQ	$32, SI
+	ADDQ	$32, DI
+	SUBQ	$8, CX
+
+avx2_tail1:
+	TESTQ	CX, CX
+	JE	avx2_end
+
+avx2_loop1:
+	VMOVSS	(DI), X0
+	VFMADD231SS	(SI), X2, X0
+	VMOVSS	X0, (DI)
+
+	ADDQ	$4, SI
+	ADDQ	$4, DI
+
+	DECQ	CX
+	JNE	avx2_loop1
+
+avx2_end:
+	VZEROUPPER
+	RET
@@ -1,34 +1,41 @@
-//func Sdsot(X, Y []float32) float32
-TEXT ·Sdot(SB), 7, $0
-	MOVQ	X_data+0(FP), SI
-	MOVQ	X_len+8(FP), BP
-	MOVQ	Y_data+24(FP), DI
+//go:build !purego
+
+#include "textflag.h"
+
+// func Sdot(X, Y []float32) float32
+TEXT ·Sdot(SB), NOSPLIT, $0-52
+	MOVQ	X_base+0(FP), SI
+	MOVQ	X_len+8(FP), CX
+	MOVQ	Y_base+24(FP), DI
+
+	CMPB	·useAVX2(SB), $1
+	JE	avx2
 
 	// Clear accumulator
 	XORPS	X0, X0
 
 	// Check that there are 4 or more pairs for SIMD calculations
-	SUBQ	$4, BP
-	JL		rest	// There are less than 4 pairs to process
+	SUBQ	$4, CX
+	JL	rest	// There are less than 4 pairs to process
 
-	simd_loop:
-		// Multiply four pairs
-		MOVUPS	(SI), X2
-		MOVUPS	(DI), X3
-		MULPS	X2, X3
+simd_loop:
+	// Multiply four pairs
+	MOVUPS	(SI), X2
+	MOVUPS	(DI), X3
+	MULPS	X2, X3
 
-		// Update data pointers
-		ADDQ	$16, SI
-		ADDQ	$16, DI
+	// Update data pointers
+	ADDQ	$16, SI
+	ADDQ	$16, DI
 
-		// Accumulate the results of multiplications
-		ADDPS	X3, X0
+	// Accumulate the results of multiplications
+	ADDPS	X3, X0
 
-		SUBQ	$4, BP
-		JGE		simd_loop	// There are 4 or more pairs to process
+	SUBQ	$4, CX
+	JGE	simd_loop	// There are 4 or more pairs to process
 
 	// Horizontal sum
-	MOVHLPS X0, X1
+	MOVHLPS	X0, X1
 	ADDPS	X0, X1
 	MOVSS	X1, X0
 	SHUFPS	$0xe1, X1, X1
@@ -36,25 +43,86 @@ TEXT ·Sdot(SB), 7, $0
 
 rest:
 	// Undo last SUBQ
-	ADDQ	$4,	BP
+	ADDQ	$4, CX
 	// Check that are there any value to process
 	JE	end
 
-	loop:
-		// Multiply one pair
-		MOVSS	(SI), X1
-		MULSS	(DI), X1
+loop:
+	// Multiply one pair
+	MOVSS	(SI), X1
+	MULSS	(DI), X1
 
+	// Update data pointers
+	ADDQ	$4, SI
+	ADDQ	$4, DI
 
-		// Update data pointers
-		ADDQ	$4, SI
-		ADDQ	$4, DI
+	// Accumulate
+	ADDSS	X1, X0
 
-		// Save sum in Y
-		ADDSS	X1, X0
+	DECQ	CX
+	JNE	loop
 
-		DECQ	BP
-		JNE	loop
 end:
-	MOVSS	X0, r+48(FP)
+	MOVSS	X0, ret+48(FP)
+	RET
+
+avx2:
+	// Two independent accumulators hide FMA latency
+	VXORPS	Y0, Y0, Y0
+	VXORPS	Y1, Y1, Y1
+
+	SUBQ	$16, CX
+	JL	avx2_tail8	// There are less than 16 pairs to process
+
+avx2_loop:
+	// Multiply and accumulate sixteen pairs
+	VMOVUPS	(SI), Y2
+	VMOVUPS	32(SI), Y3
+	VFMADD231PS	(DI), Y2, Y0
+	VFMADD231PS	32(DI), Y3, Y1
+
+	ADDQ	$64, SI
+	ADDQ	$64, DI
+
+	SUBQ	$16, CX
+	JGE	avx2_loop
+
+avx2_tail8:
+	ADDQ	$16, CX
+	CMPQ	CX, $8
+	JL	avx2_reduce	// There are less than 8 pairs left
+
+	VMOVUPS	(SI), Y2
+	VFMADD231PS	(DI), Y2, Y0
+
+	ADDQ	$32, SI
+	ADDQ	$32, DI
+	SUBQ	$8, CX
+
+avx2_reduce:
+	// Horizontal sum of eight lanes
+	VADDPS	Y1, Y0, Y0
+	VEXTRACTF128	$1, Y0, X1
+	VADDPS	X1, X0, X0
+	VMOVHLPS	X0, X0, X1
+	VADDPS	X1, X0, X0
+	VMOVSHDUP	X0, X1
+	VADDSS	X1, X0, X0
+
+	TESTQ	CX, CX
+	JE	avx2_end
+
+avx2_loop1:
+	VMOVSS	(SI), X1
+	VFMADD231SS	(DI), X1, X0
+
+	ADDQ	$4, SI
+	ADDQ	$4, DI
+
+	DECQ	CX
+	JNE	avx2_loop1
+
+avx2_end:
+	VZEROUPPER
+	MOVSS	X0, ret+48(FP)
 	RET
@@ -0,0 +1,54 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func sdot4AVX2(q []float32, stride int, y []float32, out *[4]float32)
+TEXT ·sdot4AVX2(SB), NOSPLIT, $0-64
+	MOVQ	q_base+0(FP), SI
+	MOVQ	stride+24(FP), R8
+	MOVQ	y_base+32(FP), DI
+	MOVQ	y_len+40(FP), CX
+	MOVQ	out+56(FP), DX
+
+	// Queries are stride values apart
+	SHLQ	$2, R8
+	LEAQ	(SI)(R8*1), R9
+	LEAQ	(R9)(R8*1), R10
+	LEAQ	(R10)(R8*1), R11
+
+	// One accumulator per query
+	VXORPS	Y0, Y0, Y0
+	VXORPS	Y1, Y1, Y1
+	VXORPS	Y2, Y2, Y2
+	VXORPS	Y3, Y3, Y3
+
+	SHRQ	$3, CX
+	JE	reduce
+
+loop:
+	// Eight values of the row are loaded once for all four queries
+	VMOVUPS	(DI), Y4
+	VFMADD231PS	(SI), Y4, Y0
+	VFMADD231PS	(R9), Y4, Y1
+	VFMADD231PS	(R10), Y4, Y2
+	VFMADD231PS	(R11), Y4, Y3
+
+	ADDQ	$32, DI
+	ADDQ	$32, SI
+	ADDQ	$32, R9
+	ADDQ	$32, R10
+	ADDQ	$32, R11
+
+	DECQ	CX
+	JNE	loop
+
+reduce:
+	// Pairwise sums leave the four dot products in lanes of both halves
+	VHADDPS	Y1, Y0, Y0
+	VHADDPS	Y3, Y2, Y2
+	VHADDPS	Y2, Y0, Y0
+	VEXTRACTF128	$1, Y0, X1
+	VADDPS	X1, X0, X0
+	VMOVUPS	X0, (DX)
+	VZEROUPPER
+	RET
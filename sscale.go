@@ -1,7 +1,7 @@
 package govector
 
-func Sscale(a float32, X []float32) {
+func sscale(alpha float32, X []float32) {
 	for i := range X {
-		X[i] *= a
+		X[i] *= alpha
 	}
 }
@@ -1,47 +1,99 @@
-//func Sscale(alpha float32, X []float32)
-TEXT ·Sscale(SB), 7, $0
-	MOVSS	alpha+0(FP), X0
-	MOVQ	X_data+8(FP), SI
-	MOVQ	X_len+16(FP), BP
+//go:build !purego
+
+#include "textflag.h"
+
+// func Sscale(alpha float32, X []float32)
+TEXT ·Sscale(SB), NOSPLIT, $0-32
+	MOVQ	X_base+8(FP), SI
+	MOVQ	X_len+16(FP), CX
 
-	SUBQ	$4, BP
-	JL		rest	// There are less than 4 pairs to process
+	CMPB	·useAVX2(SB), $1
+	JE	avx2
 
 	// Setup four alphas in X0
+	MOVSS	alpha+0(FP), X0
 	SHUFPS	$0, X0, X0
-	simd_loop:
-		//PREFETCHNTA (128*8)(SI)
-		// Load four pairs and scale
-		MOVUPS	(SI), X1
-		MULPS	X0, X1
-		// Save result
-		MOVUPS	X1, (SI)
 
-		// Update data pointers
-		ADDQ	$16, SI
+	SUBQ	$4, CX
+	JL	rest	// There are less than 4 values to process
+
+simd_loop:
+	// Load four values and scale
+	MOVUPS	(SI), X1
+	MULPS	X0, X1
+	// Save result
+	MOVUPS	X1, (SI)
+
+	// Update data pointers
+	ADDQ	$16, SI
 
-		SUBQ	$4, BP
-		JGE		simd_loop	// There are 4 or more pairs to process
-	JMP	rest
+	SUBQ	$4, CX
+	JGE	simd_loop	// There are 4 or more values to process
 
 rest:
 	// Undo last SUBQ
-	ADDQ	$4,	BP
+	ADDQ	$4, CX
 	// Check that are there any value to process
 	JE	end
-	loop:
-		// Load from X and scale
-		MOVSS	(SI), X1
-		MULSS	X0, X1
-		// Save
-		MOVSS	X1, (SI)
-
-		// Update data pointers
-		ADDQ	$4, SI
-
-		DECQ	BP
-		JNE	loop
-	RET
+
+loop:
+	// Load from X and scale
+	MOVSS	(SI), X1
+	MULSS	X0, X1
+	// Save
+	MOVSS	X1, (SI)
+
+	// Update data pointers
+	ADDQ	$4, SI
+
+	DECQ	CX
+	JNE	loop
 
 end:
 	RET
+
+avx2:
+	// Setup eight alphas in Y0
+	VBROADCASTSS	alpha+0(FP), Y0
+
+	SUBQ	$16, CX
+	JL	avx2_tail8	// There are less than 16 values to process
+
+avx2_loop:
+	VMULPS	(SI), Y0, Y1
+	VMULPS	32(SI), Y0, Y2
+	VMOVUPS	Y1, (SI)
+	VMOVUPS	Y2, 32(SI)
+
+	ADDQ	$64, SI
+
+	SUBQ	$16, CX
+	JGE	avx2_loop
+
+avx2_tail8:
+	ADDQ	$16, CX
+	CMPQ	CX, $8
+	JL	avx2_tail1	// There are less than 8 values left
+
+	VMULPS	(SI), Y0, Y1
+	VMOVUPS	Y1, (SI)
+
+	ADDQ	$32, SI
+	SUBQ	$8, CX
+
+avx2_tail1:
+	TESTQ	CX, CX
+	JE	avx2_end
+
+avx2_loop1:
+	VMULSS	(SI), X0, X1
+	VMOVSS	X1, (SI)
+
+	ADDQ	$4, SI
+
+	DECQ	CX
+	JNE	avx2_loop1
+
+avx2_end:
+	VZEROUPPER
+	RET
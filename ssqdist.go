@@ -0,0 +1,9 @@
+package govector
+
+func ssqdist(X, Y []float32) (dist float32) {
+	for i, x := range X {
+		d := x - Y[i]
+		dist += d * d
+	}
+	return
+}
@@ -0,0 +1,132 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func Ssqdist(X, Y []float32) float32
+TEXT ·Ssqdist(SB), NOSPLIT, $0-52
+	MOVQ	X_base+0(FP), SI
+	MOVQ	X_len+8(FP), CX
+	MOVQ	Y_base+24(FP), DI
+
+	CMPB	·useAVX2(SB), $1
+	JE	avx2
+
+	// Clear accumulator
+	XORPS	X0, X0
+
+	SUBQ	$4, CX
+	JL	rest	// There are less than 4 pairs to process
+
+simd_loop:
+	// Square difference of four pairs
+	MOVUPS	(SI), X1
+	MOVUPS	(DI), X2
+	SUBPS	X2, X1
+	MULPS	X1, X1
+
+	// Update data pointers
+	ADDQ	$16, SI
+	ADDQ	$16, DI
+
+	// Accumulate
+	ADDPS	X1, X0
+
+	SUBQ	$4, CX
+	JGE	simd_loop	// There are 4 or more pairs to process
+
+	// Horizontal sum
+	MOVHLPS	X0, X1
+	ADDPS	X0, X1
+	MOVSS	X1, X0
+	SHUFPS	$0xe1, X1, X1
+	ADDSS	X1, X0
+
+rest:
+	// Undo last SUBQ
+	ADDQ	$4, CX
+	// Check that are there any value to process
+	JE	end
+
+loop:
+	// Square difference of one pair
+	MOVSS	(SI), X1
+	SUBSS	(DI), X1
+	MULSS	X1, X1
+
+	// Update data pointers
+	ADDQ	$4, SI
+	ADDQ	$4, DI
+
+	// Accumulate
+	ADDSS	X1, X0
+
+	DECQ	CX
+	JNE	loop
+
+end:
+	MOVSS	X0, ret+48(FP)
+	RET
+
+avx2:
+	// Two independent accumulators hide FMA latency
+	VXORPS	Y0, Y0, Y0
+	VXORPS	Y1, Y1, Y1
+
+	SUBQ	$16, CX
+	JL	avx2_tail8	// There are less than 16 pairs to process
+
+avx2_loop:
+	VMOVUPS	(SI), Y2
+	VMOVUPS	32(SI), Y3
+	VSUBPS	(DI), Y2, Y2
+	VSUBPS	32(DI), Y3, Y3
+	VFMADD231PS	Y2, Y2, Y0
+	VFMADD231PS	Y3, Y3, Y1
+
+	ADDQ	$64, SI
+	ADDQ	$64, DI
+
+	SUBQ	$16, CX
+	JGE	avx2_loop
+
+avx2_tail8:
+	ADDQ	$16, CX
+	CMPQ	CX, $8
+	JL	avx2_reduce	// There are less than 8 pairs left
+
+	VMOVUPS	(SI), Y2
+	VSUBPS	(DI), Y2, Y2
+	VFMADD231PS	Y2, Y2, Y0
+
+	ADDQ	$32, SI
+	ADDQ	$32, DI
+	SUBQ	$8, CX
+
+avx2_reduce:
+	// Horizontal sum of eight lanes
+	VADDPS	Y1, Y0, Y0
+	VEXTRACTF128	$1, Y0, X1
+	VADDPS	X1, X0, X0
+	VMOVHLPS	X0, X0, X1
+	VADDPS	X1, X0, X0
+	VMOVSHDUP	X0, X1
+	VADDSS	X1, X0, X0
+
+	TESTQ	CX, CX
+	JE	avx2_end
+
+avx2_loop1:
+	VMOVSS	(SI), X1
+	VSUBSS	(DI), X1, X1
+	VFMADD231SS	X1, X1, X0
+
+	ADDQ	$4, SI
+	ADDQ	$4, DI
+
+	DECQ	CX
+	JNE	avx2_loop1
+
+avx2_end:
+	VZEROUPPER
+	MOVSS	X0, ret+48(FP)
+	RET
@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"log"
+	"unsafe"
 
 	"github.com/vseledkin/govector/mmap"
 )
 
+// headerSize is the size of the file header, four counts of vectors
+// followed by an unused value
+const headerSize = 5 * 4
+
 type Store struct {
 	vectors    *mmap.ReaderAt
 	index      map[string]uint32
@@ -18,6 +24,10 @@ type Store struct {
 	NGramCount uint32
 	WGramCount uint32
 	TotalCount uint32
+	// Matrix is the vector section of the file viewed in place without
+	// copying, row i holds the vector of the key with index i. It is backed
+	// by a read only mapping and must never be written to.
+	Matrix []float32
 }
 
 func (s *Store) Open(name string) (e error) {
@@ -51,8 +61,9 @@ func (s *Store) Open(name string) (e error) {
 		log.Println(e)
 	}
 	println(s.NGramCount)
+	s.Matrix = matrixView(s.vectors.Data, s.TotalCount)
 	var offset int64
-	offset, e = s.Reader.Seek(4*4+int64(s.TotalCount)*128*4+4, 0)
+	offset, e = s.Reader.Seek(headerSize+int64(s.TotalCount)*128*4, 0)
 	println("Offset:", offset)
 
 	//	reader = io.NewSectionReader(s.vectors, 4*4+int64(s.TotalCount)*128*4, int64(s.vectors.Len()))
@@ -86,7 +97,23 @@ func (s *Store) Open(name string) (e error) {
 	return
 }
 
+// Checksum returns CRC-32C of the whole model file
+func (s *Store) Checksum() uint32 {
+	return crc32.Checksum(s.vectors.Data, crc32.MakeTable(crc32.Castagnoli))
+}
+
 func (s *Store) Close() (e error) {
+	s.Matrix = nil
 	e = s.vectors.Close()
 	return
 }
+
+// matrixView reinterprets the vector section of a mapped file as float32
+// values, it relies on the host being little endian like the file format
+func matrixView(data []byte, count uint32) []float32 {
+	size := int(count) * 128
+	if count == 0 || len(data) < headerSize+size*4 {
+		return nil
+	}
+	return unsafe.Slice((*float32)(unsafe.Pointer(&data[headerSize])), size)
+}
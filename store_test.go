@@ -0,0 +1,42 @@
+package govector
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestRow(t *testing.T) {
+	var words []string
+	for i := 0; i < 50; i++ {
+		words = append(words, fmt.Sprintf("word%d", i))
+	}
+	m := openModel(t, writeModel(t, words, 3))
+	defer m.Close()
+
+	// writeModel writes vectors in the order of words
+	r := rand.New(rand.NewSource(3))
+	for i, word := range words {
+		want := make([]float32, 128)
+		for j := range want {
+			want[j] = float32(r.NormFloat64())
+		}
+		id := m.WordID(word)
+		if id != int32(i) {
+			t.Fatalf("%s want id %d got %d", word, i, id)
+		}
+		stored, ok, e := m.llget([]byte("0" + word))
+		if e != nil || !ok {
+			t.Fatalf("%s not read: %v", word, e)
+		}
+		row := m.Row(id)
+		for j := range want {
+			if row[j] != want[j] {
+				t.Fatalf("%s row value %d want %f got %f", word, j, want[j], row[j])
+			}
+			if stored[j] != want[j] {
+				t.Fatalf("%s read value %d want %f got %f", word, j, want[j], stored[j])
+			}
+		}
+	}
+}
@@ -1,46 +1,103 @@
-//func Sxpy(X []float32, Y []float32)
-TEXT ·Sxpy(SB), 7, $0
-	MOVQ	X_data+0(FP), SI
-	MOVQ	X_len+8(FP), BP
-	MOVQ	Y_data+24(FP), DI
-
-	SUBQ	$4, BP
-	JL		rest	// There are less than 4 pairs to process
-	simd_loop:
-		// Load four pairs and scale
-		MOVUPS	(SI), X2
-		MOVUPS	(DI), X3
-		// Save sum
-		ADDPS	X2, X3
-		MOVUPS	X3, (DI)
-
-		// Update data pointers
-		ADDQ	$16, SI
-		ADDQ	$16, DI
-
-		SUBQ	$4, BP
-		JGE		simd_loop	// There are 4 or more pairs to process
-	JMP	rest
+//go:build !purego
+
+#include "textflag.h"
+
+// func Sxpy(X, Y []float32)
+TEXT ·Sxpy(SB), NOSPLIT, $0-48
+	MOVQ	X_base+0(FP), SI
+	MOVQ	X_len+8(FP), CX
+	MOVQ	Y_base+24(FP), DI
+
+	CMPB	·useAVX2(SB), $1
+	JE	avx2
+
+	SUBQ	$4, CX
+	JL	rest	// There are less than 4 pairs to process
+
+simd_loop:
+	// Load four pairs
+	MOVUPS	(SI), X2
+	MOVUPS	(DI), X3
+	// Save sum
+	ADDPS	X2, X3
+	MOVUPS	X3, (DI)
+
+	// Update data pointers
+	ADDQ	$16, SI
+	ADDQ	$16, DI
+
+	SUBQ	$4, CX
+	JGE	simd_loop	// There are 4 or more pairs to process
 
 rest:
 	// Undo last SUBQ
-	ADDQ	$4,	BP
+	ADDQ	$4, CX
 	// Check that are there any value to process
 	JE	end
-	loop:
-		// Load from X and scale
-		MOVSS	(SI), X2
-		// Save sum in Y
-		ADDSS	(DI), X2
-		MOVSS	X2, (DI)
-
-		// Update data pointers
-		ADDQ	$4, SI
-		ADDQ	$4, DI
-
-		DECQ	BP
-		JNE	loop
-	RET
+
+loop:
+	// Load from X
+	MOVSS	(SI), X2
+	// Save sum in Y
+	ADDSS	(DI), X2
+	MOVSS	X2, (DI)
+
+	// Update data pointers
+	ADDQ	$4, SI
+	ADDQ	$4, DI
+
+	DECQ	CX
+	JNE	loop
 
 end:
 	RET
+
+avx2:
+	SUBQ	$16, CX
+	JL	avx2_tail8	// There are less than 16 pairs to process
+
+avx2_loop:
+	VMOVUPS	(SI), Y0
+	VMOVUPS	32(SI), Y1
+	VADDPS	(DI), Y0, Y0
+	VADDPS	32(DI), Y1, Y1
+	VMOVUPS	Y0, (DI)
+	VMOVUPS	Y1, 32(DI)
+
+	ADDQ	$64, SI
+	ADDQ	$64, DI
+
+	SUBQ	$16, CX
+	JGE	avx2_loop
+
+avx2_tail8:
+	ADDQ	$16, CX
+	CMPQ	CX, $8
+	JL	avx2_tail1	// There are less than 8 pairs left
+
+	VMOVUPS	(SI), Y0
+	VADDPS	(DI), Y0, Y0
+	VMOVUPS	Y0, (DI)
+
+	ADDQ	$32, SI
+	ADDQ	$32, DI
+	SUBQ	$8, CX
+
+avx2_tail1:
+	TESTQ	CX, CX
+	JE	avx2_end
+
+avx2_loop1:
+	VMOVSS	(SI), X0
+	VADDSS	(DI), X0, X0
+	VMOVSS	X0, (DI)
+
+	ADDQ	$4, SI
+	ADDQ	$4, DI
+
+	DECQ	CX
+	JNE	avx2_loop1
+
+avx2_end:
+	VZEROUPPER
+	RET